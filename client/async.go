@@ -0,0 +1,66 @@
+package client
+
+import "context"
+
+// Future is the result of an asynchronous call
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// async runs the call in a goroutine
+func async[T any](call func() (T, error)) *Future[T] {
+	f := &Future[T]{done: make(chan struct{})}
+	go func() {
+		defer close(f.done)
+		f.value, f.err = call()
+	}()
+	return f
+}
+
+// Done is closed once the result is available
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the result of the call, or the context error if the context
+// is done first. The call itself is bounded by the context it was started
+// with.
+func (f *Future[T]) Wait(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// AddAsync is Add without waiting for the result
+func (c *Client) AddAsync(ctx context.Context, key, value string) *Future[struct{}] {
+	return async(func() (struct{}, error) {
+		return struct{}{}, c.Add(ctx, key, value)
+	})
+}
+
+// GetAsync is Get without waiting for the result
+func (c *Client) GetAsync(ctx context.Context, key string) *Future[string] {
+	return async(func() (string, error) {
+		return c.Get(ctx, key)
+	})
+}
+
+// DeleteAsync is Delete without waiting for the result
+func (c *Client) DeleteAsync(ctx context.Context, key string) *Future[struct{}] {
+	return async(func() (struct{}, error) {
+		return struct{}{}, c.Delete(ctx, key)
+	})
+}
+
+// GetAllAsync is GetAll without waiting for the result
+func (c *Client) GetAllAsync(ctx context.Context) *Future[[]KeyValue] {
+	return async(func() ([]KeyValue, error) {
+		return c.GetAll(ctx)
+	})
+}
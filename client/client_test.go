@@ -0,0 +1,377 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/queue"
+	"eoracle-client-server/internal/server"
+	"eoracle-client-server/internal/storage"
+)
+
+type nopOutput struct{}
+
+func (nopOutput) Write(data string) {}
+func (nopOutput) Close() error      { return nil }
+
+// startServers runs one server per shard over memory queues and returns a
+// client for them
+func startServers(t *testing.T, config Config, serverConfig server.Config) *Client {
+	t.Helper()
+	if serverConfig.ReadPool.Size == 0 {
+		serverConfig.ReadPool.Size = 2
+	}
+	if serverConfig.WritePool.Size == 0 {
+		serverConfig.WritePool.Size = 1
+	}
+
+	queues := make(map[string]shardQueues)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	names := shardNames(config.Shards)
+	for _, shard := range names {
+		readQueue, writeQueue := queue.NewMemoryQueue(0), queue.NewMemoryQueue(0)
+		srv, err := server.NewServer(readQueue, writeQueue, serverConfig, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+		if err != nil {
+			t.Fatalf("NewServer() error = %v", err)
+		}
+		go func() {
+			srv.Start(ctx)
+			done <- struct{}{}
+		}()
+		queues[shard] = shardQueues{read: readQueue, write: writeQueue}
+	}
+	t.Cleanup(func() {
+		cancel()
+		for range names {
+			<-done
+		}
+	})
+
+	client, err := newClient(queues, config)
+	if err != nil {
+		t.Fatalf("newClient() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClient_AddGetDelete(t *testing.T) {
+	client := startServers(t, Config{}, server.Config{})
+	ctx := context.Background()
+
+	if err := client.Add(ctx, "a", "1"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := client.Add(ctx, "b", "2"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if value, err := client.Get(ctx, "a"); err != nil || value != "1" {
+		t.Errorf("Get(a) = %q, %v, want 1", value, err)
+	}
+	if err := client.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := client.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(a) error = %v, want %v", err, ErrNotFound)
+	}
+	if err := client.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete(missing) error = %v", err)
+	}
+
+	items, err := client.GetAll(ctx)
+	if want := []KeyValue{{"b", "2"}}; err != nil || !reflect.DeepEqual(items, want) {
+		t.Errorf("GetAll() = %v, %v, want %v", items, err, want)
+	}
+
+	prices := client.Namespace("prices")
+	if err := prices.Add(ctx, "b", "3"); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if value, err := client.Get(ctx, "b"); err != nil || value != "2" {
+		t.Errorf("Get(b) = %q, %v, want 2", value, err)
+	}
+	if value, err := prices.Get(ctx, "b"); err != nil || value != "3" {
+		t.Errorf("Namespace(prices).Get(b) = %q, %v, want 3", value, err)
+	}
+}
+
+func TestClient_Batch(t *testing.T) {
+	for _, shards := range []int{0, 3} {
+		client := startServers(t, Config{Shards: shards}, server.Config{})
+		ctx := context.Background()
+
+		items := []KeyValue{{"a", "1"}, {"b", "2"}, {"c", "3"}, {"d", "4"}, {"e", "5"}}
+		if err := client.AddMany(ctx, items); err != nil {
+			t.Fatalf("AddMany() error = %v", err)
+		}
+		got, err := client.GetMany(ctx, "e", "missing", "a", "c", "a")
+		if want := []KeyValue{{"e", "5"}, {"a", "1"}, {"c", "3"}}; err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%d shards: GetMany() = %v, %v, want %v", shards, got, err, want)
+		}
+
+		if err := client.DeleteMany(ctx, "a", "b"); err != nil {
+			t.Fatalf("DeleteMany() error = %v", err)
+		}
+		all, err := client.GetAll(ctx)
+		if err != nil {
+			t.Fatalf("GetAll() error = %v", err)
+		}
+		if len(all) != 3 {
+			t.Errorf("%d shards: GetAll() = %v, want c, d and e", shards, all)
+		}
+		for _, item := range all {
+			if item.Key == "a" || item.Key == "b" {
+				t.Errorf("%d shards: GetAll() = %v, want a and b deleted", shards, all)
+			}
+		}
+	}
+}
+
+func TestClient_Async(t *testing.T) {
+	client := startServers(t, Config{}, server.Config{})
+	ctx := context.Background()
+
+	if _, err := client.AddAsync(ctx, "a", "1").Wait(ctx); err != nil {
+		t.Fatalf("AddAsync() error = %v", err)
+	}
+	get := client.GetAsync(ctx, "a")
+	<-get.Done()
+	if value, err := get.Wait(ctx); err != nil || value != "1" {
+		t.Errorf("GetAsync(a) = %q, %v, want 1", value, err)
+	}
+	if _, err := client.DeleteAsync(ctx, "a").Wait(ctx); err != nil {
+		t.Fatalf("DeleteAsync() error = %v", err)
+	}
+	if items, err := client.GetAllAsync(ctx).Wait(ctx); err != nil || len(items) != 0 {
+		t.Errorf("GetAllAsync() = %v, %v, want no items", items, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.GetAsync(ctx, "a").Wait(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	client := startServers(t, Config{Timeout: 50 * time.Millisecond, Limits: &Limits{MaxValueSize: 4}}, server.Config{
+		Limits: &commands.Limits{MaxKeySize: 8},
+	})
+	ctx := context.Background()
+
+	var clientErr *Error
+	if err := client.Add(ctx, "has space", "1"); !errors.As(err, &clientErr) || clientErr.Code != string(commands.CodeInvalidKey) {
+		t.Errorf("Add(has space) error = %v, want code %s", err, commands.CodeInvalidKey)
+	}
+	if err := client.Add(ctx, "key-over-limit", "1"); !errors.As(err, &clientErr) || clientErr.Code != string(commands.CodeKeyTooLong) {
+		t.Errorf("Add(key-over-limit) error = %v, want code %s", err, commands.CodeKeyTooLong)
+	}
+	// Values are checked with the limits of the client before sending
+	if err := client.Add(ctx, "a", "12345"); !errors.As(err, &clientErr) || clientErr.Code != string(commands.CodeValueTooLong) {
+		t.Errorf("Add(long value) error = %v, want code %s", err, commands.CodeValueTooLong)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := client.Get(ctx, "a"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestClient_Routing(t *testing.T) {
+	readQueue, writeQueue := queue.NewMemoryQueue(0), queue.NewMemoryQueue(0)
+	srv, err := server.NewServer(readQueue, nil, server.Config{
+		ReadPool:  server.PoolConfig{Size: 1},
+		WritePool: server.PoolConfig{Size: 1},
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client, err := newClient(map[string]shardQueues{"": {read: readQueue, write: writeQueue}}, Config{Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("newClient() error = %v", err)
+	}
+	defer client.Close()
+
+	// Only the read queue has a server
+	if _, err := client.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := client.Add(ctx, "a", "1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Add() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if depth, _ := writeQueue.Depth(); depth != 1 {
+		t.Errorf("write queue Depth() = %d, want 1", depth)
+	}
+}
+
+func TestClient_Do(t *testing.T) {
+	for _, shards := range []int{0, 3} {
+		client := startServers(t, Config{Shards: shards}, server.Config{}).Namespace("prices")
+		ctx := context.Background()
+
+		if _, err := client.Do(ctx, "add a 1"); err != nil {
+			t.Fatalf("%d shards: Do(add) error = %v", shards, err)
+		}
+		result, err := client.Do(ctx, "incrby a 41")
+		if want := []KeyValue{{"a", "42"}}; err != nil || !reflect.DeepEqual(result.Items, want) || result.Output != "" {
+			t.Errorf("%d shards: Do(incrby) = %+v, %v, want items %v", shards, result, err, want)
+		}
+		// nsinfo is broadcast and every shard with the namespace answers
+		result, err = client.Do(ctx, "nsinfo prices")
+		if want := "prices: 1 items\n"; err != nil || result.Output != want || len(result.Items) != 0 {
+			t.Errorf("%d shards: Do(nsinfo) = %+v, %v, want output %q", shards, result, err, want)
+		}
+
+		var clientErr *Error
+		if _, err := client.Do(ctx, "frobnicate a"); !errors.As(err, &clientErr) {
+			t.Errorf("%d shards: Do(frobnicate) error = %v, want *Error", shards, err)
+		}
+	}
+}
+
+// fakeExchange sends the events to every subscriber
+type fakeExchange struct {
+	events []storage.ChangeEvent
+	closed chan struct{}
+}
+
+func (e *fakeExchange) SubscribeChanges(ctx context.Context, handler func(storage.ChangeEvent)) error {
+	for _, event := range e.events {
+		handler(event)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (e *fakeExchange) Close() error {
+	close(e.closed)
+	return nil
+}
+
+func TestClient_Watch(t *testing.T) {
+	client := startServers(t, Config{Shards: 2, ChangeExchange: "changes"}, server.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := client.Namespace("prices").Watch(ctx, "[", nil); err == nil {
+		t.Error("Watch() with invalid pattern error = nil")
+	}
+
+	exchanges := make(map[string]*fakeExchange)
+	client.conn.openExchange = func(name string) (changeSource, error) {
+		exchanges[name] = &fakeExchange{
+			events: []storage.ChangeEvent{
+				{Op: storage.OpAdd, Namespace: "prices", Key: "feed:" + name},
+				{Op: storage.OpAdd, Namespace: "prices", Key: "user:1"},
+				{Op: storage.OpAdd, Namespace: "default", Key: "feed:" + name},
+			},
+			closed: make(chan struct{}),
+		}
+		return exchanges[name], nil
+	}
+	var keys []string
+	err := client.Namespace("prices").Watch(ctx, "feed:*", func(event ChangeEvent) {
+		if event.Op != OpAdd {
+			t.Errorf("Watch() handled op %q, want %q", event.Op, OpAdd)
+		}
+		keys = append(keys, event.Key)
+		if len(keys) == 2 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	sort.Strings(keys)
+	if want := []string{"feed:changes.shard-0", "feed:changes.shard-1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Watch() handled %v, want %v", keys, want)
+	}
+	for name, exchange := range exchanges {
+		select {
+		case <-exchange.closed:
+		default:
+			t.Errorf("exchange %s was not closed", name)
+		}
+	}
+
+	unwatched := startServers(t, Config{}, server.Config{})
+	if err := unwatched.Watch(context.Background(), "*", nil); !errors.Is(err, ErrNoChangeExchange) {
+		t.Errorf("Watch() without exchange error = %v, want %v", err, ErrNoChangeExchange)
+	}
+}
+
+func TestClient_GetPage(t *testing.T) {
+	for _, shards := range []int{0, 3} {
+		client := startServers(t, Config{Shards: shards}, server.Config{})
+		ctx := context.Background()
+
+		var want []string
+		for i := 0; i < 10; i++ {
+			key := "key" + strconv.Itoa(i)
+			want = append(want, key)
+			if err := client.Add(ctx, key, "value"); err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+		}
+
+		var got []string
+		cursor, pages := "", 0
+		for {
+			items, next, err := client.GetPage(ctx, cursor, 3)
+			if err != nil {
+				t.Fatalf("%d shards: GetPage(%q) error = %v", shards, cursor, err)
+			}
+			if len(items) == 0 || len(items) > 3 {
+				t.Errorf("%d shards: GetPage(%q) returned %d items", shards, cursor, len(items))
+			}
+			for _, item := range items {
+				got = append(got, item.Key)
+			}
+			pages++
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) || pages != 4 {
+			t.Errorf("%d shards: pages returned %v in %d pages, want %v in 4", shards, got, pages, want)
+		}
+
+		// The cursor names the last key of its page
+		items, cursor, err := client.GetPage(ctx, "", 1)
+		if err != nil {
+			t.Fatalf("GetPage() error = %v", err)
+		}
+		if err := client.Delete(ctx, items[0].Key); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		var clientErr *Error
+		for _, cursor := range []string{cursor, "not a cursor", encodeCursor(shards+1, "")} {
+			if _, _, err := client.GetPage(ctx, cursor, 1); !errors.As(err, &clientErr) || clientErr.Code != string(commands.CodeInvalidCursor) {
+				t.Errorf("%d shards: GetPage(%q) error = %v, want code %s", shards, cursor, err, commands.CodeInvalidCursor)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// GetPage returns up to limit pairs in order, starting after the page of
+// cursor or at the front for an empty cursor. next is the cursor of the
+// following page, empty after the last one. With sharding the pages go
+// through the shards one after the other. The cursor of a page whose last
+// key was deleted since fails with code INVALID_CURSOR.
+func (c *Client) GetPage(ctx context.Context, cursor string, limit int) (items []KeyValue, next string, err error) {
+	if limit < 1 {
+		return nil, "", &Error{Message: "page limit must be positive"}
+	}
+	shard, after, ok := decodeCursor(cursor)
+	if !ok || shard >= len(c.conn.shardNames) {
+		return nil, "", &Error{Code: string(commands.CodeInvalidCursor), Message: "invalid page cursor"}
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	items = []KeyValue{}
+	for ; shard < len(c.conn.shardNames); shard, after = shard+1, "" {
+		// One pair more than needed tells whether the shard has more
+		want := limit - len(items)
+		page, err := c.page(ctx, c.conn.shardNames[shard], after, want+1)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(page) > want {
+			items = append(items, page[:want]...)
+			if want > 0 {
+				after = page[want-1].Key
+			}
+			return items, encodeCursor(shard, after), nil
+		}
+		items = append(items, page...)
+	}
+	return items, "", nil
+}
+
+// page requests up to limit pairs after the key from one shard
+func (c *Client) page(ctx context.Context, shard, after string, limit int) ([]KeyValue, error) {
+	args := []string{strconv.Itoa(limit)}
+	if after != "" {
+		args = append(args, after)
+	}
+	cmd, err := c.prepare(commands.NewCommand(commands.GetAllItems, "", "", args...))
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.request(ctx, shard, cmd)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]KeyValue, 0, len(result.Items))
+	for _, item := range result.Items {
+		items = append(items, KeyValue{Key: item.Key, Value: item.Value})
+	}
+	return items, nil
+}
+
+// encodeCursor makes an opaque, URL-safe cursor of the shard index and the
+// key the next page starts after
+func encodeCursor(shard int, after string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(shard) + ":" + after))
+}
+
+func decodeCursor(cursor string) (shard int, after string, ok bool) {
+	if cursor == "" {
+		return 0, "", true
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", false
+	}
+	index, after, found := strings.Cut(string(data), ":")
+	shard, err = strconv.Atoi(index)
+	if !found || err != nil || shard < 0 {
+		return 0, "", false
+	}
+	return shard, after, true
+}
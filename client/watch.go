@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"eoracle-client-server/internal/storage"
+)
+
+// ErrNoChangeExchange is returned by Watch without Config.ChangeExchange
+var ErrNoChangeExchange = errors.New("watch requires a change exchange")
+
+// ChangeOp is the kind of change of a ChangeEvent
+type ChangeOp string
+
+const (
+	OpAdd    ChangeOp = "add"
+	OpUpdate ChangeOp = "update"
+	OpDelete ChangeOp = "delete"
+	OpEvict  ChangeOp = "evict"
+	// OpMove reorders an existing key, see Position and Mark
+	OpMove ChangeOp = "move"
+	// OpDrop removes a whole namespace, the key is empty
+	OpDrop ChangeOp = "drop"
+)
+
+// Position is where an OpMove places its key
+type Position string
+
+const (
+	PositionFront  Position = "front"
+	PositionBack   Position = "back"
+	PositionBefore Position = "before"
+	PositionAfter  Position = "after"
+)
+
+// ChangeEvent is a change of the map published by a server. Version orders
+// the changes of one server within an Epoch.
+type ChangeEvent struct {
+	Op        ChangeOp
+	Namespace string
+	Key       string
+	OldValue  string
+	NewValue  string
+	Position  Position
+	Mark      string
+	Epoch     int64
+	Version   uint64
+	Timestamp time.Time
+}
+
+// changeSource is the change exchange of one shard
+type changeSource interface {
+	SubscribeChanges(ctx context.Context, handler func(storage.ChangeEvent)) error
+	Close() error
+}
+
+// Watch calls handler for the changes of the keys in the namespace of the
+// client matching the glob pattern, until the context is done. With
+// sharding the changes of every shard are watched; handler is not called
+// concurrently.
+func (c *Client) Watch(ctx context.Context, pattern string, handler func(ChangeEvent)) error {
+	if len(c.conn.exchanges) == 0 {
+		return ErrNoChangeExchange
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Events of different shards are handled as they arrive
+	var mu sync.Mutex
+	handle := func(event storage.ChangeEvent) {
+		if !event.Matches(c.namespace, pattern) {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handler(toChangeEvent(event))
+	}
+
+	errs := make(chan error, len(c.conn.exchanges))
+	for _, name := range c.conn.exchanges {
+		exchange, err := c.conn.openExchange(name)
+		if err != nil {
+			cancel()
+			return err
+		}
+		defer exchange.Close()
+
+		go func() {
+			errs <- exchange.SubscribeChanges(ctx, handle)
+		}()
+	}
+
+	var err error
+	for range c.conn.exchanges {
+		if subscribeErr := <-errs; subscribeErr != nil && err == nil {
+			err = subscribeErr
+			cancel()
+		}
+	}
+	return err
+}
+
+func toChangeEvent(event storage.ChangeEvent) ChangeEvent {
+	return ChangeEvent{
+		Op:        ChangeOp(event.Op),
+		Namespace: event.Namespace,
+		Key:       event.Key,
+		OldValue:  event.OldValue,
+		NewValue:  event.NewValue,
+		Position:  Position(event.Position),
+		Mark:      event.Mark,
+		Epoch:     event.Epoch,
+		Version:   event.Version,
+		Timestamp: event.Timestamp,
+	}
+}
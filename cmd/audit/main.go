@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"eoracle-client-server/internal/audit"
+)
+
+func main() {
+	var (
+		logName = flag.String("log", "", "Audit log to verify")
+		head    = flag.String("head", "", "Head kept outside the log as seq:hash, empty for the head file next to the log")
+	)
+	flag.Parse()
+
+	if *logName == "" {
+		log.Fatalf("-log is required")
+	}
+	var expected *audit.Head
+	if *head != "" {
+		parsed, err := audit.ParseHead(*head)
+		if err != nil {
+			log.Fatalf("Invalid head: %v", err)
+		}
+		expected = &parsed
+	}
+
+	verified, err := audit.Verify(*logName, expected)
+	if err != nil {
+		log.Fatalf("Verification failed after seq %d: %v", verified.Seq, err)
+	}
+	fmt.Printf("OK: %d entries, head %s\n", verified.Seq, verified)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"eoracle-client-server/client"
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/config"
+	"eoracle-client-server/internal/gateway"
+	"eoracle-client-server/internal/queue"
+)
+
+func main() {
+	var (
+		listen         = flag.String("listen", "localhost:8080", "Address to serve HTTP on")
+		callersFile    = flag.String("callers-file", "", "Callers file with the token hash and signing key file of every caller, empty to serve without authentication")
+		readQueueName  = flag.String("read-queue-name", "read-commands", "Queue name for read commands")
+		writeQueueName = flag.String("write-queue-name", "write-commands", "Queue name for write commands")
+		shards         = flag.Int("shards", 0, "Number of shards to route commands to, 0 for a single unsharded server")
+		signingKey     = flag.String("signing-key-file", "", "Key file to sign commands with, empty to send unsigned commands")
+		codecName      = flag.String("codec", "json", "Encoding of published commands: json, msgpack or protobuf")
+		compressAbove  = flag.Int("compress-threshold", 0, "Gzip commands of at least this many bytes, 0 to disable")
+		timeout        = flag.Duration("request-timeout", client.DefaultTimeout, "Time to wait for the result of a command")
+		maxBodySize    = flag.Int("max-body-size", 2*commands.DefaultLimits.MaxValueSize, "Maximum PUT body size in bytes, 0 for unlimited")
+		_              = flag.String(config.FileFlag, "", "Config file (.json, .yaml or .toml), overridden by EORACLE_* variables and flags")
+		printConfig    = flag.Bool("print-config", false, "Print the effective configuration and exit")
+	)
+	var brokerConfig queue.BrokerConfig
+	brokerConfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := config.Load(flag.CommandLine, os.Environ())
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	err = cfg.Validate(
+		config.URL("rabbit-url", "amqp", "amqps"),
+		config.NonNegative("shards", "compress-threshold", "max-body-size"),
+		config.File("rabbit-ca-file", "rabbit-cert-file", "rabbit-key-file", "rabbit-password-file", "signing-key-file", "callers-file"),
+	)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if *printConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
+
+	var callers map[string]gateway.Caller
+	if *callersFile != "" {
+		if *signingKey != "" {
+			log.Fatalf("Callers sign with their own keys, -signing-key-file cannot be used with -callers-file")
+		}
+		if callers, err = gateway.LoadCallers(*callersFile); err != nil {
+			log.Fatalf("Failed to load callers: %v", err)
+		}
+	}
+
+	// Every caller has its own connection signing with its key
+	dial := func(signingKeyFile string) *client.Client {
+		c, err := client.Dial(client.Config{
+			Broker:            client.BrokerConfig(brokerConfig),
+			ReadQueue:         *readQueueName,
+			WriteQueue:        *writeQueueName,
+			Shards:            *shards,
+			SigningKeyFile:    signingKeyFile,
+			Codec:             *codecName,
+			CompressThreshold: *compressAbove,
+			Timeout:           *timeout,
+		})
+		if err != nil {
+			log.Fatalf("Failed to connect: %v", err)
+		}
+		return c
+	}
+	clients := make(map[string]*client.Client)
+	if len(callers) == 0 {
+		clients[""] = dial(*signingKey)
+	}
+	for name, caller := range callers {
+		clients[name] = dial(caller.SigningKeyFile)
+	}
+	defer func() {
+		for _, c := range clients {
+			c.Close()
+		}
+	}()
+
+	handler, err := gateway.NewHandler(func(caller, namespace string) gateway.Client {
+		return clients[caller].Namespace(namespace)
+	}, gateway.Config{MaxBodySize: int64(*maxBodySize), Callers: callers})
+	if err != nil {
+		log.Fatalf("Invalid callers: %v", err)
+	}
+	srv := &http.Server{
+		Addr:              *listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Handle shutdown gracefully
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-sigChan
+		log.Println("Shutting down gateway...")
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Failed to shut down gateway: %v", err)
+		}
+	}()
+
+	if len(callers) == 0 {
+		log.Printf("Gateway listening on %s without authentication", *listen)
+	} else {
+		log.Printf("Gateway listening on %s for %d callers", *listen, len(callers))
+	}
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Gateway failed: %v", err)
+	}
+	// Wait for in-flight requests
+	<-stopped
+}
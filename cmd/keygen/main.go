@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"eoracle-client-server/internal/auth"
+)
+
+func main() {
+	var (
+		client    = flag.String("client", "", "Client identity the key belongs to")
+		id        = flag.String("id", "", "Key id, empty for <client>-<date>")
+		algorithm = flag.String("algorithm", string(auth.Ed25519), "Signature algorithm: ed25519 or hmac-sha256")
+		out       = flag.String("out", "", "Signing key file for the client, empty for <id>.json")
+	)
+	flag.Parse()
+
+	if *client == "" {
+		log.Fatalf("-client is required")
+	}
+	if *id == "" {
+		*id = *client + "-" + time.Now().Format("20060102")
+	}
+	if *out == "" {
+		*out = *id + ".json"
+	}
+
+	signing, verification, err := auth.GenerateKey(*client, *id, auth.Algorithm(*algorithm))
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+
+	data, err := json.MarshalIndent(signing, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode signing key: %v", err)
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0600); err != nil {
+		log.Fatalf("Failed to write signing key: %v", err)
+	}
+	log.Printf("Wrote signing key %s to %s, add the entry below to the server keyring", *id, *out)
+
+	// The keyring entry goes to stdout, to be appended to the keyring list
+	data, err = json.MarshalIndent(verification, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode keyring entry: %v", err)
+	}
+	fmt.Println(string(data))
+}
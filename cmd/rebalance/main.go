@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"time"
+
+	"eoracle-client-server/internal/auth"
+	"eoracle-client-server/internal/queue"
+	"eoracle-client-server/internal/replication"
+	"eoracle-client-server/internal/sharding"
+)
+
+func main() {
+	var (
+		writeQueueName = flag.String("write-queue-name", "write-commands", "Base queue name for write commands")
+		changeExchange = flag.String("change-exchange", "map-changes", "Base change exchange the shards serve snapshots on")
+		fromShards     = flag.Int("from-shards", 1, "Current number of shards")
+		toShards       = flag.Int("to-shards", 2, "New number of shards")
+		dryRun         = flag.Bool("dry-run", false, "Only print the planned moves")
+		signingKey     = flag.String("signing-key-file", "", "Key file to sign the moves with, empty to send unsigned commands")
+		timeout        = flag.Duration("request-timeout", 10*time.Second, "Time to wait for the result of each copy and delete")
+	)
+	var brokerConfig queue.BrokerConfig
+	brokerConfig.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if *fromShards < 1 || *toShards < 1 {
+		log.Fatalf("Shard counts must be positive")
+	}
+	broker, err := queue.NewBroker(brokerConfig)
+	if err != nil {
+		log.Fatalf("Invalid RabbitMQ settings: %v", err)
+	}
+
+	var signer *auth.Signer
+	if *signingKey != "" {
+		key, err := auth.ReadKey(*signingKey)
+		if err != nil {
+			log.Fatalf("Failed to read signing key: %v", err)
+		}
+		if signer, err = auth.NewSigner(key); err != nil {
+			log.Fatalf("Invalid signing key: %v", err)
+		}
+	}
+
+	ring, err := sharding.NewRing(sharding.ShardNames(*toShards))
+	if err != nil {
+		log.Fatalf("Failed to create ring: %v", err)
+	}
+
+	// Write queues of old and new shards, created on first use
+	writeQueues := make(map[string]queue.Queue)
+	writeQueue := func(shard string) queue.Requester {
+		if q, ok := writeQueues[shard]; ok {
+			return q.(queue.Requester)
+		}
+		q, err := queue.NewRabbitMQQueue(broker, sharding.QueueName(*writeQueueName, shard))
+		if err != nil {
+			log.Fatalf("Failed to create write queue for %s: %v", shard, err)
+		}
+		if signer != nil {
+			q = auth.SignQueue(q, signer)
+		}
+		writeQueues[shard] = q
+		return q.(queue.Requester)
+	}
+	defer func() {
+		for _, q := range writeQueues {
+			q.Close()
+		}
+	}()
+
+	moved, failed := 0, 0
+	for _, shard := range sharding.ShardNames(*fromShards) {
+		snapshot := requestSnapshot(broker, sharding.QueueName(*changeExchange, shard))
+		moves := sharding.PlanMoves(shard, snapshot.Namespaces, ring)
+		log.Printf("Shard %s: %d keys to move", shard, len(moves))
+
+		for _, move := range moves {
+			if *dryRun {
+				log.Printf("Move [%s] %s: %s -> %s", move.Namespace, move.Key, move.From, move.To)
+				continue
+			}
+
+			// The key is deleted only once the new shard applied the copy
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			err := move.Apply(ctx, writeQueue(move.From), writeQueue(move.To))
+			cancel()
+			if err != nil {
+				log.Printf("Failed to move [%s] %s: %v", move.Namespace, move.Key, err)
+				failed++
+				continue
+			}
+			moved++
+		}
+	}
+
+	log.Printf("Rebalanced %d shards into %d shards, %d keys moved", *fromShards, *toShards, moved)
+	if failed > 0 {
+		log.Fatalf("%d keys were not moved, run the rebalancer again once the errors are fixed", failed)
+	}
+}
+
+// requestSnapshot fetches the snapshot of the shard serving on the exchange
+func requestSnapshot(broker *queue.Broker, exchangeName string) replication.Snapshot {
+	exchange, err := queue.NewRabbitMQExchange(broker, exchangeName)
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", exchangeName, err)
+	}
+	defer exchange.Close()
+
+	data, err := exchange.RequestSnapshot(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to request snapshot from %s: %v", exchangeName, err)
+	}
+
+	var snapshot replication.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		log.Fatalf("Failed to parse snapshot from %s: %v", exchangeName, err)
+	}
+	return snapshot
+}
@@ -2,4 +2,15 @@ module eoracle-client-server
 
 go 1.21.13
 
-require github.com/streadway/amqp v1.1.0
+require (
+	github.com/streadway/amqp v1.1.0
+	google.golang.org/grpc v1.67.3
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240814211410-ddb44dafa142 // indirect
+)
@@ -0,0 +1,308 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// headSuffix is appended to the log path for the file holding the head
+const headSuffix = ".head"
+
+// genesis is the previous hash of the first entry
+var genesis = strings.Repeat("0", sha256.Size*2)
+
+// Verification failures
+var (
+	ErrTampered  = errors.New("audit log tampered")
+	ErrTruncated = errors.New("audit log truncated")
+)
+
+// Entry records a write command handled by the server. Seq, Time and Prev
+// are set by the log.
+type Entry struct {
+	Seq       uint64               `json:"seq"`
+	Time      time.Time            `json:"time"`
+	Client    string               `json:"client,omitempty"`
+	Command   commands.CommandType `json:"command"`
+	Namespace string               `json:"namespace,omitempty"`
+	Key       string               `json:"key,omitempty"`
+	Value     string               `json:"value,omitempty"`
+	Args      []string             `json:"args,omitempty"`
+	Changes   []Change             `json:"changes,omitempty"`
+	Result    string               `json:"result"`
+	Prev      string               `json:"prev"`
+}
+
+// NewEntry describes the command, its changes and result
+func NewEntry(cmd commands.Command, changes []Change, err error) Entry {
+	entry := Entry{
+		Command:   cmd.GetType(),
+		Namespace: cmd.GetNamespace(),
+		Key:       cmd.GetKey(),
+		Value:     cmd.GetValue(),
+		Args:      cmd.GetArgs(),
+		Changes:   changes,
+		Result:    "ok",
+	}
+	if signature := cmd.GetAuth(); signature != nil {
+		entry.Client = signature.Client
+	}
+	if err != nil {
+		entry.Result = err.Error()
+	}
+	return entry
+}
+
+// record is a line of the log. The hash covers the exact bytes of the entry,
+// which include the hash of the previous entry.
+type record struct {
+	Entry json.RawMessage `json:"entry"`
+	Hash  string          `json:"hash"`
+}
+
+// Head identifies the last entry of a log. Kept outside the log, e.g. in the
+// server output, it proves that entries were not removed from the end.
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+func (h Head) String() string {
+	return fmt.Sprintf("%d:%s", h.Seq, h.Hash)
+}
+
+// ParseHead parses a head in the seq:hash form of Head.String
+func ParseHead(s string) (Head, error) {
+	seq, hash, ok := strings.Cut(s, ":")
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if !ok || err != nil || len(hash) != len(genesis) {
+		return Head{}, fmt.Errorf("invalid audit log head %q, want seq:hash", s)
+	}
+	return Head{Seq: n, Hash: hash}, nil
+}
+
+// Log is an append-only, hash-chained audit log. Each entry is a JSON line
+// holding the hash of the previous one, so changing or removing an entry
+// breaks the chain. The head is kept next to the log to detect removed
+// trailing entries.
+type Log struct {
+	path string
+	now  func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	head Head
+	err  error // set once an entry may be half written
+}
+
+// Open opens the log for appending, creating it if needed. An existing log
+// must pass verification, the chain continues from its last entry.
+func Open(path string) (*Log, error) {
+	head, err := Verify(path, nil)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Log{path: path, now: time.Now, file: file, head: head}, nil
+}
+
+// Append chains the entry to the log and syncs it to disk before returning,
+// so every audited write waits for an fsync and entries are appended one at
+// a time: the write throughput of an audited server is bounded by the sync
+// rate of the disk. After a failed write or sync the log fails every
+// further entry, since a half written line would break the chain.
+func (l *Log) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+
+	entry.Seq = l.head.Seq + 1
+	entry.Time = l.now().UTC()
+	entry.Prev = l.head.Hash
+	if entry.Prev == "" {
+		entry.Prev = genesis
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	head := Head{Seq: entry.Seq, Hash: hashEntry(data)}
+	line, err := json.Marshal(record{Entry: data, Hash: head.Hash})
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.err = fmt.Errorf("failed to write audit log: %w", err)
+		return l.err
+	}
+	if err := l.file.Sync(); err != nil {
+		l.err = fmt.Errorf("failed to sync audit log: %w", err)
+		return l.err
+	}
+	l.head = head
+	return writeHead(l.path+headSuffix, head)
+}
+
+// Err returns the error that failed the log, nil while entries are written
+func (l *Log) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Head returns the last entry of the log
+func (l *Log) Head() Head {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the chain of the log and returns its head. The log must
+// contain the expected head; a nil head is read from the file next to the
+// log, if any. Use a head kept elsewhere to also detect a rewritten head file.
+func Verify(path string, expected *Head) (Head, error) {
+	if expected == nil {
+		stored, err := readHead(path + headSuffix)
+		if err != nil {
+			return Head{}, err
+		}
+		expected = stored
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && expected != nil && expected.Seq > 0 {
+		return Head{}, fmt.Errorf("%w: log missing, head is seq %d", ErrTruncated, expected.Seq)
+	}
+	if err != nil {
+		return Head{}, err
+	}
+	defer file.Close()
+
+	head := Head{Hash: genesis}
+	found := expected == nil || expected.Seq == 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err == io.EOF {
+			return head, fmt.Errorf("%w: incomplete entry after seq %d", ErrTruncated, head.Seq)
+		}
+		if err != nil {
+			return head, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		next, err := verifyRecord(line, head)
+		if err != nil {
+			return head, err
+		}
+		head = next
+		if expected != nil && head.Seq == expected.Seq {
+			if head.Hash != expected.Hash {
+				return head, fmt.Errorf("%w: seq %d does not match the head", ErrTampered, head.Seq)
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		return head, fmt.Errorf("%w: last seq %d, head is seq %d", ErrTruncated, head.Seq, expected.Seq)
+	}
+	if head.Seq == 0 {
+		head.Hash = ""
+	}
+	return head, nil
+}
+
+// verifyRecord checks that the line is the entry following prev
+func verifyRecord(line []byte, prev Head) (Head, error) {
+	seq := prev.Seq + 1
+	var r record
+	if err := json.Unmarshal(line, &r); err != nil {
+		return prev, fmt.Errorf("%w: unreadable entry at seq %d: %v", ErrTampered, seq, err)
+	}
+	if hashEntry(r.Entry) != r.Hash {
+		return prev, fmt.Errorf("%w: hash mismatch at seq %d", ErrTampered, seq)
+	}
+	var entry Entry
+	if err := json.Unmarshal(r.Entry, &entry); err != nil {
+		return prev, fmt.Errorf("%w: unreadable entry at seq %d: %v", ErrTampered, seq, err)
+	}
+	if entry.Seq != seq || entry.Prev != prev.Hash {
+		return prev, fmt.Errorf("%w: entry %d does not follow seq %d", ErrTampered, entry.Seq, prev.Seq)
+	}
+	return Head{Seq: seq, Hash: r.Hash}, nil
+}
+
+func hashEntry(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readHead reads the head file, nil if there is none
+func readHead(path string) (*Head, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log head: %w", err)
+	}
+	var head Head
+	if err := json.Unmarshal(bytes.TrimSpace(data), &head); err != nil {
+		return nil, fmt.Errorf("%w: unreadable head: %v", ErrTampered, err)
+	}
+	return &head, nil
+}
+
+// writeHead replaces the head file, so it is never seen half written
+func writeHead(path string, head Head) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit log head: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write audit log head: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write audit log head: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write audit log head: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write audit log head: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// writeTestLog appends n entries to a new log and returns its path and head
+func writeTestLog(t *testing.T, n int) (string, Head) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer log.Close()
+
+	for i := 0; i < n; i++ {
+		cmd := commands.NewCommand(commands.AddItem, "key", "value")
+		changes := []Change{{Op: "add", Namespace: "default", Key: "key", NewValue: "value"}}
+		if err := log.Append(NewEntry(cmd, changes, nil)); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	return path, log.Head()
+}
+
+func TestLog_Reopen(t *testing.T) {
+	path, head := writeTestLog(t, 3)
+	if head.Seq != 3 {
+		t.Fatalf("Head().Seq = %d, want 3", head.Seq)
+	}
+
+	// The chain continues after a restart
+	log, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got := log.Head(); got != head {
+		t.Errorf("Head() after Open() = %v, want %v", got, head)
+	}
+	if err := log.Append(NewEntry(commands.NewCommand(commands.DeleteItem, "key", ""), nil, errors.New("not found"))); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	log.Close()
+
+	verified, err := Verify(path, &head)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if verified.Seq != 4 {
+		t.Errorf("Verify().Seq = %d, want 4", verified.Seq)
+	}
+	if _, err := Open(path); err != nil {
+		t.Errorf("Open() of verified log error = %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(lines [][]byte) [][]byte
+		want   error
+	}{
+		{"intact", func(lines [][]byte) [][]byte { return lines }, nil},
+		{"changed value", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"new_value":"value"`), []byte(`"new_value":"forged"`), 1)
+			return lines
+		}, ErrTampered},
+		{"removed entry", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, ErrTampered},
+		{"swapped entries", func(lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, ErrTampered},
+		{"removed last entry", func(lines [][]byte) [][]byte {
+			return lines[:2]
+		}, ErrTruncated},
+		{"incomplete last entry", func(lines [][]byte) [][]byte {
+			lines[2] = lines[2][:len(lines[2])-10]
+			return lines
+		}, ErrTruncated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, _ := writeTestLog(t, 3)
+			data, _ := os.ReadFile(path)
+			lines := bytes.SplitAfter(data, []byte("\n"))
+			os.WriteFile(path, bytes.Join(tt.modify(lines[:3]), nil), 0600)
+
+			_, err := Verify(path, nil)
+			if tt.want == nil && err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify_Head(t *testing.T) {
+	path, head := writeTestLog(t, 2)
+
+	// A rewritten log with a matching head file is caught by the head kept
+	// elsewhere
+	os.Remove(path)
+	os.Remove(path + headSuffix)
+	rewritten, _ := writeTestLog(t, 2)
+	os.Rename(rewritten, path)
+	os.Rename(rewritten+headSuffix, path+headSuffix)
+	if _, err := Verify(path, nil); err != nil {
+		t.Errorf("Verify() of rewritten log error = %v", err)
+	}
+	if _, err := Verify(path, &head); !errors.Is(err, ErrTampered) {
+		t.Errorf("Verify() with kept head error = %v, want %v", err, ErrTampered)
+	}
+
+	os.Remove(path)
+	if _, err := Verify(path, &head); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Verify() of missing log error = %v, want %v", err, ErrTruncated)
+	}
+	if _, err := Open(path); !errors.Is(err, ErrTruncated) {
+		t.Errorf("Open() of missing log with head error = %v, want %v", err, ErrTruncated)
+	}
+
+	parsed, err := ParseHead(head.String())
+	if err != nil || parsed != head {
+		t.Errorf("ParseHead(%s) = %v, %v", head, parsed, err)
+	}
+	if _, err := ParseHead("3"); err == nil {
+		t.Error("Expected error for head without hash")
+	}
+}
+
+func TestLog_FailedAppend(t *testing.T) {
+	log, err := Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	log.Close()
+
+	cmd := commands.NewCommand(commands.AddItem, "key", "value")
+	if err := log.Append(NewEntry(cmd, nil, nil)); err == nil {
+		t.Fatal("Append() to closed log error = nil")
+	}
+	if log.Err() == nil {
+		t.Error("Err() = nil after failed append")
+	}
+	if err := log.Append(NewEntry(cmd, nil, nil)); !errors.Is(err, log.Err()) {
+		t.Errorf("Append() after failure error = %v, want %v", err, log.Err())
+	}
+}
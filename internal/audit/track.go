@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"sync"
+
+	"eoracle-client-server/internal/storage"
+)
+
+// Change is a mutation made by a command, or an eviction it caused. Values
+// are omitted for moves and dropped namespaces.
+type Change struct {
+	Op        storage.ChangeOp `json:"op"`
+	Namespace string           `json:"namespace"`
+	Key       string           `json:"key,omitempty"`
+	OldValue  string           `json:"old_value,omitempty"`
+	NewValue  string           `json:"new_value,omitempty"`
+}
+
+// Tracker records the changes a single command makes through it, including
+// the evictions they cause. Changes are reported by the storage under its
+// write lock, so old values are exactly those replaced. Changes of storages
+// that are not storage.Observable are not recorded.
+type Tracker struct {
+	spaces storage.Namespaces
+
+	mu      sync.Mutex
+	changes []Change
+}
+
+// Track wraps the namespaces to record the changes of one command
+func Track(spaces storage.Namespaces) *Tracker {
+	return &Tracker{spaces: spaces}
+}
+
+// Changes returns the recorded changes in the order they were made
+func (t *Tracker) Changes() []Change {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Change(nil), t.changes...)
+}
+
+// Namespace returns the storage of the namespace, recording its mutations
+func (t *Tracker) Namespace(name string) storage.Storage {
+	return t.observe(t.spaces.Namespace(name))
+}
+
+// Lookup returns the storage of an existing namespace, recording its mutations
+func (t *Tracker) Lookup(name string) (storage.Storage, bool) {
+	store, exists := t.spaces.Lookup(name)
+	if !exists {
+		return nil, false
+	}
+	return t.observe(store), true
+}
+
+// Drop removes the namespace and records the drop
+func (t *Tracker) Drop(name string) bool {
+	if !t.spaces.Drop(name) {
+		return false
+	}
+	t.record(Change{Op: storage.OpDrop, Namespace: name})
+	return true
+}
+
+// Names returns the names of all existing namespaces
+func (t *Tracker) Names() []string {
+	return t.spaces.Names()
+}
+
+func (t *Tracker) observe(store storage.Storage) storage.Storage {
+	observable, ok := store.(storage.Observable)
+	if !ok {
+		return store
+	}
+	return observable.Observe(func(event storage.ChangeEvent) {
+		change := Change{Op: event.Op, Namespace: event.Namespace, Key: event.Key}
+		if event.Op != storage.OpMove {
+			change.OldValue, change.NewValue = event.OldValue, event.NewValue
+		}
+		t.record(change)
+	})
+}
+
+func (t *Tracker) record(change Change) {
+	if change.Namespace == "" {
+		change.Namespace = storage.DefaultNamespace
+	}
+	t.mu.Lock()
+	t.changes = append(t.changes, change)
+	t.mu.Unlock()
+}
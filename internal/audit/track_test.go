@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	"eoracle-client-server/internal/storage"
+)
+
+func TestTracker(t *testing.T) {
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	spaces.Namespace("prices").Add("btc", "1")
+
+	tracker := Track(spaces)
+	store := tracker.Namespace("prices")
+	store.Add("btc", "2")
+	store.Add("eth", "3")
+	store.MoveToFront("eth")
+	store.InsertAfter("eth", "sol", "4")
+	store.Delete("btc")
+	store.Delete("missing")
+	store.PopLast()
+	tracker.Drop("prices")
+
+	want := []Change{
+		{Op: storage.OpUpdate, Namespace: "prices", Key: "btc", OldValue: "1", NewValue: "2"},
+		{Op: storage.OpAdd, Namespace: "prices", Key: "eth", NewValue: "3"},
+		{Op: storage.OpMove, Namespace: "prices", Key: "eth"},
+		{Op: storage.OpAdd, Namespace: "prices", Key: "sol", NewValue: "4"},
+		{Op: storage.OpMove, Namespace: "prices", Key: "sol"},
+		{Op: storage.OpDelete, Namespace: "prices", Key: "btc", OldValue: "2"},
+		{Op: storage.OpDelete, Namespace: "prices", Key: "sol", OldValue: "4"},
+		{Op: storage.OpDrop, Namespace: "prices"},
+	}
+	if got := tracker.Changes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTracker_Evictions(t *testing.T) {
+	spaces := storage.NewNamespaces(storage.Limits{MaxEntries: 2, Policy: storage.EvictLRU}, nil)
+	spaces.Namespace("").Add("a", "1")
+	spaces.Namespace("").Add("b", "2")
+
+	tracker := Track(spaces)
+	store := tracker.Namespace("")
+	store.Delete("missing")
+	store.Add("c", "3")
+
+	want := []Change{
+		{Op: storage.OpEvict, Namespace: "default", Key: "a", OldValue: "1"},
+		{Op: storage.OpAdd, Namespace: "default", Key: "c", NewValue: "3"},
+	}
+	if got := tracker.Changes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Algorithm is a signature algorithm
+type Algorithm string
+
+const (
+	// HMACSHA256 signs with a secret shared by the client and the servers
+	HMACSHA256 Algorithm = "hmac-sha256"
+	// Ed25519 signs with a private key only the client holds
+	Ed25519 Algorithm = "ed25519"
+)
+
+// hmacKeySize is the size of generated HMAC secrets
+const hmacKeySize = 32
+
+// Key is a signing key of a client or a verification key in a keyring
+type Key struct {
+	ID        string    `json:"id"`
+	Client    string    `json:"client"`
+	Algorithm Algorithm `json:"algorithm"`
+	// Key is the HMAC secret, the Ed25519 private key seed of a client or
+	// the Ed25519 public key in a keyring
+	Key []byte `json:"key"`
+	// Expires retires a rotated key, nil for no expiry
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// validate checks the key size for the algorithm. Signing keys hold an
+// Ed25519 seed, verification keys a public key.
+func (k Key) validate(signing bool) error {
+	if k.ID == "" || k.Client == "" {
+		return errors.New("key id and client are required")
+	}
+
+	switch k.Algorithm {
+	case HMACSHA256:
+		if len(k.Key) < 16 {
+			return fmt.Errorf("key %s: HMAC secret must have at least 16 bytes", k.ID)
+		}
+	case Ed25519:
+		size := ed25519.PublicKeySize
+		if signing {
+			size = ed25519.SeedSize
+		}
+		if len(k.Key) != size {
+			return fmt.Errorf("key %s: Ed25519 key must have %d bytes, got %d", k.ID, size, len(k.Key))
+		}
+	default:
+		return fmt.Errorf("key %s: unknown algorithm %q", k.ID, k.Algorithm)
+	}
+	return nil
+}
+
+// GenerateKey creates a signing key for the client and the matching key for
+// the keyring of the servers
+func GenerateKey(client, id string, algorithm Algorithm) (signing, verification Key, err error) {
+	signing = Key{ID: id, Client: client, Algorithm: algorithm}
+	switch algorithm {
+	case HMACSHA256:
+		signing.Key = make([]byte, hmacKeySize)
+		if _, err := rand.Read(signing.Key); err != nil {
+			return signing, verification, err
+		}
+		verification = signing
+	case Ed25519:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return signing, verification, err
+		}
+		signing.Key = private.Seed()
+		verification = signing
+		verification.Key = public
+	default:
+		return signing, verification, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+	return signing, verification, signing.validate(true)
+}
+
+// ReadKey reads the signing key of a client from a JSON file
+func ReadKey(path string) (Key, error) {
+	var key Key
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return key, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	return key, key.validate(true)
+}
+
+// Keyring holds the verification keys of all clients, read from a JSON file
+// with a list of keys. A client may have several keys while they are
+// rotated.
+type Keyring struct {
+	path string
+
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+// LoadKeyring reads the keyring file
+func LoadKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload replaces the keys with the current content of the file. The keys
+// are kept if the file is invalid.
+func (k *Keyring) Reload() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to read keyring: %w", err)
+	}
+	var list []Key
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("failed to parse keyring: %w", err)
+	}
+
+	keys := make(map[string]Key, len(list))
+	for _, key := range list {
+		if err := key.validate(false); err != nil {
+			return err
+		}
+		if _, ok := keys[key.ID]; ok {
+			return fmt.Errorf("duplicate key id %s", key.ID)
+		}
+		keys[key.ID] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// lookup returns the key with the id
+func (k *Keyring) lookup(id string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// ErrDenied is returned for commands the policy does not allow
+var ErrDenied = errors.New("access denied")
+
+// Rule allows commands by type or category, optionally limited to
+// namespaces and keys. Namespaces and keys are glob patterns, e.g. feed:*;
+// empty lists allow any. Commands with limited namespaces or keys must be
+// bound to a namespace or access keys, so e.g. getall is not allowed by a
+// rule with key patterns.
+type Rule struct {
+	Commands   []commands.CommandType `json:"commands,omitempty"`
+	Categories []string               `json:"categories,omitempty"`
+	Namespaces []string               `json:"namespaces,omitempty"`
+	Keys       []string               `json:"keys,omitempty"`
+}
+
+// Grant lists the roles and own rules of a client
+type Grant struct {
+	Roles []string `json:"roles,omitempty"`
+	Rules []Rule   `json:"rules,omitempty"`
+}
+
+// policyFile is the content of a policy file
+type policyFile struct {
+	Roles   map[string][]Rule `json:"roles"`
+	Clients map[string]Grant  `json:"clients"`
+}
+
+// Policy authorizes the commands of authenticated clients. Clients without
+// a grant may not run any command.
+type Policy struct {
+	path string
+
+	mu    sync.RWMutex
+	rules map[string][]Rule // by client, roles resolved
+
+	denied atomic.Int64
+}
+
+// LoadPolicy reads the policy file
+func LoadPolicy(path string) (*Policy, error) {
+	p := &Policy{path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload replaces the rules with the current content of the file. The rules
+// are kept if the file is invalid.
+func (p *Policy) Reload() error {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to read policy: %w", err)
+	}
+	var file policyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	rules := make(map[string][]Rule, len(file.Clients))
+	for client, grant := range file.Clients {
+		for _, role := range grant.Roles {
+			roleRules, ok := file.Roles[role]
+			if !ok {
+				return fmt.Errorf("client %s: unknown role %s", client, role)
+			}
+			rules[client] = append(rules[client], roleRules...)
+		}
+		rules[client] = append(rules[client], grant.Rules...)
+	}
+	for client, clientRules := range rules {
+		for _, rule := range clientRules {
+			if err := rule.validate(); err != nil {
+				return fmt.Errorf("client %s: %w", client, err)
+			}
+		}
+	}
+
+	p.mu.Lock()
+	p.rules = rules
+	p.mu.Unlock()
+	return nil
+}
+
+// Authorize checks that a rule of the client allows the command
+func (p *Policy) Authorize(client string, cmdType commands.CommandType, category commands.CommandCategory, scope commands.CommandScope) error {
+	if p.Allowed(client, cmdType, category, scope) {
+		return nil
+	}
+	p.denied.Add(1)
+	return &commands.CodedError{
+		Code: commands.CodeAccessDenied,
+		Err:  fmt.Errorf("%w: client %s may not run %s", ErrDenied, client, cmdType),
+	}
+}
+
+// Allowed tells whether a rule of the client allows the command, without
+// counting a denial. Watches use it to filter changes.
+func (p *Policy) Allowed(client string, cmdType commands.CommandType, category commands.CommandCategory, scope commands.CommandScope) bool {
+	p.mu.RLock()
+	rules := p.rules[client]
+	p.mu.RUnlock()
+
+	for _, rule := range rules {
+		if rule.allows(cmdType, category, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// Denied returns the number of denied commands
+func (p *Policy) Denied() int64 {
+	return p.denied.Load()
+}
+
+func (r Rule) validate() error {
+	if len(r.Commands) == 0 && len(r.Categories) == 0 {
+		return errors.New("rule without commands or categories")
+	}
+	for _, category := range r.Categories {
+		switch commands.CommandCategory(strings.ToUpper(category)) {
+		case commands.ReadCategory, commands.WriteCategory, commands.AdminCategory:
+		default:
+			return fmt.Errorf("unknown category %s", category)
+		}
+	}
+	for _, pattern := range append(append([]string{}, r.Namespaces...), r.Keys...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (r Rule) allows(cmdType commands.CommandType, category commands.CommandCategory, scope commands.CommandScope) bool {
+	if !r.allowsCommand(cmdType, category) {
+		return false
+	}
+	if len(r.Namespaces) > 0 && (scope.Namespace == "" || !matchAny(r.Namespaces, scope.Namespace)) {
+		return false
+	}
+	if len(r.Keys) > 0 {
+		if len(scope.Keys) == 0 {
+			return false
+		}
+		for _, key := range scope.Keys {
+			if !matchAny(r.Keys, key) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r Rule) allowsCommand(cmdType commands.CommandType, category commands.CommandCategory) bool {
+	for _, allowed := range r.Commands {
+		if allowed == "*" || allowed == cmdType {
+			return true
+		}
+	}
+	for _, allowed := range r.Categories {
+		if commands.CommandCategory(strings.ToUpper(allowed)) == category {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny tells whether the name matches one of the glob patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
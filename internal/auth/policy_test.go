@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"eoracle-client-server/internal/commands"
+)
+
+const testPolicy = `{
+	"roles": {
+		"dashboard": [{"categories": ["read"]}],
+		"feed-writer": [{"commands": ["addItem", "deleteItem"], "namespaces": ["prices"], "keys": ["feed:*"]}],
+		"admin": [{"commands": ["*"]}],
+		"writer": [{"categories": ["write"]}],
+		"operator": [{"categories": ["admin"]}]
+	},
+	"clients": {
+		"grafana": {"roles": ["dashboard"]},
+		"ingest": {"roles": ["feed-writer"], "rules": [{"commands": ["getAllItems"], "namespaces": ["prices"]}]},
+		"ops": {"roles": ["admin"]},
+		"etl": {"roles": ["writer"]},
+		"oncall": {"roles": ["operator"]}
+	}
+}`
+
+func writePolicy(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	return path
+}
+
+func TestPolicy_Authorize(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	prices := func(keys ...string) commands.CommandScope {
+		return commands.CommandScope{Namespace: "prices", Keys: keys}
+	}
+	tests := []struct {
+		name     string
+		client   string
+		cmdType  commands.CommandType
+		category commands.CommandCategory
+		scope    commands.CommandScope
+		allowed  bool
+	}{
+		{"dashboard reads", "grafana", commands.GetItem, commands.ReadCategory, prices("feed:btc"), true},
+		{"dashboard lists namespaces", "grafana", commands.ListNamespaces, commands.ReadCategory, commands.CommandScope{}, true},
+		{"dashboard writes", "grafana", commands.AddItem, commands.WriteCategory, prices("feed:btc"), false},
+		{"writer adds feed key", "ingest", commands.AddItem, commands.WriteCategory, prices("feed:btc"), true},
+		{"writer adds other key", "ingest", commands.AddItem, commands.WriteCategory, prices("user:1"), false},
+		{"writer adds in other namespace", "ingest", commands.AddItem, commands.WriteCategory, commands.CommandScope{Namespace: "default", Keys: []string{"feed:btc"}}, false},
+		{"writer moves feed key behind other key", "ingest", commands.InsertAfter, commands.WriteCategory, prices("feed:btc", "user:1"), false},
+		{"writer deletes without key", "ingest", commands.DeleteItem, commands.WriteCategory, prices(), false},
+		{"writer drops namespace", "ingest", commands.DropNamespace, commands.WriteCategory, commands.CommandScope{}, false},
+		{"writer own rule", "ingest", commands.GetAllItems, commands.ReadCategory, prices(), true},
+		{"admin", "ops", commands.ResizePool, commands.AdminCategory, commands.CommandScope{}, true},
+		{"write rule resizes", "etl", commands.ResizePool, commands.AdminCategory, commands.CommandScope{}, false},
+		{"write rule drops namespace", "etl", commands.DropNamespace, commands.WriteCategory, commands.CommandScope{}, true},
+		{"operator resizes", "oncall", commands.ResizePool, commands.AdminCategory, commands.CommandScope{}, true},
+		{"operator writes", "oncall", commands.AddItem, commands.WriteCategory, prices("feed:btc"), false},
+		{"unknown client", "intruder", commands.GetItem, commands.ReadCategory, prices("feed:btc"), false},
+	}
+
+	denied := int64(0)
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.client, tt.cmdType, tt.category, tt.scope); got != tt.allowed {
+			t.Errorf("%s: Allowed() = %v, want %v", tt.name, got, tt.allowed)
+		}
+		err := policy.Authorize(tt.client, tt.cmdType, tt.category, tt.scope)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: Authorize() error = %v", tt.name, err)
+		}
+		if !tt.allowed {
+			denied++
+			if !errors.Is(err, ErrDenied) {
+				t.Errorf("%s: Authorize() error = %v, want %v", tt.name, err, ErrDenied)
+			}
+			if code := commands.Code(err); code != commands.CodeAccessDenied {
+				t.Errorf("%s: Code(Authorize()) = %q, want %q", tt.name, code, commands.CodeAccessDenied)
+			}
+		}
+	}
+	if got := policy.Denied(); got != denied {
+		t.Errorf("Denied() = %d, want %d", got, denied)
+	}
+}
+
+func TestPolicy_Reload(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	// Revoking the role takes effect on reload
+	os.WriteFile(path, []byte(`{"clients": {"grafana": {}}}`), 0600)
+	if err := policy.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if err := policy.Authorize("grafana", commands.GetItem, commands.ReadCategory, commands.CommandScope{}); err == nil {
+		t.Error("Expected revoked client to be denied")
+	}
+
+	for _, content := range []string{
+		`{"clients": {"grafana": {"roles": ["missing"]}}}`,
+		`{"clients": {"grafana": {"rules": [{"namespaces": ["prices"]}]}}}`,
+		`{"clients": {"grafana": {"rules": [{"categories": ["delete"]}]}}}`,
+		`{"clients": {"grafana": {"rules": [{"commands": ["*"], "keys": ["["]}]}}}`,
+		`not json`,
+	} {
+		os.WriteFile(path, []byte(content), 0600)
+		if err := policy.Reload(); err == nil {
+			t.Errorf("Reload(%s) expected error", content)
+		}
+	}
+}
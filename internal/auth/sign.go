@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/queue"
+)
+
+// nonceSize is the number of random bytes of a nonce
+const nonceSize = 16
+
+// Reasons a command is rejected
+var (
+	ErrUnsigned     = errors.New("unsigned command")
+	ErrUnknownKey   = errors.New("unknown key")
+	ErrKeyExpired   = errors.New("expired key")
+	ErrBadSignature = errors.New("bad signature")
+	ErrStale        = errors.New("timestamp outside window")
+	ErrReplayed     = errors.New("replayed nonce")
+)
+
+// Signer signs the commands of a client
+type Signer struct {
+	key     Key
+	private ed25519.PrivateKey
+}
+
+// NewSigner creates a signer for the signing key
+func NewSigner(key Key) (*Signer, error) {
+	if err := key.validate(true); err != nil {
+		return nil, err
+	}
+	s := &Signer{key: key}
+	if key.Algorithm == Ed25519 {
+		s.private = ed25519.NewKeyFromSeed(key.Key)
+	}
+	return s, nil
+}
+
+// Sign returns a copy of the command signed with a fresh timestamp and nonce
+func (s *Signer) Sign(cmd commands.Command) (commands.Command, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to create nonce: %w", err)
+	}
+
+	auth := &commands.Auth{
+		Client:    s.key.Client,
+		KeyID:     s.key.ID,
+		Timestamp: time.Now().UnixMilli(),
+		Nonce:     hex.EncodeToString(nonce),
+	}
+	payload, err := signingPayload(cmd, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.key.Algorithm {
+	case HMACSHA256:
+		auth.Signature = hmacSum(s.key.Key, payload)
+	case Ed25519:
+		auth.Signature = ed25519.Sign(s.private, payload)
+	}
+	return commands.WithAuth(cmd, auth), nil
+}
+
+// SignQueue signs every command published to the queue
+func SignQueue(q queue.Queue, signer *Signer) queue.Queue {
+	return signingQueue{Queue: q, signer: signer}
+}
+
+type signingQueue struct {
+	queue.Queue
+	signer *Signer
+}
+
+func (q signingQueue) Publish(cmd commands.Command) error {
+	signed, err := q.signer.Sign(cmd)
+	if err != nil {
+		return err
+	}
+	return q.Queue.Publish(signed)
+}
+
+// Request signs the command and waits for its result, if the queue supports
+// requests
+func (q signingQueue) Request(ctx context.Context, cmd commands.Command) (commands.Result, error) {
+	requester, ok := q.Queue.(queue.Requester)
+	if !ok {
+		return commands.Result{}, queue.ErrNoRequests
+	}
+	signed, err := q.signer.Sign(cmd)
+	if err != nil {
+		return commands.Result{}, err
+	}
+	return requester.Request(ctx, signed)
+}
+
+// Verifier checks the signatures of commands against a keyring. Commands
+// must be signed within the window around the server clock, and a nonce is
+// accepted once per window.
+type Verifier struct {
+	keyring *Keyring
+	window  time.Duration
+	now     func() time.Time
+
+	mu         sync.Mutex
+	nonces     map[string]time.Time // client/nonce to expiry
+	nextPrune  time.Time
+	rejections map[string]int64
+}
+
+// NewVerifier creates a verifier for the keys of the keyring
+func NewVerifier(keyring *Keyring, window time.Duration) *Verifier {
+	return &Verifier{
+		keyring:    keyring,
+		window:     window,
+		now:        time.Now,
+		nonces:     make(map[string]time.Time),
+		rejections: make(map[string]int64),
+	}
+}
+
+// Verify checks that the command was signed by a known key within the
+// window and that its nonce was not seen before. Rejections are counted by
+// reason.
+func (v *Verifier) Verify(cmd commands.Command) error {
+	err := v.verify(cmd)
+	if err != nil {
+		v.mu.Lock()
+		v.rejections[rejectionReason(err)]++
+		v.mu.Unlock()
+	}
+	return err
+}
+
+func (v *Verifier) verify(cmd commands.Command) error {
+	auth := cmd.GetAuth()
+	if auth == nil || len(auth.Signature) == 0 {
+		return ErrUnsigned
+	}
+
+	key, ok := v.keyring.lookup(auth.KeyID)
+	if !ok || key.Client != auth.Client {
+		return fmt.Errorf("%w %s for client %s", ErrUnknownKey, auth.KeyID, auth.Client)
+	}
+	now := v.now()
+	if key.Expires != nil && now.After(*key.Expires) {
+		return fmt.Errorf("%w %s", ErrKeyExpired, auth.KeyID)
+	}
+
+	unsigned := *auth
+	unsigned.Signature = nil
+	payload, err := signingPayload(cmd, &unsigned)
+	if err != nil {
+		return err
+	}
+	switch key.Algorithm {
+	case HMACSHA256:
+		ok = hmac.Equal(auth.Signature, hmacSum(key.Key, payload))
+	case Ed25519:
+		ok = ed25519.Verify(key.Key, payload, auth.Signature)
+	}
+	if !ok {
+		return fmt.Errorf("%w from client %s", ErrBadSignature, auth.Client)
+	}
+
+	// Only authentic commands reach the nonce cache, so it cannot be
+	// flooded with forged nonces
+	signed := time.UnixMilli(auth.Timestamp)
+	if signed.Before(now.Add(-v.window)) || signed.After(now.Add(v.window)) {
+		return fmt.Errorf("%w: signed at %s", ErrStale, signed.UTC().Format(time.RFC3339))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.prune(now)
+	id := nonceID(auth)
+	if _, ok := v.nonces[id]; ok {
+		return fmt.Errorf("%w %s from client %s", ErrReplayed, auth.Nonce, auth.Client)
+	}
+	// Past the window the timestamp check rejects the command
+	v.nonces[id] = signed.Add(v.window)
+	return nil
+}
+
+// Release forgets the nonce of a verified command, so the queue can retry
+// the same message
+func (v *Verifier) Release(cmd commands.Command) {
+	if auth := cmd.GetAuth(); auth != nil {
+		v.mu.Lock()
+		delete(v.nonces, nonceID(auth))
+		v.mu.Unlock()
+	}
+}
+
+// Nonces returns the accepted nonces that did not expire with their expiry,
+// so a server taking over can keep rejecting them
+func (v *Verifier) Nonces() map[string]time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.now()
+	nonces := make(map[string]time.Time, len(v.nonces))
+	for id, expires := range v.nonces {
+		if !now.After(expires) {
+			nonces[id] = expires
+		}
+	}
+	return nonces
+}
+
+// Remember adds nonces returned by Nonces, e.g. of the previous active server
+func (v *Verifier) Remember(nonces map[string]time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for id, expires := range nonces {
+		v.nonces[id] = expires
+	}
+}
+
+// Rejections returns the number of rejected commands by reason
+func (v *Verifier) Rejections() map[string]int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	counts := make(map[string]int64, len(v.rejections))
+	for reason, n := range v.rejections {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// prune drops expired nonces once per window. It is called with the lock
+// held.
+func (v *Verifier) prune(now time.Time) {
+	if now.Before(v.nextPrune) {
+		return
+	}
+	for id, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, id)
+		}
+	}
+	v.nextPrune = now.Add(v.window)
+}
+
+// signingPayload serializes the command with the signature fields. The
+// version 0 encoding is signed whatever the wire version, so signatures stay
+// valid across schema versions.
+func signingPayload(cmd commands.Command, auth *commands.Auth) ([]byte, error) {
+	payload, err := commands.Marshal(commands.WithAuth(cmd, auth), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize command: %w", err)
+	}
+	return payload, nil
+}
+
+func hmacSum(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func nonceID(auth *commands.Auth) string {
+	return auth.Client + "/" + auth.Nonce
+}
+
+// rejectionReason returns the reason a verification error wraps
+func rejectionReason(err error) string {
+	for _, reason := range []error{ErrUnsigned, ErrUnknownKey, ErrKeyExpired, ErrBadSignature, ErrStale, ErrReplayed} {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+	return "invalid command"
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// newTestKeys returns a signer and a verifier sharing a generated key
+func newTestKeys(t *testing.T, algorithm Algorithm, window time.Duration) (*Signer, *Verifier) {
+	t.Helper()
+	signing, verification, err := GenerateKey("dashboard", "dashboard-1", algorithm)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := NewSigner(signing)
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	keyring := writeKeyring(t, filepath.Join(t.TempDir(), "keyring.json"), verification)
+	return signer, NewVerifier(keyring, window)
+}
+
+func writeKeyring(t *testing.T, path string, keys ...Key) *Keyring {
+	t.Helper()
+	data, _ := json.Marshal(keys)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write keyring: %v", err)
+	}
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	return keyring
+}
+
+func TestVerifier(t *testing.T) {
+	for _, algorithm := range []Algorithm{HMACSHA256, Ed25519} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			signer, verifier := newTestKeys(t, algorithm, time.Minute)
+
+			cmd := commands.WithNamespace(commands.NewCommand(commands.AddItem, "feed:btc", "42"), "prices")
+			signed, err := signer.Sign(cmd)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+
+			// The signature survives the trip through the queue
+			data, _ := signed.ToJSON()
+			received, _ := commands.FromJSON(data)
+			if err := verifier.Verify(received); err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if err := verifier.Verify(received); !errors.Is(err, ErrReplayed) {
+				t.Errorf("Verify() of replay error = %v, want %v", err, ErrReplayed)
+			}
+
+			// A released nonce may be retried
+			verifier.Release(received)
+			if err := verifier.Verify(received); err != nil {
+				t.Errorf("Verify() after Release() error = %v", err)
+			}
+
+			tampered := commands.WithAuth(commands.NewCommand(commands.AddItem, "feed:btc", "0"), received.GetAuth())
+			tampered = commands.WithNamespace(tampered, "prices")
+			if err := verifier.Verify(tampered); !errors.Is(err, ErrBadSignature) {
+				t.Errorf("Verify() of tampered command error = %v, want %v", err, ErrBadSignature)
+			}
+		})
+	}
+}
+
+func TestVerifier_SchemaVersions(t *testing.T) {
+	signer, verifier := newTestKeys(t, HMACSHA256, time.Minute)
+	signed, _ := signer.Sign(commands.WithNamespace(commands.NewCommand(commands.GetMany, "", "", "a", "b"), "prices"))
+
+	// Clients writing an older wire version keep working after an upgrade
+	for version := 0; version <= commands.SchemaVersion; version++ {
+		data, _ := commands.Marshal(signed, version)
+		received, err := commands.FromJSON(data)
+		if err != nil {
+			t.Fatalf("FromJSON() of version %d error = %v", version, err)
+		}
+		if err := verifier.Verify(received); err != nil {
+			t.Errorf("Verify() of version %d error = %v", version, err)
+		}
+		verifier.Release(received)
+	}
+}
+
+func TestVerifier_Rejections(t *testing.T) {
+	signer, verifier := newTestKeys(t, Ed25519, time.Minute)
+	other, _ := newTestKeys(t, Ed25519, time.Minute)
+
+	cmd := commands.NewCommand(commands.GetItem, "key", "")
+	signed, _ := signer.Sign(cmd)
+	forged, _ := other.Sign(cmd)
+
+	auth := *signed.GetAuth()
+	auth.Client = "admin"
+	impersonated := commands.WithAuth(cmd, &auth)
+
+	stale, _ := signer.Sign(cmd)
+	verifier.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if err := verifier.Verify(stale); !errors.Is(err, ErrStale) {
+		t.Errorf("Verify() of stale command error = %v, want %v", err, ErrStale)
+	}
+	verifier.now = time.Now
+
+	tests := []struct {
+		name string
+		cmd  commands.Command
+		want error
+	}{
+		{"unsigned", cmd, ErrUnsigned},
+		{"signed by other key", forged, ErrBadSignature},
+		{"other client", impersonated, ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		if err := verifier.Verify(tt.cmd); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Verify() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	want := map[string]int64{"unsigned command": 1, "bad signature": 1, "unknown key": 1, "timestamp outside window": 1}
+	got := verifier.Rejections()
+	for reason, n := range want {
+		if got[reason] != n {
+			t.Errorf("Rejections()[%s] = %d, want %d", reason, got[reason], n)
+		}
+	}
+}
+
+// TestVerifier_Nonces tests that a verifier taking over the nonces of
+// another rejects replays of the commands it accepted
+func TestVerifier_Nonces(t *testing.T) {
+	signer, verifier := newTestKeys(t, HMACSHA256, time.Minute)
+	signed, _ := signer.Sign(commands.NewCommand(commands.AddItem, "key", "value"))
+	if err := verifier.Verify(signed); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	nonces := verifier.Nonces()
+	if len(nonces) != 1 {
+		t.Fatalf("Nonces() = %v, want 1 nonce", nonces)
+	}
+	standby := NewVerifier(verifier.keyring, time.Minute)
+	standby.Remember(nonces)
+	if err := standby.Verify(signed); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Verify() of replayed command error = %v, want %v", err, ErrReplayed)
+	}
+
+	verifier.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	if nonces := verifier.Nonces(); len(nonces) != 0 {
+		t.Errorf("Nonces() after the window = %v, want none", nonces)
+	}
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	oldSigning, oldVerification, _ := GenerateKey("dashboard", "dashboard-1", HMACSHA256)
+	newSigning, newVerification, _ := GenerateKey("dashboard", "dashboard-2", Ed25519)
+	oldSigner, _ := NewSigner(oldSigning)
+	newSigner, _ := NewSigner(newSigning)
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	keyring := writeKeyring(t, path, oldVerification)
+	verifier := NewVerifier(keyring, time.Minute)
+
+	cmd := commands.NewCommand(commands.GetItem, "key", "")
+	sign := func(signer *Signer) commands.Command {
+		signed, err := signer.Sign(cmd)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return signed
+	}
+
+	if err := verifier.Verify(sign(newSigner)); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Verify() with new key before rotation error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// During the rotation both keys are valid, then the old one expires
+	expired := time.Now().Add(-time.Second)
+	oldVerification.Expires = &expired
+	writeKeyring(t, path, oldVerification, newVerification)
+	if err := keyring.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if err := verifier.Verify(sign(newSigner)); err != nil {
+		t.Errorf("Verify() with new key error = %v", err)
+	}
+	if err := verifier.Verify(sign(oldSigner)); !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("Verify() with expired key error = %v, want %v", err, ErrKeyExpired)
+	}
+
+	// An invalid file keeps the current keys
+	os.WriteFile(path, []byte(`[{"id": "broken"}]`), 0600)
+	if err := keyring.Reload(); err == nil {
+		t.Error("Expected error for invalid keyring")
+	}
+	if err := verifier.Verify(sign(newSigner)); err != nil {
+		t.Errorf("Verify() after failed reload error = %v", err)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	signing, _, err := GenerateKey("writer", "writer-1", Ed25519)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	write := func(name string, key Key) string {
+		path := filepath.Join(dir, name)
+		data, _ := json.Marshal(key)
+		os.WriteFile(path, data, 0600)
+		return path
+	}
+
+	if _, err := ReadKey(write("signing.json", signing)); err != nil {
+		t.Errorf("ReadKey() error = %v", err)
+	}
+	signing.Key = signing.Key[:16]
+	if _, err := ReadKey(write("truncated.json", signing)); err == nil {
+		t.Error("Expected error for truncated key")
+	}
+	if _, _, err := GenerateKey("writer", "writer-2", "rsa"); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"eoracle-client-server/internal/output"
+)
+
+const (
+	ResizePool CommandType = "resizePool"
+	Pools      CommandType = "pools"
+	Rejections CommandType = "rejections"
+)
+
+// Worker pool names used by admin commands
+const (
+	ReadPool  = "read"
+	WritePool = "write"
+)
+
+// PoolStats describes the current state of a worker pool
+type PoolStats struct {
+	Name       string
+	Size       int
+	Min        int
+	Max        int
+	Busy       int
+	QueueDepth int
+	AvgWait    time.Duration
+}
+
+// Admin is the server side of admin commands
+type Admin interface {
+	ResizePool(name string, min, max int) error
+	PoolStats() []PoolStats
+	// Rejections returns the number of commands rejected by authentication
+	// or authorization by reason, nil if authentication is disabled
+	Rejections() map[string]int64
+}
+
+// registerAdminCommands registers the commands that control the server
+// itself rather than the map
+func (r commandRegistry) registerAdminCommands() {
+	r.Register("resize", CommandSpec{
+		Type:     ResizePool,
+		Category: WriteCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 2 {
+				return nil, errors.New("resize command requires pool, min and optional max workers")
+			}
+			pool := strings.ToLower(args[0])
+			if pool != ReadPool && pool != WritePool {
+				return nil, fmt.Errorf("unknown worker pool: %s", args[0])
+			}
+			bounds := args[1:]
+			if len(bounds) == 1 {
+				bounds = append(bounds, bounds[0])
+			}
+			min, minErr := strconv.Atoi(bounds[0])
+			max, maxErr := strconv.Atoi(bounds[1])
+			if minErr != nil || maxErr != nil || min < 1 || max < min {
+				return nil, fmt.Errorf("invalid worker bounds: %s", strings.Join(bounds, " "))
+			}
+			return &command{
+				Type: ResizePool,
+				Key:  pool,
+				Args: []string{strconv.Itoa(min), strconv.Itoa(max)},
+			}, nil
+		},
+		AdminHandler: func(cmd Command, admin Admin, out output.Output) error {
+			args := cmd.GetArgs()
+			if len(args) != 2 {
+				return fmt.Errorf("resize command requires min and max workers")
+			}
+			min, minErr := strconv.Atoi(args[0])
+			max, maxErr := strconv.Atoi(args[1])
+			if minErr != nil || maxErr != nil {
+				return fmt.Errorf("invalid worker bounds: %s", strings.Join(args, " "))
+			}
+			if err := admin.ResizePool(cmd.GetKey(), min, max); err != nil {
+				return err
+			}
+			log.Printf("Resized %s pool to %d-%d workers", cmd.GetKey(), min, max)
+			return nil
+		},
+	})
+
+	r.Register("pools", CommandSpec{
+		Type:     Pools,
+		Category: ReadCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			return &command{Type: Pools}, nil
+		},
+		AdminHandler: func(cmd Command, admin Admin, out output.Output) error {
+			writeData := ""
+			for _, stats := range admin.PoolStats() {
+				writeData += fmt.Sprintf("%s: size=%d min=%d max=%d busy=%d queue_depth=%d avg_wait=%s\n",
+					stats.Name, stats.Size, stats.Min, stats.Max, stats.Busy, stats.QueueDepth, stats.AvgWait)
+			}
+			out.Write(writeData)
+			log.Printf("Retrieved worker pool stats")
+			return nil
+		},
+	})
+
+	r.Register("rejections", CommandSpec{
+		Type:     Rejections,
+		Category: ReadCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			return &command{Type: Rejections}, nil
+		},
+		AdminHandler: func(cmd Command, admin Admin, out output.Output) error {
+			counts := admin.Rejections()
+			if counts == nil {
+				out.Write("authentication and access policy disabled\n")
+				return nil
+			}
+			reasons := make([]string, 0, len(counts))
+			for reason := range counts {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+
+			writeData := ""
+			for _, reason := range reasons {
+				writeData += fmt.Sprintf("%s: %d\n", reason, counts[reason])
+			}
+			out.Write(writeData)
+			log.Printf("Retrieved authentication rejections")
+			return nil
+		},
+	})
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/storage"
+)
+
+type mockAdmin struct {
+	resized map[string][2]int
+}
+
+func (a *mockAdmin) ResizePool(name string, min, max int) error {
+	if name != ReadPool {
+		return fmt.Errorf("no %s worker pool", name)
+	}
+	a.resized[name] = [2]int{min, max}
+	return nil
+}
+
+func (a *mockAdmin) PoolStats() []PoolStats {
+	return []PoolStats{{Name: ReadPool, Size: 3, Min: 1, Max: 8, Busy: 2, QueueDepth: 5, AvgWait: time.Millisecond}}
+}
+
+func (a *mockAdmin) Rejections() map[string]int64 {
+	return map[string]int64{"unsigned command": 2, "bad signature": 1}
+}
+
+func TestAdminCommands(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	out := &mockOutput{}
+
+	cmd, err := registry.ParseCommand("resize read 2 8")
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+	if registry.IsReadCommand(cmd) {
+		t.Error("Expected resize to be a write command")
+	}
+
+	// Without a server admin commands cannot run
+	if err := registry.HandleCommand(cmd, spaces, out); err == nil {
+		t.Error("Expected error for admin command without admin")
+	}
+
+	admin := &mockAdmin{resized: make(map[string][2]int)}
+	registry.SetAdmin(admin)
+	if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+		t.Fatalf("HandleCommand() error = %v", err)
+	}
+	if got := admin.resized[ReadPool]; got != [2]int{2, 8} {
+		t.Errorf("Expected read pool resized to 2-8, got %v", got)
+	}
+
+	// A single bound fixes the size
+	cmd, _ = registry.ParseCommand("resize read 4")
+	registry.HandleCommand(cmd, spaces, out)
+	if got := admin.resized[ReadPool]; got != [2]int{4, 4} {
+		t.Errorf("Expected read pool resized to 4-4, got %v", got)
+	}
+
+	cmd, _ = registry.ParseCommand("resize write 4")
+	if err := registry.HandleCommand(cmd, spaces, out); err == nil {
+		t.Error("Expected error for missing write pool")
+	}
+
+	cmd, _ = registry.ParseCommand("pools")
+	if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+		t.Fatalf("HandleCommand() error = %v", err)
+	}
+	want := "read: size=3 min=1 max=8 busy=2 queue_depth=5 avg_wait=1ms\n"
+	if got := out.output.String(); !strings.Contains(got, want) {
+		t.Errorf("pools output = %q, want it to contain %q", got, want)
+	}
+
+	cmd, _ = registry.ParseCommand("rejections")
+	if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+		t.Fatalf("HandleCommand() error = %v", err)
+	}
+	want = "bad signature: 1\nunsigned command: 2\n"
+	if got := out.output.String(); !strings.Contains(got, want) {
+		t.Errorf("rejections output = %q, want it to contain %q", got, want)
+	}
+}
+
+func TestAdminCommands_ParseErrors(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	for _, line := range []string{
+		"resize",
+		"resize read",
+		"resize other 1 2",
+		"resize read 0",
+		"resize read 5 2",
+		"resize read x",
+	} {
+		if _, err := registry.ParseCommand(line); err == nil {
+			t.Errorf("ParseCommand(%q) expected error", line)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// Content types of the codecs, set on every published message
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes commands for the wire. Every codec carries the fields of the
+// version 1 envelope and decodes as strictly as FromJSON.
+type Codec interface {
+	ContentType() string
+	Marshal(cmd Command) ([]byte, error)
+	Unmarshal(data []byte) (Command, error)
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	MsgPack  Codec = msgpackCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+// codecs by name, as selected on the command line
+var codecs = map[string]Codec{
+	"json":     JSON,
+	"msgpack":  MsgPack,
+	"protobuf": Protobuf,
+}
+
+// CodecByName returns the codec named json, msgpack or protobuf
+func CodecByName(name string) (Codec, error) {
+	codec, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec %s, want json, msgpack or protobuf", name)
+	}
+	return codec, nil
+}
+
+// CodecFor returns the codec of a message content type. Messages without a
+// content type are JSON. An unknown content type is a retryable error, a
+// newer server may read it.
+func CodecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		for _, codec := range codecs {
+			if codec.ContentType() == mediaType {
+				return codec, nil
+			}
+		}
+	}
+	return nil, Retryable(&ValidationError{Code: CodeUnsupportedContentType, Field: "message",
+		Detail: fmt.Sprintf("no codec for content type %q", contentType)})
+}
+
+// Decode decodes a message with the codec of its content type
+func Decode(contentType string, data []byte) (Command, error) {
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Unmarshal(data)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(cmd Command) ([]byte, error) {
+	return Marshal(cmd, SchemaVersion)
+}
+
+func (jsonCodec) Unmarshal(data []byte) (Command, error) {
+	return FromJSON(data)
+}
+
+// checkEnvelope checks the version and type of a decoded binary envelope.
+// Binary codecs start at version 1, there is no version 0 to fall back to.
+func checkEnvelope(version uint64, cmdType CommandType) error {
+	switch {
+	case version > SchemaVersion:
+		return Retryable(&ValidationError{Code: CodeUnsupportedVersion, Field: "message",
+			Detail: fmt.Sprintf("schema version %d is newer than %d", version, SchemaVersion)})
+	case version != 1:
+		return &ValidationError{Code: CodeMalformed, Field: "message", Detail: fmt.Sprintf("invalid schema version %d", version)}
+	case cmdType == "":
+		return &ValidationError{Code: CodeMalformed, Field: "message", Detail: "missing command type"}
+	}
+	return nil
+}
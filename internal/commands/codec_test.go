@@ -0,0 +1,194 @@
+package commands
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	registry := NewCommandRegistry()
+	cmds := []Command{
+		WithAuth(NewCommand(GetItem, "user:1", ""), &Auth{Client: "dashboard", KeyID: "k1", Timestamp: 1700000000000, Nonce: "n", Signature: []byte{0, 1, 255}}),
+		WithAuth(NewCommand(GetItem, "user:1", ""), &Auth{}),
+		NewCommand(GetMany, "", "", "a", "", "c"),
+		NewCommand(AddItem, "ключ", strings.Repeat("v", 70000)),
+	}
+	for _, line := range []string{"add user:1 john doe", "mget a b c", "insertafter a b value", "getall", "resize read 1 4"} {
+		cmd, err := registry.ParseCommand(line)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", line, err)
+		}
+		cmds = append(cmds, WithNamespace(cmd, "prices"))
+	}
+
+	for _, codec := range []Codec{JSON, MsgPack, Protobuf} {
+		for _, cmd := range cmds {
+			data, err := codec.Marshal(cmd)
+			if err != nil {
+				t.Fatalf("%s: Marshal(%s) error = %v", codec.ContentType(), cmd.GetType(), err)
+			}
+			got, err := Decode(codec.ContentType(), data)
+			if err != nil {
+				t.Fatalf("%s: Decode(%s) error = %v", codec.ContentType(), cmd.GetType(), err)
+			}
+			if !reflect.DeepEqual(got, copyCommand(cmd)) {
+				t.Errorf("%s: Decode() = %+v, want %+v", codec.ContentType(), got, cmd)
+			}
+		}
+	}
+}
+
+func TestCodecs_Strict(t *testing.T) {
+	msgpack := func(write func(w *msgpackWriter)) []byte {
+		var w msgpackWriter
+		write(&w)
+		return w.buf
+	}
+	valid, _ := MsgPack.Marshal(NewCommand(GetItem, "a", ""))
+
+	tests := []struct {
+		name      string
+		codec     Codec
+		data      []byte
+		code      ErrorCode
+		retryable bool
+	}{
+		{name: "msgpack newer version", codec: MsgPack, data: msgpack(func(w *msgpackWriter) {
+			w.mapHeader(2)
+			w.str("version")
+			w.int(SchemaVersion + 1)
+			w.str("type")
+			w.str("getItem")
+		}), code: CodeUnsupportedVersion, retryable: true},
+		{name: "msgpack missing version", codec: MsgPack, data: msgpack(func(w *msgpackWriter) {
+			w.mapHeader(1)
+			w.str("type")
+			w.str("getItem")
+		}), code: CodeMalformed},
+		{name: "msgpack unknown field", codec: MsgPack, data: msgpack(func(w *msgpackWriter) {
+			w.mapHeader(3)
+			w.str("version")
+			w.int(1)
+			w.str("type")
+			w.str("addItem")
+			w.str("ttl")
+			w.int(5)
+		}), code: CodeMalformed},
+		{name: "msgpack duplicate field", codec: MsgPack, data: msgpack(func(w *msgpackWriter) {
+			w.mapHeader(3)
+			w.str("version")
+			w.int(1)
+			w.str("type")
+			w.str("addItem")
+			w.str("type")
+			w.str("deleteItem")
+		}), code: CodeMalformed},
+		{name: "msgpack invalid UTF-8", codec: MsgPack, data: msgpack(func(w *msgpackWriter) {
+			w.mapHeader(2)
+			w.str("version")
+			w.int(1)
+			w.str("type")
+			w.str("\xff")
+		}), code: CodeInvalidUTF8},
+		{name: "msgpack signature not binary", codec: MsgPack, data: msgpack(func(w *msgpackWriter) {
+			w.mapHeader(3)
+			w.str("version")
+			w.int(1)
+			w.str("type")
+			w.str("getItem")
+			w.str("metadata")
+			w.mapHeader(1)
+			w.str("auth")
+			w.mapHeader(1)
+			w.str("sig")
+			w.int(-1)
+		}), code: CodeMalformed},
+		{name: "msgpack trailing data", codec: MsgPack, data: append(append([]byte(nil), valid...), 0xc0), code: CodeMalformed},
+		{name: "msgpack truncated", codec: MsgPack, data: valid[:len(valid)-1], code: CodeMalformed},
+		{name: "msgpack huge array", codec: MsgPack, data: []byte{0x81, 0xa4, 'a', 'r', 'g', 's', 0x81, 0xa5, 'e', 'x', 't', 'r', 'a', 0xdd, 0xff, 0xff, 0xff, 0xff}, code: CodeMalformed},
+		{name: "msgpack empty", codec: MsgPack, data: nil, code: CodeMalformed},
+		{name: "protobuf newer version", codec: Protobuf, data: []byte{0x08, SchemaVersion + 1, 0x12, 1, 'a'}, code: CodeUnsupportedVersion, retryable: true},
+		{name: "protobuf missing type", codec: Protobuf, data: []byte{0x08, 1}, code: CodeMalformed},
+		{name: "protobuf unknown field", codec: Protobuf, data: []byte{0x08, 1, 0x12, 1, 'a', 0x48, 5}, code: CodeMalformed},
+		{name: "protobuf unknown args field", codec: Protobuf, data: []byte{0x08, 1, 0x12, 1, 'a', 0x1a, 2, 0x28, 5}, code: CodeMalformed},
+		{name: "protobuf wrong wire type", codec: Protobuf, data: []byte{0x08, 1, 0x10, 1}, code: CodeMalformed},
+		{name: "protobuf invalid UTF-8", codec: Protobuf, data: []byte{0x08, 1, 0x12, 1, 0xff}, code: CodeInvalidUTF8},
+		{name: "protobuf truncated", codec: Protobuf, data: []byte{0x08, 1, 0x12, 5, 'a'}, code: CodeMalformed},
+		{name: "protobuf empty", codec: Protobuf, data: nil, code: CodeMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := tt.codec.Unmarshal(tt.data)
+			if Code(err) != tt.code {
+				t.Fatalf("Unmarshal() = %+v, %v, want code %s", cmd, err, tt.code)
+			}
+			if IsRetryable(err) != tt.retryable {
+				t.Errorf("IsRetryable(%v) = %v, want %v", err, IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Codec
+	}{
+		{"", JSON},
+		{"application/json", JSON},
+		{"application/json; charset=utf-8", JSON},
+		{"application/msgpack", MsgPack},
+		{"application/x-protobuf", Protobuf},
+		{"application/cbor", nil},
+		{"not a media type;", nil},
+	}
+	for _, tt := range tests {
+		got, err := CodecFor(tt.contentType)
+		if got != tt.want {
+			t.Errorf("CodecFor(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+		if tt.want == nil && (Code(err) != CodeUnsupportedContentType || !IsRetryable(err)) {
+			t.Errorf("CodecFor(%q) error = %v, want retryable %s", tt.contentType, err, CodeUnsupportedContentType)
+		}
+	}
+
+	for name, want := range map[string]Codec{"json": JSON, "MsgPack": MsgPack, "protobuf": Protobuf} {
+		if got, err := CodecByName(name); err != nil || got != want {
+			t.Errorf("CodecByName(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := CodecByName("xml"); err == nil {
+		t.Error("Expected error for unknown codec")
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	cmd := WithAuth(NewCommand(AddItem, "test:key:12345", "benchmark_value_with_some_length"),
+		&Auth{Client: "dashboard", KeyID: "k1", Timestamp: 1700000000000, Nonce: "0123456789abcdef", Signature: make([]byte, 32)})
+
+	for _, codec := range []Codec{JSON, MsgPack, Protobuf} {
+		data, err := codec.Marshal(cmd)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(codec.ContentType()+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Marshal(cmd); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(codec.ContentType()+"/unmarshal", func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(data)), "bytes/msg")
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.Unmarshal(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -1,10 +1,5 @@
 package commands
 
-import (
-	"encoding/json"
-	"fmt"
-)
-
 type CommandType string
 type CommandCategory string
 
@@ -13,17 +8,44 @@ type Command interface {
 	GetType() CommandType
 	GetKey() string
 	GetValue() string
+	GetNamespace() string
+	GetArgs() []string
+	GetAuth() *Auth
+}
+
+// Auth identifies the client that signed a command. The signature covers
+// the serialized command with this Auth and an empty Signature.
+type Auth struct {
+	Client    string `json:"client"`
+	KeyID     string `json:"key_id"`
+	Timestamp int64  `json:"ts"` // Unix milliseconds
+	Nonce     string `json:"nonce"`
+	Signature []byte `json:"sig,omitempty"`
 }
 
 type command struct {
-	Type  CommandType `json:"type"`
-	Key   string      `json:"key,omitempty"`
-	Value string      `json:"value,omitempty"`
+	Type      CommandType `json:"type"`
+	Key       string      `json:"key,omitempty"`
+	Value     string      `json:"value,omitempty"`
+	Namespace string      `json:"namespace,omitempty"`
+	Args      []string    `json:"args,omitempty"`
+	Auth      *Auth       `json:"auth,omitempty"`
+}
+
+// NewCommand creates a command without parsing, e.g. for commands built by
+// programs rather than typed by users
+func NewCommand(cmdType CommandType, key, value string, args ...string) Command {
+	return &command{
+		Type:  cmdType,
+		Key:   key,
+		Value: value,
+		Args:  args,
+	}
 }
 
-// ToJSON converts command to JSON
+// ToJSON converts command to JSON in the current SchemaVersion
 func (c *command) ToJSON() ([]byte, error) {
-	return json.Marshal(c)
+	return Marshal(c, SchemaVersion)
 }
 
 // GetType returns the key of the command
@@ -41,12 +63,73 @@ func (c *command) GetValue() string {
 	return c.Value
 }
 
-// FromJSON creates command from JSON
-func FromJSON(data []byte) (Command, error) {
-	var cmd command
-	err := json.Unmarshal(data, &cmd)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal command: %w", err)
+// GetNamespace returns the namespace of the command
+func (c *command) GetNamespace() string {
+	return c.Namespace
+}
+
+// GetArgs returns the extra arguments of the command
+func (c *command) GetArgs() []string {
+	return c.Args
+}
+
+// GetAuth returns the signature of the command, nil if it is unsigned
+func (c *command) GetAuth() *Auth {
+	return c.Auth
+}
+
+// WithNamespace returns a copy of the command bound to the namespace
+func WithNamespace(cmd Command, namespace string) Command {
+	bound := copyCommand(cmd)
+	bound.Namespace = namespace
+	return bound
+}
+
+// WithAuth returns a copy of the command carrying the signature, nil to
+// remove it
+func WithAuth(cmd Command, auth *Auth) Command {
+	signed := copyCommand(cmd)
+	signed.Auth = auth
+	return signed
+}
+
+func copyCommand(cmd Command) *command {
+	if c, ok := cmd.(*command); ok {
+		copied := *c
+		return &copied
+	}
+	return &command{
+		Type:      cmd.GetType(),
+		Key:       cmd.GetKey(),
+		Value:     cmd.GetValue(),
+		Namespace: cmd.GetNamespace(),
+		Args:      cmd.GetArgs(),
+		Auth:      cmd.GetAuth(),
+	}
+}
+
+// SplitKeys splits a command whose args are keys into one command per
+// group, as returned by groupOf for each key. Key order is kept per group.
+func SplitKeys(cmd Command, groupOf func(key string) string) map[string]Command {
+	groups := make(map[string]*command)
+	for _, key := range cmd.GetArgs() {
+		group := groupOf(key)
+		split, ok := groups[group]
+		if !ok {
+			split = &command{
+				Type:      cmd.GetType(),
+				Key:       cmd.GetKey(),
+				Value:     cmd.GetValue(),
+				Namespace: cmd.GetNamespace(),
+			}
+			groups[group] = split
+		}
+		split.Args = append(split.Args, key)
+	}
+
+	result := make(map[string]Command, len(groups))
+	for group, split := range groups {
+		result[group] = split
 	}
-	return &cmd, nil
+	return result
 }
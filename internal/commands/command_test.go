@@ -19,7 +19,7 @@ func TestCommand_ToJSON(t *testing.T) {
 				Key:   "user:123",
 				Value: "john_doe",
 			},
-			want:    `{"type":"SET","key":"user:123","value":"john_doe"}`,
+			want:    `{"version":1,"type":"SET","args":{"key":"user:123","value":"john_doe"}}`,
 			wantErr: false,
 		},
 		{
@@ -27,7 +27,7 @@ func TestCommand_ToJSON(t *testing.T) {
 			command: command{
 				Type: "PING",
 			},
-			want:    `{"type":"PING"}`,
+			want:    `{"version":1,"type":"PING"}`,
 			wantErr: false,
 		},
 		{
@@ -36,13 +36,13 @@ func TestCommand_ToJSON(t *testing.T) {
 				Type: "GET",
 				Key:  "config:timeout",
 			},
-			want:    `{"type":"GET","key":"config:timeout"}`,
+			want:    `{"version":1,"type":"GET","args":{"key":"config:timeout"}}`,
 			wantErr: false,
 		},
 		{
 			name:    "empty command",
 			command: command{},
-			want:    `{"type":""}`,
+			want:    `{"version":1,"type":""}`,
 			wantErr: false,
 		},
 	}
@@ -87,13 +87,10 @@ func TestFromJSON(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name: "JSON with extra fields (should be ignored)",
-			data: []byte(`{"type":"GET","key":"config","extra":"ignored"}`),
-			want: &command{
-				Type: "GET",
-				Key:  "config",
-			},
-			wantErr: false,
+			name:    "JSON with extra fields",
+			data:    []byte(`{"type":"GET","key":"config","extra":"rejected"}`),
+			want:    nil,
+			wantErr: true,
 		},
 		{
 			name:    "invalid JSON",
@@ -104,14 +101,14 @@ func TestFromJSON(t *testing.T) {
 		{
 			name:    "empty JSON object",
 			data:    []byte(`{}`),
-			want:    &command{},
-			wantErr: false,
+			want:    nil,
+			wantErr: true,
 		},
 		{
 			name:    "null JSON",
 			data:    []byte(`null`),
-			want:    &command{},
-			wantErr: false,
+			want:    nil,
+			wantErr: true,
 		},
 		{
 			name:    "empty byte slice",
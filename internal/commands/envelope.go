@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// SchemaVersion is the wire format version written by ToJSON. Version 0 is
+// the original flat {type,key,value} message without a version field.
+const SchemaVersion = 1
+
+// envelope is the version 1 message
+type envelope struct {
+	Version  int           `json:"version"`
+	Type     CommandType   `json:"type"`
+	Args     *envelopeArgs `json:"args,omitempty"`
+	Metadata *metadata     `json:"metadata,omitempty"`
+}
+
+// envelopeArgs are the arguments of a version 1 message
+type envelopeArgs struct {
+	Namespace string   `json:"namespace,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Value     string   `json:"value,omitempty"`
+	Extra     []string `json:"extra,omitempty"`
+}
+
+// metadata describes a version 1 message rather than the command itself
+type metadata struct {
+	Auth *Auth `json:"auth,omitempty"`
+}
+
+// wireMessage holds the fields of every known version, so a message is
+// decoded once. Args is a list in version 0 and an object in version 1.
+type wireMessage struct {
+	Version  *int            `json:"version"`
+	Type     CommandType     `json:"type"`
+	Args     json.RawMessage `json:"args"`
+	Metadata *metadata       `json:"metadata"`
+
+	// Version 0 only
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Namespace string `json:"namespace"`
+	Auth      *Auth  `json:"auth"`
+}
+
+// Marshal encodes the command in the given wire format version. Older
+// versions are written for servers that were not upgraded yet.
+func Marshal(cmd Command, version int) ([]byte, error) {
+	switch version {
+	case 0:
+		return json.Marshal(copyCommand(cmd))
+	case 1:
+		e := envelope{Version: 1, Type: cmd.GetType()}
+		args := envelopeArgs{
+			Namespace: cmd.GetNamespace(),
+			Key:       cmd.GetKey(),
+			Value:     cmd.GetValue(),
+			Extra:     cmd.GetArgs(),
+		}
+		if args.Namespace != "" || args.Key != "" || args.Value != "" || len(args.Extra) > 0 {
+			e.Args = &args
+		}
+		if auth := cmd.GetAuth(); auth != nil {
+			e.Metadata = &metadata{Auth: auth}
+		}
+		return json.Marshal(e)
+	default:
+		return nil, fmt.Errorf("unsupported schema version %d", version)
+	}
+}
+
+// FromJSON decodes a command of any version up to SchemaVersion. Unknown
+// fields, trailing data and a missing type are rejected. A newer version is
+// a retryable error, a newer server may read it. Invalid UTF-8 is rejected
+// rather than replaced; the limits are checked by CommandRegistry.Validate.
+func FromJSON(data []byte) (Command, error) {
+	if !utf8.Valid(data) {
+		return nil, &ValidationError{Code: CodeInvalidUTF8, Field: "message", Detail: "not valid UTF-8"}
+	}
+
+	var msg wireMessage
+	if err := decodeStrict(data, &msg); err != nil {
+		return nil, malformed(err)
+	}
+	if msg.Type == "" {
+		return nil, &ValidationError{Code: CodeMalformed, Field: "message", Detail: "missing command type"}
+	}
+
+	switch {
+	case msg.Version == nil:
+		return fromVersion0(msg)
+	case *msg.Version == 1:
+		return fromVersion1(msg)
+	case *msg.Version > SchemaVersion:
+		return nil, Retryable(&ValidationError{Code: CodeUnsupportedVersion, Field: "message",
+			Detail: fmt.Sprintf("schema version %d is newer than %d", *msg.Version, SchemaVersion)})
+	default:
+		return nil, &ValidationError{Code: CodeMalformed, Field: "message",
+			Detail: fmt.Sprintf("invalid schema version %d", *msg.Version)}
+	}
+}
+
+func fromVersion0(msg wireMessage) (Command, error) {
+	if msg.Metadata != nil {
+		return nil, malformed(fmt.Errorf("metadata requires a schema version"))
+	}
+	cmd := &command{
+		Type:      msg.Type,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Namespace: msg.Namespace,
+		Auth:      msg.Auth,
+	}
+	if len(msg.Args) > 0 {
+		if err := decodeStrict(msg.Args, &cmd.Args); err != nil {
+			return nil, malformed(err)
+		}
+	}
+	return cmd, nil
+}
+
+func fromVersion1(msg wireMessage) (Command, error) {
+	if msg.Key != "" || msg.Value != "" || msg.Namespace != "" || msg.Auth != nil {
+		return nil, malformed(fmt.Errorf("key, value, namespace and auth belong in args and metadata in version 1"))
+	}
+	cmd := &command{Type: msg.Type}
+	if len(msg.Args) > 0 {
+		var args envelopeArgs
+		if err := decodeStrict(msg.Args, &args); err != nil {
+			return nil, malformed(err)
+		}
+		cmd.Key, cmd.Value, cmd.Namespace, cmd.Args = args.Key, args.Value, args.Namespace, args.Extra
+	}
+	if msg.Metadata != nil {
+		cmd.Auth = msg.Metadata.Auth
+	}
+	return cmd, nil
+}
+
+// decodeStrict decodes a single JSON value without unknown fields
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the message")
+	}
+	return nil
+}
+
+func malformed(err error) error {
+	return &ValidationError{Code: CodeMalformed, Field: "message",
+		Detail: fmt.Sprintf("failed to unmarshal command: %v", err)}
+}
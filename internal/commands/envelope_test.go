@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshal_Versions(t *testing.T) {
+	registry := NewCommandRegistry()
+	signed := WithAuth(NewCommand(GetItem, "user:1", ""), &Auth{Client: "dashboard", KeyID: "k1", Timestamp: 1, Nonce: "n", Signature: []byte{1}})
+	cmds := []Command{signed}
+	for _, line := range []string{"add user:1 john doe", "mget a b c", "insertafter a b value", "getall", "resize read 1 4"} {
+		cmd, err := registry.ParseCommand(line)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", line, err)
+		}
+		cmds = append(cmds, WithNamespace(cmd, "prices"))
+	}
+
+	// Every version this build writes is read back unchanged
+	for version := 0; version <= SchemaVersion; version++ {
+		for _, cmd := range cmds {
+			data, err := Marshal(cmd, version)
+			if err != nil {
+				t.Fatalf("Marshal(%s, %d) error = %v", cmd.GetType(), version, err)
+			}
+			got, err := FromJSON(data)
+			if err != nil {
+				t.Fatalf("FromJSON(%s) error = %v", data, err)
+			}
+			if !reflect.DeepEqual(got, copyCommand(cmd)) {
+				t.Errorf("FromJSON(%s) = %+v, want %+v", data, got, cmd)
+			}
+		}
+	}
+	if _, err := Marshal(signed, SchemaVersion+1); err == nil {
+		t.Error("Expected error for unknown schema version")
+	}
+}
+
+func TestFromJSON_Compatibility(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		want      *command
+		code      ErrorCode
+		retryable bool
+	}{
+		{
+			name: "version 0 message",
+			data: `{"type":"insertAfter","key":"b","value":"v","namespace":"prices","args":["a"],"auth":{"client":"c","key_id":"k","ts":1,"nonce":"n"}}`,
+			want: &command{Type: InsertAfter, Key: "b", Value: "v", Namespace: "prices", Args: []string{"a"},
+				Auth: &Auth{Client: "c", KeyID: "k", Timestamp: 1, Nonce: "n"}},
+		},
+		{
+			name: "version 1 message",
+			data: `{"version":1,"type":"insertAfter","args":{"namespace":"prices","key":"b","value":"v","extra":["a"]},"metadata":{"auth":{"client":"c","key_id":"k","ts":1,"nonce":"n"}}}`,
+			want: &command{Type: InsertAfter, Key: "b", Value: "v", Namespace: "prices", Args: []string{"a"},
+				Auth: &Auth{Client: "c", KeyID: "k", Timestamp: 1, Nonce: "n"}},
+		},
+		{name: "newer version", data: `{"version":2,"type":"addItem","args":{"key":"a","ttl":5}}`, code: CodeUnsupportedVersion, retryable: true},
+		{name: "unknown field", data: `{"version":1,"type":"addItem","args":{"key":"a","ttl":5}}`, code: CodeMalformed},
+		{name: "unknown metadata", data: `{"version":1,"type":"addItem","metadata":{"trace":"x"}}`, code: CodeMalformed},
+		{name: "version 0 fields in version 1", data: `{"version":1,"type":"addItem","key":"a"}`, code: CodeMalformed},
+		{name: "version 1 args in version 0", data: `{"type":"addItem","args":{"key":"a"}}`, code: CodeMalformed},
+		{name: "metadata in version 0", data: `{"type":"addItem","metadata":{}}`, code: CodeMalformed},
+		{name: "invalid version", data: `{"version":0,"type":"addItem"}`, code: CodeMalformed},
+		{name: "missing type", data: `{"version":1,"args":{"key":"a"}}`, code: CodeMalformed},
+		{name: "trailing data", data: `{"type":"getItem","key":"a"} {"type":"deleteItem","key":"a"}`, code: CodeMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromJSON([]byte(tt.data))
+			if tt.want != nil {
+				if err != nil {
+					t.Fatalf("FromJSON() error = %v", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("FromJSON() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+			if Code(err) != tt.code {
+				t.Errorf("FromJSON() error = %v, want code %s", err, tt.code)
+			}
+			if IsRetryable(err) != tt.retryable {
+				t.Errorf("IsRetryable(%v) = %v, want %v", err, IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+}
+
+func TestCommandRegistry_ValidateMissingKeys(t *testing.T) {
+	registry := NewCommandRegistry()
+	tests := []struct {
+		name string
+		data string
+		want ErrorCode
+	}{
+		{"get without key", `{"version":1,"type":"getItem"}`, CodeMissingKey},
+		{"insert without mark", `{"version":1,"type":"insertAfter","args":{"key":"b","value":"v"}}`, CodeMissingKey},
+		{"mget with empty key", `{"version":1,"type":"getMany","args":{"extra":["a",""]}}`, CodeMissingKey},
+		{"getall", `{"version":1,"type":"getAllItems"}`, ""},
+		{"unknown type", `{"version":1,"type":"fromNewerVersion"}`, ""},
+	}
+	for _, tt := range tests {
+		cmd, err := FromJSON([]byte(tt.data))
+		if err != nil {
+			t.Fatalf("%s: FromJSON() error = %v", tt.name, err)
+		}
+		if got := Code(registry.Validate(cmd)); got != tt.want {
+			t.Errorf("%s: Code(Validate()) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package commands
+
+import "errors"
+
+// RetryableError marks a handler error that may not occur when the command
+// is executed again, possibly by another server. Other handler errors are
+// permanent.
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable wraps err to mark it as retryable
+func Retryable(err error) error {
+	return &RetryableError{Err: err}
+}
+
+// CodedError is a handler error with a code for clients, e.g. a command
+// denied by the access policy
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable tells whether executing the command again may succeed
+func IsRetryable(err error) bool {
+	var retryableErr *RetryableError
+	return errors.As(err, &retryableErr)
+}
@@ -0,0 +1,386 @@
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// msgpackCodec encodes the version 1 envelope as a MessagePack map with the
+// JSON field names. Only the formats the envelope needs are supported.
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return ContentTypeMsgPack
+}
+
+func (msgpackCodec) Marshal(cmd Command) ([]byte, error) {
+	var w msgpackWriter
+	args := msgpackArgs(cmd)
+	auth := cmd.GetAuth()
+
+	w.mapHeader(2 + boolCount(len(args) > 0, auth != nil))
+	w.str("version")
+	w.int(SchemaVersion)
+	w.str("type")
+	w.str(string(cmd.GetType()))
+	if len(args) > 0 {
+		w.str("args")
+		w.mapHeader(len(args))
+		for _, arg := range args {
+			w.str(arg.name)
+			if arg.name == "extra" {
+				w.arrayHeader(len(cmd.GetArgs()))
+				for _, extra := range cmd.GetArgs() {
+					w.str(extra)
+				}
+			} else {
+				w.str(arg.value)
+			}
+		}
+	}
+	if auth != nil {
+		w.str("metadata")
+		w.mapHeader(1)
+		w.str("auth")
+		w.mapHeader(4 + boolCount(len(auth.Signature) > 0))
+		w.str("client")
+		w.str(auth.Client)
+		w.str("key_id")
+		w.str(auth.KeyID)
+		w.str("ts")
+		w.int(auth.Timestamp)
+		w.str("nonce")
+		w.str(auth.Nonce)
+		if len(auth.Signature) > 0 {
+			w.str("sig")
+			w.bin(auth.Signature)
+		}
+	}
+	return w.buf, nil
+}
+
+// msgpackArgs lists the non-empty args fields in envelope order, the value
+// of extra is written separately
+func msgpackArgs(cmd Command) []struct{ name, value string } {
+	var args []struct{ name, value string }
+	for _, arg := range []struct{ name, value string }{
+		{"namespace", cmd.GetNamespace()}, {"key", cmd.GetKey()}, {"value", cmd.GetValue()},
+	} {
+		if arg.value != "" {
+			args = append(args, arg)
+		}
+	}
+	if len(cmd.GetArgs()) > 0 {
+		args = append(args, struct{ name, value string }{name: "extra"})
+	}
+	return args
+}
+
+func (msgpackCodec) Unmarshal(data []byte) (Command, error) {
+	r := msgpackReader{data: data}
+	cmd := &command{}
+	var version uint64
+
+	err := r.fields(func(field string) error {
+		switch field {
+		case "version":
+			n, err := r.int()
+			if n < 0 {
+				return errors.New("negative version")
+			}
+			version = uint64(n)
+			return err
+		case "type":
+			s, err := r.str()
+			cmd.Type = CommandType(s)
+			return err
+		case "args":
+			return r.fields(func(field string) (err error) {
+				switch field {
+				case "namespace":
+					cmd.Namespace, err = r.str()
+				case "key":
+					cmd.Key, err = r.str()
+				case "value":
+					cmd.Value, err = r.str()
+				case "extra":
+					cmd.Args, err = r.strs()
+				default:
+					err = fmt.Errorf("unknown field args.%s", field)
+				}
+				return err
+			})
+		case "metadata":
+			return r.fields(func(field string) error {
+				if field != "auth" {
+					return fmt.Errorf("unknown field metadata.%s", field)
+				}
+				cmd.Auth = &Auth{}
+				return r.fields(func(field string) (err error) {
+					switch field {
+					case "client":
+						cmd.Auth.Client, err = r.str()
+					case "key_id":
+						cmd.Auth.KeyID, err = r.str()
+					case "ts":
+						cmd.Auth.Timestamp, err = r.int()
+					case "nonce":
+						cmd.Auth.Nonce, err = r.str()
+					case "sig":
+						cmd.Auth.Signature, err = r.bin()
+					default:
+						err = fmt.Errorf("unknown field metadata.auth.%s", field)
+					}
+					return err
+				})
+			})
+		default:
+			return fmt.Errorf("unknown field %s", field)
+		}
+	})
+	if err == nil && r.pos != len(r.data) {
+		err = errors.New("unexpected data after the message")
+	}
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, err
+		}
+		return nil, malformed(err)
+	}
+	if err := checkEnvelope(version, cmd.Type); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func boolCount(conditions ...bool) int {
+	n := 0
+	for _, c := range conditions {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+type msgpackWriter struct {
+	buf []byte
+}
+
+func (w *msgpackWriter) mapHeader(n int) {
+	w.header(n, 0x80, 16, 0xde, 0xdf)
+}
+
+func (w *msgpackWriter) arrayHeader(n int) {
+	w.header(n, 0x90, 16, 0xdc, 0xdd)
+}
+
+// header writes a fix, 16 or 32 bit length
+func (w *msgpackWriter) header(n int, fix byte, fixMax int, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		w.buf = append(w.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, code16)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, code32)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+}
+
+func (w *msgpackWriter) str(s string) {
+	switch n := len(s); {
+	case n < 32:
+		w.buf = append(w.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xda)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xdb)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, s...)
+}
+
+func (w *msgpackWriter) bin(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		w.buf = append(w.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		w.buf = append(w.buf, 0xc5)
+		w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(n))
+	default:
+		w.buf = append(w.buf, 0xc6)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	}
+	w.buf = append(w.buf, b...)
+}
+
+func (w *msgpackWriter) int(n int64) {
+	if n >= 0 && n < 128 {
+		w.buf = append(w.buf, byte(n))
+		return
+	}
+	w.buf = append(w.buf, 0xd3)
+	w.buf = binary.BigEndian.AppendUint64(w.buf, uint64(n))
+}
+
+// msgpackReader reads the formats written by msgpackWriter and the other
+// integer, string and length formats of the specification
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+var errMsgPackShort = errors.New("unexpected end of message")
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errMsgPackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *msgpackReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// uint reads a big endian unsigned integer of size bytes
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+// length reads a fix, 8, 16 or 32 bit length of the given kind. A zero
+// fixMask means the kind has no fix format.
+func (r *msgpackReader) length(kind string, fixMask, fixBits byte, codes [3]byte) (int, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	if fixMask != 0 && c&^fixMask == fixBits {
+		return int(c & fixMask), nil
+	}
+	for i, code := range codes {
+		if code != 0 && c == code {
+			n, err := r.uint(1 << i)
+			return int(n), err
+		}
+	}
+	return 0, fmt.Errorf("expected %s, got type 0x%02x", kind, c)
+}
+
+func (r *msgpackReader) mapLen() (int, error) {
+	return r.length("map", 0x0f, 0x80, [3]byte{0, 0xde, 0xdf})
+}
+
+func (r *msgpackReader) str() (string, error) {
+	n, err := r.length("string", 0x1f, 0xa0, [3]byte{0xd9, 0xda, 0xdb})
+	if err != nil {
+		return "", err
+	}
+	b, err := r.next(n)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", &ValidationError{Code: CodeInvalidUTF8, Field: "message", Detail: "not valid UTF-8"}
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) strs() ([]string, error) {
+	n, err := r.length("array", 0x0f, 0x90, [3]byte{0, 0xdc, 0xdd})
+	if err != nil {
+		return nil, err
+	}
+	// Every element takes at least one byte
+	if n > len(r.data)-r.pos {
+		return nil, errMsgPackShort
+	}
+	strs := make([]string, n)
+	for i := range strs {
+		if strs[i], err = r.str(); err != nil {
+			return nil, err
+		}
+	}
+	return strs, nil
+}
+
+func (r *msgpackReader) bin() ([]byte, error) {
+	n, err := r.length("binary", 0, 0, [3]byte{0xc4, 0xc5, 0xc6})
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.next(n)
+	return append([]byte(nil), b...), err
+}
+
+func (r *msgpackReader) int() (int64, error) {
+	c, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case c < 0x80:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0xcc && c <= 0xcf: // uint 8 to 64
+		n, err := r.uint(1 << (c - 0xcc))
+		if n > math.MaxInt64 {
+			return 0, errors.New("integer overflows int64")
+		}
+		return int64(n), err
+	case c >= 0xd0 && c <= 0xd3: // int 8 to 64
+		size := 1 << (c - 0xd0)
+		n, err := r.uint(size)
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, err
+	}
+	return 0, fmt.Errorf("expected integer, got type 0x%02x", c)
+}
+
+// fields reads a map with string keys, calling read for each key to read
+// its value. Duplicate keys are rejected.
+func (r *msgpackReader) fields(read func(field string) error) error {
+	n, err := r.mapLen()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, n)
+	for i := 0; i < n; i++ {
+		field, err := r.str()
+		if err != nil {
+			return err
+		}
+		if seen[field] {
+			return fmt.Errorf("duplicate field %s", field)
+		}
+		seen[field] = true
+		if err := read(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
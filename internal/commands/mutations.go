@@ -0,0 +1,301 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"eoracle-client-server/internal/output"
+	"eoracle-client-server/internal/storage"
+)
+
+const (
+	Incr        CommandType = "incr"
+	IncrBy      CommandType = "incrBy"
+	Decr        CommandType = "decr"
+	IncrByFloat CommandType = "incrByFloat"
+	Append      CommandType = "append"
+	SetRange    CommandType = "setRange"
+	GetSet      CommandType = "getSet"
+)
+
+// MaxSetRangeOffset bounds setrange offsets even without a value size limit,
+// since the value is padded up to the offset
+const MaxSetRangeOffset = 512 << 20
+
+var (
+	ErrNotInteger    = errors.New("value is not an integer")
+	ErrNotFloat      = errors.New("value is not a valid float")
+	ErrOverflow      = errors.New("increment or decrement would overflow")
+	ErrInvalidOffset = errors.New("invalid offset")
+)
+
+// registerMutationCommands registers the atomic read-modify-write commands.
+// Their handlers run inside storage.Update, under the map write lock, and
+// check the size of the values they build against the current limits.
+func (r *commandRegistry) registerMutationCommands() {
+	r.Register("incr", CommandSpec{
+		Type:     Incr,
+		Category: WriteCategory,
+		Parser:   keyParser(Incr, "incr"),
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			return handleIncrBy(cmd, store, out, 1)
+		},
+	})
+
+	r.Register("decr", CommandSpec{
+		Type:     Decr,
+		Category: WriteCategory,
+		Parser:   keyParser(Decr, "decr"),
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			return handleIncrBy(cmd, store, out, -1)
+		},
+	})
+
+	r.Register("incrby", CommandSpec{
+		Type:     IncrBy,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 2 {
+				return nil, errors.New("incrby command requires key and increment")
+			}
+			if _, err := strconv.ParseInt(args[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("incrby increment: %w", ErrNotInteger)
+			}
+			return &command{
+				Type:  IncrBy,
+				Key:   args[0],
+				Value: args[1],
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			delta, err := strconv.ParseInt(cmd.GetValue(), 10, 64)
+			if err != nil {
+				return mutationError(cmd, fmt.Errorf("increment: %w", ErrNotInteger))
+			}
+			return handleIncrBy(cmd, store, out, delta)
+		},
+	})
+
+	r.Register("incrbyfloat", CommandSpec{
+		Type:     IncrByFloat,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 2 {
+				return nil, errors.New("incrbyfloat command requires key and increment")
+			}
+			if _, err := parseFloat(args[1]); err != nil {
+				return nil, fmt.Errorf("incrbyfloat increment: %w", err)
+			}
+			return &command{
+				Type:  IncrByFloat,
+				Key:   args[0],
+				Value: args[1],
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			delta, err := parseFloat(cmd.GetValue())
+			if err != nil {
+				return mutationError(cmd, fmt.Errorf("increment: %w", err))
+			}
+			value, err := store.Update(cmd.GetKey(), func(current string, exists bool) (string, error) {
+				number := 0.0
+				if exists {
+					parsed, err := parseFloat(current)
+					if err != nil {
+						return "", err
+					}
+					number = parsed
+				}
+				result := number + delta
+				if math.IsInf(result, 0) || math.IsNaN(result) {
+					return "", ErrOverflow
+				}
+				return strconv.FormatFloat(result, 'f', -1, 64), nil
+			})
+			return writeMutationResult(out, cmd, value, err)
+		},
+	})
+
+	r.Register("append", CommandSpec{
+		Type:     Append,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 2 {
+				return nil, errors.New("append command requires key and value")
+			}
+			return &command{
+				Type:  Append,
+				Key:   args[0],
+				Value: strings.Join(args[1:], " "),
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			value, err := store.Update(cmd.GetKey(), func(current string, exists bool) (string, error) {
+				if err := r.validator.validateValueSize(len(current) + len(cmd.GetValue())); err != nil {
+					return "", err
+				}
+				return current + cmd.GetValue(), nil
+			})
+			return writeMutationResult(out, cmd, value, err)
+		},
+	})
+
+	r.Register("setrange", CommandSpec{
+		Type:     SetRange,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 3 {
+				return nil, errors.New("setrange command requires key, offset and value")
+			}
+			if offset, err := strconv.Atoi(args[1]); err != nil || offset < 0 {
+				return nil, errors.New("setrange offset must be a non-negative integer")
+			}
+			return &command{
+				Type:  SetRange,
+				Key:   args[0],
+				Value: strings.Join(args[2:], " "),
+				Args:  []string{args[1]},
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			if len(cmd.GetArgs()) < 1 {
+				return errors.New("setrange command requires offset")
+			}
+			offset, err := strconv.Atoi(cmd.GetArgs()[0])
+			if err != nil || offset < 0 {
+				return mutationError(cmd, fmt.Errorf("%w: must be a non-negative integer", ErrInvalidOffset))
+			}
+			if offset > MaxSetRangeOffset {
+				return mutationError(cmd, fmt.Errorf("%w: exceeds the limit of %d", ErrInvalidOffset, MaxSetRangeOffset))
+			}
+			value, err := store.Update(cmd.GetKey(), func(current string, exists bool) (string, error) {
+				if err := r.validator.validateValueSize(max(len(current), offset+len(cmd.GetValue()))); err != nil {
+					return "", err
+				}
+				return setRange(current, offset, cmd.GetValue()), nil
+			})
+			return writeMutationResult(out, cmd, value, err)
+		},
+	})
+
+	r.Register("getset", CommandSpec{
+		Type:     GetSet,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 2 {
+				return nil, errors.New("getset command requires key and value")
+			}
+			return &command{
+				Type:  GetSet,
+				Key:   args[0],
+				Value: strings.Join(args[1:], " "),
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			var previous string
+			var existed bool
+			if _, err := store.Update(cmd.GetKey(), func(current string, exists bool) (string, error) {
+				previous, existed = current, exists
+				return cmd.GetValue(), nil
+			}); err != nil {
+				return err
+			}
+			if existed {
+				writeItems(out, storage.KeyValue{Key: cmd.GetKey(), Value: previous})
+			}
+			log.Printf("Replaced item: %s = %s (previous: %q)", cmd.GetKey(), cmd.GetValue(), previous)
+			return nil
+		},
+	})
+}
+
+// keyParser parses commands that take a single key
+func keyParser(cmdType CommandType, name string) func(args []string) (Command, error) {
+	return func(args []string) (Command, error) {
+		if len(args) < 1 {
+			return nil, fmt.Errorf("%s command requires key", name)
+		}
+		return &command{
+			Type: cmdType,
+			Key:  args[0],
+		}, nil
+	}
+}
+
+// handleIncrBy adds delta to the integer stored at the command key
+func handleIncrBy(cmd Command, store storage.Storage, out output.Output, delta int64) error {
+	value, err := store.Update(cmd.GetKey(), func(current string, exists bool) (string, error) {
+		var number int64
+		if exists {
+			parsed, err := strconv.ParseInt(current, 10, 64)
+			if err != nil {
+				return "", ErrNotInteger
+			}
+			number = parsed
+		}
+		if (delta > 0 && number > math.MaxInt64-delta) || (delta < 0 && number < math.MinInt64-delta) {
+			return "", ErrOverflow
+		}
+		return strconv.FormatInt(number+delta, 10), nil
+	})
+	return writeMutationResult(out, cmd, value, err)
+}
+
+// writeMutationResult writes the new value to the output, or returns the
+// failure of the mutation. A value exceeding the limits returns its
+// validation error, like a command rejected before it was handled.
+func writeMutationResult(out output.Output, cmd Command, value string, err error) error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		log.Printf("Failed to update item %s: %v", cmd.GetKey(), err)
+		return validationErr
+	}
+	if err != nil {
+		return mutationError(cmd, err)
+	}
+	writeItems(out, storage.KeyValue{Key: cmd.GetKey(), Value: value})
+	log.Printf("Updated item: %s = %s", cmd.GetKey(), value)
+	return nil
+}
+
+// mutationError returns the failure of a mutation with the code of its
+// cause. It is permanent: the command would fail the same way again.
+func mutationError(cmd Command, err error) error {
+	log.Printf("Failed to update item %s: %v", cmd.GetKey(), err)
+	var code ErrorCode
+	switch {
+	case errors.Is(err, ErrNotInteger):
+		code = CodeNotInteger
+	case errors.Is(err, ErrNotFloat):
+		code = CodeNotFloat
+	case errors.Is(err, ErrOverflow):
+		code = CodeOverflow
+	case errors.Is(err, ErrInvalidOffset):
+		code = CodeInvalidOffset
+	}
+	return &CodedError{Code: code, Err: fmt.Errorf("%s %s: %w", cmd.GetType(), cmd.GetKey(), err)}
+}
+
+// parseFloat parses a finite float
+func parseFloat(s string) (float64, error) {
+	number, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+		return 0, ErrNotFloat
+	}
+	return number, nil
+}
+
+// setRange overwrites current starting at offset, padding with zero bytes
+func setRange(current string, offset int, value string) string {
+	if len(current) < offset {
+		current += strings.Repeat("\x00", offset-len(current))
+	}
+	if offset+len(value) >= len(current) {
+		return current[:offset] + value
+	}
+	return current[:offset] + value + current[offset+len(value):]
+}
@@ -0,0 +1,244 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"eoracle-client-server/internal/storage"
+)
+
+func TestMutationCommands(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	tests := []struct {
+		name       string
+		setup      map[string]string
+		input      string
+		wantOutput string
+		wantCode   ErrorCode
+		wantValue  string
+	}{
+		{
+			name:       "incr missing key",
+			input:      "incr counter",
+			wantOutput: "counter = 1\n",
+			wantValue:  "1",
+		},
+		{
+			name:       "incr existing key",
+			setup:      map[string]string{"counter": "41"},
+			input:      "incr counter",
+			wantOutput: "counter = 42\n",
+			wantValue:  "42",
+		},
+		{
+			name:      "incr non-integer value",
+			setup:     map[string]string{"counter": "abc"},
+			input:     "incr counter",
+			wantCode:  CodeNotInteger,
+			wantValue: "abc",
+		},
+		{
+			name:      "incr overflow",
+			setup:     map[string]string{"counter": "9223372036854775807"},
+			input:     "incr counter",
+			wantCode:  CodeOverflow,
+			wantValue: "9223372036854775807",
+		},
+		{
+			name:       "decr",
+			setup:      map[string]string{"counter": "0"},
+			input:      "decr counter",
+			wantOutput: "counter = -1\n",
+			wantValue:  "-1",
+		},
+		{
+			name:       "incrby negative",
+			setup:      map[string]string{"counter": "10"},
+			input:      "incrby counter -15",
+			wantOutput: "counter = -5\n",
+			wantValue:  "-5",
+		},
+		{
+			name:       "incrbyfloat",
+			setup:      map[string]string{"price": "10.5"},
+			input:      "incrbyfloat price 0.25",
+			wantOutput: "price = 10.75\n",
+			wantValue:  "10.75",
+		},
+		{
+			name:      "incrbyfloat non-float value",
+			setup:     map[string]string{"price": "ten"},
+			input:     "incrbyfloat price 1",
+			wantCode:  CodeNotFloat,
+			wantValue: "ten",
+		},
+		{
+			name:       "append",
+			setup:      map[string]string{"greeting": "hello"},
+			input:      "append greeting  world",
+			wantOutput: "greeting = helloworld\n",
+			wantValue:  "helloworld",
+		},
+		{
+			name:       "setrange inside value",
+			setup:      map[string]string{"greeting": "hello world"},
+			input:      "setrange greeting 6 redis",
+			wantOutput: "greeting = hello redis\n",
+			wantValue:  "hello redis",
+		},
+		{
+			name:       "setrange pads missing key",
+			input:      "setrange greeting 2 hi",
+			wantOutput: "greeting = \x00\x00hi\n",
+			wantValue:  "\x00\x00hi",
+		},
+		{
+			name:       "getset existing key",
+			setup:      map[string]string{"key1": "old"},
+			input:      "getset key1 new",
+			wantOutput: "key1 = old\n",
+			wantValue:  "new",
+		},
+		{
+			name:      "getset missing key",
+			input:     "getset key1 new",
+			wantValue: "new",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spaces := storage.NewNamespaces(storage.Limits{}, nil)
+			store := spaces.Namespace("")
+			for k, v := range tt.setup {
+				store.Add(k, v)
+			}
+			out := &mockOutput{}
+
+			cmd, err := registry.ParseCommand(tt.input)
+			if err != nil {
+				t.Fatalf("ParseCommand() error = %v", err)
+			}
+			err = registry.HandleCommand(cmd, spaces, out)
+			if code := Code(err); code != tt.wantCode || (err != nil) != (tt.wantCode != "") {
+				t.Fatalf("HandleCommand() error = %v, want code %q", err, tt.wantCode)
+			}
+			if tt.wantCode != "" && IsRetryable(err) {
+				t.Errorf("HandleCommand() error = %v, want permanent", err)
+			}
+			if got := out.output.String(); got != tt.wantOutput {
+				t.Errorf("HandleCommand() output = %q, want %q", got, tt.wantOutput)
+			}
+			key := strings.Fields(tt.input)[1]
+			if got, _ := store.Get(key); got != tt.wantValue {
+				t.Errorf("stored value = %q, want %q", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestMutationCommands_ValueSize(t *testing.T) {
+	registry := NewCommandRegistry()
+	if err := registry.SetLimits(Limits{MaxValueSize: 8}); err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+
+	tests := []struct {
+		input      string
+		wantOutput string
+		wantCode   ErrorCode
+		wantValue  string
+	}{
+		{"append key 123", "key = hello123\n", "", "hello123"},
+		{"append key 1234", "", CodeValueTooLong, "hello"},
+		{"setrange key 5 abc", "key = helloabc\n", "", "helloabc"},
+		{"setrange key 6 abc", "", CodeValueTooLong, "hello"},
+		{"setrange key 2000000000 x", "", CodeInvalidOffset, "hello"},
+	}
+	for _, tt := range tests {
+		spaces := storage.NewNamespaces(storage.Limits{}, nil)
+		store := spaces.Namespace("")
+		store.Add("key", "hello")
+		out := &mockOutput{}
+
+		cmd, err := registry.ParseCommand(tt.input)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", tt.input, err)
+		}
+		err = registry.HandleCommand(cmd, spaces, out)
+		if code := Code(err); code != tt.wantCode || (err != nil) != (tt.wantCode != "") {
+			t.Fatalf("HandleCommand(%q) error = %v, want code %q", tt.input, err, tt.wantCode)
+		}
+		if got := out.output.String(); got != tt.wantOutput {
+			t.Errorf("HandleCommand(%q) output = %q, want %q", tt.input, got, tt.wantOutput)
+		}
+		if got, _ := store.Get("key"); got != tt.wantValue {
+			t.Errorf("%q: stored value = %q, want %q", tt.input, got, tt.wantValue)
+		}
+	}
+}
+
+func TestMutationCommands_ParseErrors(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	for _, input := range []string{
+		"incr",
+		"incrby counter",
+		"incrby counter 1.5",
+		"incrbyfloat counter abc",
+		"append key",
+		"setrange key -1 value",
+		"setrange key 1",
+		"getset key",
+	} {
+		if _, err := registry.ParseCommand(input); err == nil {
+			t.Errorf("ParseCommand(%q) expected error", input)
+		}
+	}
+}
+
+func TestMutationCommands_ConcurrentIncr(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	out := &lockedOutput{}
+
+	cmd, err := registry.ParseCommand("incr counter")
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+				t.Errorf("HandleCommand() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _ := spaces.Namespace("").Get("counter"); got != fmt.Sprint(100) {
+		t.Errorf("counter = %s, want 100", got)
+	}
+}
+
+// lockedOutput serializes writes to the mock output for concurrent tests
+type lockedOutput struct {
+	mu  sync.Mutex
+	out mockOutput
+}
+
+func (l *lockedOutput) Write(s string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(s)
+}
+
+func (l *lockedOutput) Close() error {
+	return nil
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// protobufCodec encodes the version 1 envelope in the Protobuf wire format
+// of command.proto. Unknown fields are rejected like in FromJSON.
+type protobufCodec struct{}
+
+// Protobuf wire types
+const (
+	protoVarint = 0
+	protoBytes  = 2
+)
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Marshal(cmd Command) ([]byte, error) {
+	var args []byte
+	args = protoAppendString(args, 1, cmd.GetNamespace())
+	args = protoAppendString(args, 2, cmd.GetKey())
+	args = protoAppendString(args, 3, cmd.GetValue())
+	for _, extra := range cmd.GetArgs() {
+		args = protoAppendBytes(args, 4, []byte(extra))
+	}
+
+	var buf []byte
+	buf = protoAppendVarint(buf, 1, SchemaVersion)
+	buf = protoAppendString(buf, 2, string(cmd.GetType()))
+	if len(args) > 0 {
+		buf = protoAppendBytes(buf, 3, args)
+	}
+	if auth := cmd.GetAuth(); auth != nil {
+		var a []byte
+		a = protoAppendString(a, 1, auth.Client)
+		a = protoAppendString(a, 2, auth.KeyID)
+		if auth.Timestamp != 0 {
+			a = protoAppendVarint(a, 3, uint64(auth.Timestamp))
+		}
+		a = protoAppendString(a, 4, auth.Nonce)
+		if len(auth.Signature) > 0 {
+			a = protoAppendBytes(a, 5, auth.Signature)
+		}
+		// metadata is written even for an empty auth, so it decodes to non-nil
+		buf = protoAppendBytes(buf, 4, protoAppendBytes(nil, 1, a))
+	}
+	return buf, nil
+}
+
+func (protobufCodec) Unmarshal(data []byte) (Command, error) {
+	cmd := &command{}
+	var version uint64
+
+	err := protoFields(data, func(num int, typ int, v protoValue) (err error) {
+		switch {
+		case num == 1 && typ == protoVarint:
+			version = v.n
+		case num == 2 && typ == protoBytes:
+			var s string
+			s, err = v.str()
+			cmd.Type = CommandType(s)
+		case num == 3 && typ == protoBytes:
+			err = protoFields(v.b, func(num int, typ int, v protoValue) (err error) {
+				if typ != protoBytes || num < 1 || num > 4 {
+					return fmt.Errorf("unknown field args.%d", num)
+				}
+				s, err := v.str()
+				switch num {
+				case 1:
+					cmd.Namespace = s
+				case 2:
+					cmd.Key = s
+				case 3:
+					cmd.Value = s
+				case 4:
+					cmd.Args = append(cmd.Args, s)
+				}
+				return err
+			})
+		case num == 4 && typ == protoBytes:
+			err = protoFields(v.b, func(num int, typ int, v protoValue) error {
+				if num != 1 || typ != protoBytes {
+					return fmt.Errorf("unknown field metadata.%d", num)
+				}
+				cmd.Auth = &Auth{}
+				return protoFields(v.b, func(num int, typ int, v protoValue) (err error) {
+					switch {
+					case num == 1 && typ == protoBytes:
+						cmd.Auth.Client, err = v.str()
+					case num == 2 && typ == protoBytes:
+						cmd.Auth.KeyID, err = v.str()
+					case num == 3 && typ == protoVarint:
+						cmd.Auth.Timestamp = int64(v.n)
+					case num == 4 && typ == protoBytes:
+						cmd.Auth.Nonce, err = v.str()
+					case num == 5 && typ == protoBytes:
+						cmd.Auth.Signature = append([]byte(nil), v.b...)
+					default:
+						err = fmt.Errorf("unknown field metadata.auth.%d", num)
+					}
+					return err
+				})
+			})
+		default:
+			err = fmt.Errorf("unknown field %d", num)
+		}
+		return err
+	})
+	if err != nil {
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			return nil, err
+		}
+		return nil, malformed(err)
+	}
+	if err := checkEnvelope(version, cmd.Type); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func protoAppendTag(buf []byte, num, typ int) []byte {
+	return binary.AppendUvarint(buf, uint64(num<<3|typ))
+}
+
+func protoAppendVarint(buf []byte, num int, n uint64) []byte {
+	return binary.AppendUvarint(protoAppendTag(buf, num, protoVarint), n)
+}
+
+func protoAppendBytes(buf []byte, num int, b []byte) []byte {
+	buf = binary.AppendUvarint(protoAppendTag(buf, num, protoBytes), uint64(len(b)))
+	return append(buf, b...)
+}
+
+// protoAppendString omits empty strings, the proto3 default
+func protoAppendString(buf []byte, num int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = binary.AppendUvarint(protoAppendTag(buf, num, protoBytes), uint64(len(s)))
+	return append(buf, s...)
+}
+
+// protoValue is a decoded field, n for varints and b for length delimited
+type protoValue struct {
+	n uint64
+	b []byte
+}
+
+func (v protoValue) str() (string, error) {
+	if !utf8.Valid(v.b) {
+		return "", &ValidationError{Code: CodeInvalidUTF8, Field: "message", Detail: "not valid UTF-8"}
+	}
+	return string(v.b), nil
+}
+
+// protoFields reads the varint and length delimited fields of a message,
+// calling read for each. Other wire types are rejected.
+func protoFields(data []byte, read func(num int, typ int, v protoValue) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field tag")
+		}
+		data = data[n:]
+		num, typ := int(tag>>3), int(tag&7)
+		if num == 0 || tag>>3 > 1<<29-1 {
+			return fmt.Errorf("invalid field number %d", tag>>3)
+		}
+
+		var v protoValue
+		switch typ {
+		case protoVarint:
+			if v.n, n = binary.Uvarint(data); n <= 0 {
+				return errors.New("invalid varint")
+			}
+			data = data[n:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errors.New("unexpected end of message")
+			}
+			v.b = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", typ, num)
+		}
+		if err := read(num, typ, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 )
 
@@ -14,35 +15,98 @@ const (
 	DeleteItem  CommandType = "deleteItem"
 	GetItem     CommandType = "getItem"
 	GetAllItems CommandType = "getAllItems"
+	GetMany     CommandType = "getMany"
+
+	MoveToFront  CommandType = "moveToFront"
+	MoveToBack   CommandType = "moveToBack"
+	InsertBefore CommandType = "insertBefore"
+	InsertAfter  CommandType = "insertAfter"
+	PopFirst     CommandType = "popFirst"
+	PopLast      CommandType = "popLast"
+
+	ListNamespaces CommandType = "listNamespaces"
+	DropNamespace  CommandType = "dropNamespace"
+	NamespaceInfo  CommandType = "namespaceInfo"
+
+	Info CommandType = "info"
 )
 
+// InfoMemory is the info section reporting memory usage and evictions
+const InfoMemory = "memory"
+
 const (
 	ReadCategory  CommandCategory = "READ"
 	WriteCategory CommandCategory = "WRITE"
+	// AdminCategory is the policy category of the commands on the server
+	// itself; their spec category only picks the queue
+	AdminCategory CommandCategory = "ADMIN"
+)
+
+// ShardingMode tells how a command is routed in a sharded deployment
+type ShardingMode string
+
+const (
+	// ShardByKey sends the command to the shard owning its key
+	ShardByKey ShardingMode = ""
+	// ShardColocated requires the key and all args (keys too) on one shard
+	ShardColocated ShardingMode = "COLOCATED"
+	// ShardSplitKeys splits the keys in args into one command per shard
+	ShardSplitKeys ShardingMode = "SPLIT_KEYS"
+	// ShardBroadcast sends the command to every shard
+	ShardBroadcast ShardingMode = "BROADCAST"
+	// ShardUnsupported commands need a global order and cannot be sharded
+	ShardUnsupported ShardingMode = "UNSUPPORTED"
 )
 
 type CommandRegistry interface {
 	ParseCommand(line string) (Command, error)
-	HandleCommand(cmd Command, store storage.Storage, out output.Output) error
+	HandleCommand(cmd Command, spaces storage.Namespaces, out output.Output) error
 	IsReadCommand(cmd Command) bool
+	Category(cmd Command) CommandCategory
+	ShardingMode(cmd Command) ShardingMode
+	Scope(cmd Command) CommandScope
+	Validate(cmd Command) error
+	SetLimits(limits Limits) error
+	SetAdmin(admin Admin)
+}
+
+// CommandScope is the part of the map a command accesses
+type CommandScope struct {
+	// Namespace is empty for commands on the namespace registry or the
+	// server, which are not bound to one namespace
+	Namespace string
+	// Keys are the keys the command reads or writes, none for commands on
+	// a whole namespace
+	Keys []string
 }
 
 type commandRegistry struct {
-	byName map[string]CommandSpec
-	byType map[CommandType]CommandSpec
+	byName    map[string]CommandSpec
+	byType    map[CommandType]CommandSpec
+	admin     Admin
+	validator validator
 }
 
+// CommandSpec describes a command. Handler operates on the namespace the
+// command is bound to, NamespaceHandler on the namespace registry itself and
+// AdminHandler on the server executing it; exactly one of them is set.
 type CommandSpec struct {
-	Type     CommandType
-	Category CommandCategory
-	Parser   func(args []string) (Command, error)
-	Handler  func(cmd Command, store storage.Storage, out output.Output) error
+	Type             CommandType
+	Category         CommandCategory
+	Sharding         ShardingMode
+	Parser           func(args []string) (Command, error)
+	Handler          func(cmd Command, store storage.Storage, out output.Output) error
+	NamespaceHandler func(cmd Command, spaces storage.Namespaces, out output.Output) error
+	AdminHandler     func(cmd Command, admin Admin, out output.Output) error
 }
 
+// NewCommandRegistry creates a registry of all commands validating them
+// with the DefaultLimits
 func NewCommandRegistry() CommandRegistry {
 	commandRegistry := &commandRegistry{
-		byName: make(map[string]CommandSpec),
-		byType: make(map[CommandType]CommandSpec),
+		byName:    make(map[string]CommandSpec),
+		byType:    make(map[CommandType]CommandSpec),
+		validator: validator{limits: DefaultLimits},
 	}
 
 	commandRegistry.Register("add", CommandSpec{
@@ -103,7 +167,7 @@ func NewCommandRegistry() CommandRegistry {
 		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
 			value, exists := store.Get(cmd.GetKey())
 			if exists {
-				out.Write(fmt.Sprintf("%s = %s\n", cmd.GetKey(), value))
+				writeItems(out, storage.KeyValue{Key: cmd.GetKey(), Value: value})
 				log.Printf("Retrieved item: %s = %s", cmd.GetKey(), value)
 			} else {
 				log.Printf("Item not found: %s", cmd.GetKey())
@@ -116,17 +180,292 @@ func NewCommandRegistry() CommandRegistry {
 	commandRegistry.Register("getall", CommandSpec{
 		Type:     GetAllItems,
 		Category: ReadCategory,
+		Sharding: ShardBroadcast,
 		Parser: func(args []string) (Command, error) {
-			return &command{Type: GetAllItems}, nil
+			if len(args) > 2 {
+				return nil, errors.New("getall command takes an optional limit and key to start after")
+			}
+			if len(args) == 0 {
+				return &command{Type: GetAllItems}, nil
+			}
+			if _, err := pageLimit(args[0]); err != nil {
+				return nil, err
+			}
+			return &command{Type: GetAllItems, Args: args}, nil
 		},
 		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
-			allItems := store.GetAll()
+			args := cmd.GetArgs()
+			if len(args) == 0 {
+				allItems := store.GetAll()
+				writeItems(out, allItems...)
+				log.Printf("Retrieved all items: %d items", len(allItems))
+				return nil
+			}
+
+			// A page of at most limit pairs after the key, if given
+			limit, err := pageLimit(args[0])
+			if err != nil {
+				return err
+			}
+			after := ""
+			if len(args) > 1 {
+				after = args[1]
+			}
+			items, ok := store.GetPage(after, limit)
+			if !ok {
+				return &CodedError{Code: CodeInvalidCursor, Err: fmt.Errorf("page key not found: %s", after)}
+			}
+			writeItems(out, items...)
+			log.Printf("Retrieved page of items: %d items", len(items))
+			return nil
+		},
+	})
+
+	commandRegistry.Register("mget", CommandSpec{
+		Type:     GetMany,
+		Category: ReadCategory,
+		Sharding: ShardSplitKeys,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 1 {
+				return nil, errors.New("mget command requires at least one key")
+			}
+			return &command{
+				Type: GetMany,
+				Args: args,
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			var found []storage.KeyValue
+			for _, key := range cmd.GetArgs() {
+				if value, exists := store.Get(key); exists {
+					found = append(found, storage.KeyValue{Key: key, Value: value})
+				}
+			}
+			writeItems(out, found...)
+			log.Printf("Retrieved many items: %d of %d found", len(found), len(cmd.GetArgs()))
+			return nil
+		},
+	})
+
+	commandRegistry.Register("movetofront", CommandSpec{
+		Type:     MoveToFront,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 1 {
+				return nil, errors.New("movetofront command requires key")
+			}
+			return &command{
+				Type: MoveToFront,
+				Key:  args[0],
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			if store.MoveToFront(cmd.GetKey()) {
+				log.Printf("Moved item to front: %s", cmd.GetKey())
+			} else {
+				log.Printf("Item not found for move: %s", cmd.GetKey())
+			}
+			return nil
+		},
+	})
+
+	commandRegistry.Register("movetoback", CommandSpec{
+		Type:     MoveToBack,
+		Category: WriteCategory,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 1 {
+				return nil, errors.New("movetoback command requires key")
+			}
+			return &command{
+				Type: MoveToBack,
+				Key:  args[0],
+			}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			if store.MoveToBack(cmd.GetKey()) {
+				log.Printf("Moved item to back: %s", cmd.GetKey())
+			} else {
+				log.Printf("Item not found for move: %s", cmd.GetKey())
+			}
+			return nil
+		},
+	})
+
+	commandRegistry.Register("insertbefore", CommandSpec{
+		Type:     InsertBefore,
+		Category: WriteCategory,
+		Sharding: ShardColocated,
+		Parser:   insertParser(InsertBefore, "insertbefore"),
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			if len(cmd.GetArgs()) < 1 {
+				return errors.New("insertbefore command requires mark")
+			}
+			mark := cmd.GetArgs()[0]
+			if store.InsertBefore(mark, cmd.GetKey(), cmd.GetValue()) {
+				log.Printf("Inserted item before %s: %s = %s", mark, cmd.GetKey(), cmd.GetValue())
+			} else {
+				log.Printf("Mark not found for insert: %s", mark)
+			}
+			return nil
+		},
+	})
+
+	commandRegistry.Register("insertafter", CommandSpec{
+		Type:     InsertAfter,
+		Category: WriteCategory,
+		Sharding: ShardColocated,
+		Parser:   insertParser(InsertAfter, "insertafter"),
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			if len(cmd.GetArgs()) < 1 {
+				return errors.New("insertafter command requires mark")
+			}
+			mark := cmd.GetArgs()[0]
+			if store.InsertAfter(mark, cmd.GetKey(), cmd.GetValue()) {
+				log.Printf("Inserted item after %s: %s = %s", mark, cmd.GetKey(), cmd.GetValue())
+			} else {
+				log.Printf("Mark not found for insert: %s", mark)
+			}
+			return nil
+		},
+	})
+
+	commandRegistry.Register("popfirst", CommandSpec{
+		Type:     PopFirst,
+		Category: WriteCategory,
+		Sharding: ShardUnsupported,
+		Parser: func(args []string) (Command, error) {
+			return &command{Type: PopFirst}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			item, exists := store.PopFirst()
+			return writePopped(out, item, exists)
+		},
+	})
+
+	commandRegistry.Register("poplast", CommandSpec{
+		Type:     PopLast,
+		Category: WriteCategory,
+		Sharding: ShardUnsupported,
+		Parser: func(args []string) (Command, error) {
+			return &command{Type: PopLast}, nil
+		},
+		Handler: func(cmd Command, store storage.Storage, out output.Output) error {
+			item, exists := store.PopLast()
+			return writePopped(out, item, exists)
+		},
+	})
+
+	commandRegistry.registerMutationCommands()
+	commandRegistry.registerAdminCommands()
+
+	commandRegistry.Register("namespaces", CommandSpec{
+		Type:     ListNamespaces,
+		Category: ReadCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			return &command{Type: ListNamespaces}, nil
+		},
+		NamespaceHandler: func(cmd Command, spaces storage.Namespaces, out output.Output) error {
+			names := spaces.Names()
 			writeData := ""
-			for _, item := range allItems {
-				writeData += fmt.Sprintf("%s = %s\n", item.Key, item.Value)
+			for _, name := range names {
+				if store, exists := spaces.Lookup(name); exists {
+					writeData += fmt.Sprintf("%s: %d items\n", name, store.Size())
+				}
 			}
 			out.Write(writeData)
-			log.Printf("Retrieved all items: %d items", len(allItems))
+			log.Printf("Listed namespaces: %d namespaces", len(names))
+			return nil
+		},
+	})
+
+	commandRegistry.Register("dropns", CommandSpec{
+		Type:     DropNamespace,
+		Category: WriteCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 1 {
+				return nil, errors.New("dropns command requires namespace")
+			}
+			return &command{
+				Type: DropNamespace,
+				Key:  args[0],
+			}, nil
+		},
+		NamespaceHandler: func(cmd Command, spaces storage.Namespaces, out output.Output) error {
+			if spaces.Drop(cmd.GetKey()) {
+				log.Printf("Dropped namespace: %s", cmd.GetKey())
+			} else {
+				log.Printf("Namespace not found for drop: %s", cmd.GetKey())
+			}
+			return nil
+		},
+	})
+
+	commandRegistry.Register("nsinfo", CommandSpec{
+		Type:     NamespaceInfo,
+		Category: ReadCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 1 {
+				return nil, errors.New("nsinfo command requires namespace")
+			}
+			return &command{
+				Type: NamespaceInfo,
+				Key:  args[0],
+			}, nil
+		},
+		NamespaceHandler: func(cmd Command, spaces storage.Namespaces, out output.Output) error {
+			store, exists := spaces.Lookup(cmd.GetKey())
+			if !exists {
+				log.Printf("Namespace not found: %s", cmd.GetKey())
+				return nil
+			}
+			out.Write(fmt.Sprintf("%s: %d items\n", cmd.GetKey(), store.Size()))
+			log.Printf("Retrieved namespace info: %s", cmd.GetKey())
+			return nil
+		},
+	})
+
+	commandRegistry.Register("info", CommandSpec{
+		Type:     Info,
+		Category: ReadCategory,
+		Sharding: ShardBroadcast,
+		Parser: func(args []string) (Command, error) {
+			if len(args) < 1 {
+				return nil, errors.New("info command requires section")
+			}
+			section := strings.ToLower(args[0])
+			if section != InfoMemory {
+				return nil, fmt.Errorf("unknown info section: %s", args[0])
+			}
+			return &command{
+				Type: Info,
+				Key:  section,
+			}, nil
+		},
+		NamespaceHandler: func(cmd Command, spaces storage.Namespaces, out output.Output) error {
+			if cmd.GetKey() != InfoMemory {
+				return fmt.Errorf("unknown info section: %s", cmd.GetKey())
+			}
+
+			var total storage.MemoryStats
+			writeData := ""
+			for _, name := range spaces.Names() {
+				store, exists := spaces.Lookup(name)
+				if !exists {
+					continue
+				}
+				stats := store.MemoryStats()
+				writeData += fmt.Sprintf("%s: entries=%d memory=%d max_entries=%d max_memory=%d policy=%s evictions=%d\n",
+					name, stats.Entries, stats.MemoryBytes, stats.MaxEntries, stats.MaxMemory, stats.Policy, stats.Evictions)
+				total.Entries += stats.Entries
+				total.MemoryBytes += stats.MemoryBytes
+				total.Evictions += stats.Evictions
+			}
+			writeData += fmt.Sprintf("total: entries=%d memory=%d evictions=%d\n", total.Entries, total.MemoryBytes, total.Evictions)
+			out.Write(writeData)
+			log.Printf("Retrieved memory info: %d entries, %d bytes", total.Entries, total.MemoryBytes)
 			return nil
 		},
 	})
@@ -134,6 +473,54 @@ func NewCommandRegistry() CommandRegistry {
 	return commandRegistry
 }
 
+// insertParser parses "<name> <mark> <key> <value>" for positional inserts
+func insertParser(cmdType CommandType, name string) func(args []string) (Command, error) {
+	return func(args []string) (Command, error) {
+		if len(args) < 3 {
+			return nil, fmt.Errorf("%s command requires mark, key and value", name)
+		}
+		return &command{
+			Type:  cmdType,
+			Key:   args[1],
+			Value: strings.Join(args[2:], " "),
+			Args:  []string{args[0]},
+		}, nil
+	}
+}
+
+// writePopped writes a popped item to the output
+func writePopped(out output.Output, item storage.KeyValue, exists bool) error {
+	if !exists {
+		log.Printf("No item to pop")
+		return nil
+	}
+	writeItems(out, item)
+	log.Printf("Popped item: %s = %s", item.Key, item.Value)
+	return nil
+}
+
+// pageLimit parses the positive page size of getall
+func pageLimit(s string) (int, error) {
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("getall limit must be a positive integer: %s", s)
+	}
+	return limit, nil
+}
+
+// writeItems writes items as "key = value" lines in one write, and in
+// structured form to outputs that record them
+func writeItems(out output.Output, items ...storage.KeyValue) {
+	writeData := ""
+	for _, item := range items {
+		writeData += fmt.Sprintf("%s = %s\n", item.Key, item.Value)
+	}
+	out.Write(writeData)
+	if recorder, ok := out.(output.ItemWriter); ok && len(items) > 0 {
+		recorder.WriteItems(items)
+	}
+}
+
 func (r commandRegistry) Register(name string, spec CommandSpec) {
 	r.byName[strings.ToLower(name)] = spec
 	r.byType[spec.Type] = spec
@@ -152,16 +539,40 @@ func (r commandRegistry) ParseCommand(line string) (Command, error) {
 		return nil, fmt.Errorf("unknown command: %s", name)
 	}
 
-	return spec.Parser(args)
+	cmd, err := spec.Parser(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.Validate(cmd); err != nil {
+		return nil, err
+	}
+	return cmd, nil
 }
 
-func (r commandRegistry) HandleCommand(cmd Command, store storage.Storage, out output.Output) error {
+func (r commandRegistry) HandleCommand(cmd Command, spaces storage.Namespaces, out output.Output) error {
 	spec, ok := r.byType[cmd.GetType()]
 	if !ok {
-		return fmt.Errorf("unknown command: %s", cmd.GetType())
+		// During a rolling upgrade a newer server may know the command
+		return Retryable(fmt.Errorf("unknown command: %s", cmd.GetType()))
 	}
 
-	return spec.Handler(cmd, store, out)
+	if spec.AdminHandler != nil {
+		if r.admin == nil {
+			return fmt.Errorf("admin command %s is not available", cmd.GetType())
+		}
+		return spec.AdminHandler(cmd, r.admin, out)
+	}
+	if spec.NamespaceHandler != nil {
+		return spec.NamespaceHandler(cmd, spaces, out)
+	}
+	store := spaces.Namespace(cmd.GetNamespace())
+	err := spec.Handler(cmd, store, out)
+	// A write racing a drop of its namespace may not have been applied, so
+	// it is run again on the new namespace
+	if spec.Category == WriteCategory && storage.Dropped(store) {
+		return Retryable(fmt.Errorf("%s %s: %w", cmd.GetType(), cmd.GetNamespace(), storage.ErrDropped))
+	}
+	return err
 }
 
 func (r commandRegistry) IsReadCommand(cmd Command) bool {
@@ -172,3 +583,105 @@ func (r commandRegistry) IsReadCommand(cmd Command) bool {
 
 	return spec.Category == ReadCategory
 }
+
+// Category returns the category the access policy checks: AdminCategory for
+// admin commands, so rules for reads or writes do not allow them
+func (r commandRegistry) Category(cmd Command) CommandCategory {
+	spec, ok := r.byType[cmd.GetType()]
+	if !ok {
+		return WriteCategory
+	}
+	if spec.AdminHandler != nil {
+		return AdminCategory
+	}
+	return spec.Category
+}
+
+func (r commandRegistry) ShardingMode(cmd Command) ShardingMode {
+	spec, ok := r.byType[cmd.GetType()]
+	if !ok {
+		return ShardUnsupported
+	}
+	return spec.Sharding
+}
+
+// Scope returns the namespace and keys the command accesses, following the
+// keys its sharding mode routes by
+func (r commandRegistry) Scope(cmd Command) CommandScope {
+	spec, ok := r.byType[cmd.GetType()]
+	if !ok || spec.Handler == nil {
+		return CommandScope{}
+	}
+
+	scope := CommandScope{Namespace: cmd.GetNamespace()}
+	if scope.Namespace == "" {
+		scope.Namespace = storage.DefaultNamespace
+	}
+	switch spec.Sharding {
+	case ShardByKey:
+		if cmd.GetKey() != "" {
+			scope.Keys = []string{cmd.GetKey()}
+		}
+	case ShardColocated:
+		scope.Keys = append([]string{cmd.GetKey()}, cmd.GetArgs()...)
+	case ShardSplitKeys:
+		scope.Keys = cmd.GetArgs()
+	}
+	return scope
+}
+
+// Validate checks the command against the limits. Commands received from a
+// queue must be validated again, since anyone can publish raw JSON.
+func (r commandRegistry) Validate(cmd Command) error {
+	if spec, ok := r.byType[cmd.GetType()]; ok && spec.Handler != nil {
+		if err := requireKeys(spec.Sharding, cmd); err != nil {
+			return err
+		}
+	} else if ok && spec.NamespaceHandler != nil && cmd.GetKey() != "" {
+		// Namespace commands name the namespace in the key
+		if err := r.validator.validateName("namespace", cmd.GetKey()); err != nil {
+			return err
+		}
+	}
+	return r.validator.validate(cmd, r.Scope(cmd))
+}
+
+// requireKeys checks that a key command carries the keys its sharding mode
+// routes by, since raw messages may omit them
+func requireKeys(mode ShardingMode, cmd Command) error {
+	var keys []string
+	switch mode {
+	case ShardByKey:
+		keys = []string{cmd.GetKey()}
+	case ShardColocated:
+		keys = append([]string{cmd.GetKey()}, cmd.GetArgs()...)
+	case ShardSplitKeys:
+		keys = cmd.GetArgs()
+	default:
+		return nil
+	}
+	if len(keys) == 0 || (mode == ShardColocated && len(keys) < 2) {
+		return &ValidationError{Code: CodeMissingKey, Field: "key", Detail: fmt.Sprintf("%s command requires keys", cmd.GetType())}
+	}
+	for _, key := range keys {
+		if key == "" {
+			return &ValidationError{Code: CodeMissingKey, Field: "key", Detail: fmt.Sprintf("%s command requires non-empty keys", cmd.GetType())}
+		}
+	}
+	return nil
+}
+
+// SetLimits replaces the limits commands are validated with
+func (r *commandRegistry) SetLimits(limits Limits) error {
+	v, err := newValidator(limits)
+	if err != nil {
+		return err
+	}
+	r.validator = v
+	return nil
+}
+
+// SetAdmin sets the server that admin commands control
+func (r *commandRegistry) SetAdmin(admin Admin) {
+	r.admin = admin
+}
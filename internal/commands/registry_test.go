@@ -2,6 +2,9 @@ package commands
 
 import (
 	"eoracle-client-server/internal/storage"
+	"errors"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -14,6 +17,16 @@ func (m *mockStorage) Add(key, value string) {
 	m.data[key] = value
 }
 
+func (m *mockStorage) Update(key string, fn storage.UpdateFunc) (string, error) {
+	current, exists := m.data[key]
+	value, err := fn(current, exists)
+	if err != nil {
+		return "", err
+	}
+	m.data[key] = value
+	return value, nil
+}
+
 func (m *mockStorage) Delete(key string) bool {
 	if _, exists := m.data[key]; exists {
 		delete(m.data, key)
@@ -28,13 +41,89 @@ func (m *mockStorage) Get(key string) (string, bool) {
 }
 
 func (m *mockStorage) GetAll() []storage.KeyValue {
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	// Sort keys so the output is deterministic
+	sort.Strings(keys)
+
 	var items []storage.KeyValue
-	for k, v := range m.data {
-		items = append(items, storage.KeyValue{Key: k, Value: v})
+	for _, k := range keys {
+		items = append(items, storage.KeyValue{Key: k, Value: m.data[k]})
 	}
 	return items
 }
 
+func (m *mockStorage) GetPage(after string, limit int) ([]storage.KeyValue, bool) {
+	items := m.GetAll()
+	start := 0
+	if after != "" {
+		if _, exists := m.data[after]; !exists {
+			return nil, false
+		}
+		for items[start].Key != after {
+			start++
+		}
+		start++
+	}
+	return items[start:min(start+limit, len(items))], true
+}
+
+func (m *mockStorage) Size() int {
+	return len(m.data)
+}
+
+// Order operations are not supported by the map based mock
+func (m *mockStorage) MoveToFront(key string) bool {
+	return false
+}
+
+func (m *mockStorage) MoveToBack(key string) bool {
+	return false
+}
+
+func (m *mockStorage) InsertBefore(mark, key, value string) bool {
+	return false
+}
+
+func (m *mockStorage) InsertAfter(mark, key, value string) bool {
+	return false
+}
+
+func (m *mockStorage) PopFirst() (storage.KeyValue, bool) {
+	return storage.KeyValue{}, false
+}
+
+func (m *mockStorage) PopLast() (storage.KeyValue, bool) {
+	return storage.KeyValue{}, false
+}
+
+func (m *mockStorage) MemoryStats() storage.MemoryStats {
+	return storage.MemoryStats{Entries: len(m.data)}
+}
+
+// mockNamespaces serves every namespace from the same mock storage
+type mockNamespaces struct {
+	store *mockStorage
+}
+
+func (m *mockNamespaces) Namespace(name string) storage.Storage {
+	return m.store
+}
+
+func (m *mockNamespaces) Lookup(name string) (storage.Storage, bool) {
+	return m.store, true
+}
+
+func (m *mockNamespaces) Drop(name string) bool {
+	return false
+}
+
+func (m *mockNamespaces) Names() []string {
+	return []string{storage.DefaultNamespace}
+}
+
 type mockOutput struct {
 	output strings.Builder
 }
@@ -101,6 +190,67 @@ func TestCommandRegistry_ParseCommand(t *testing.T) {
 				Type: GetAllItems,
 			},
 		},
+		{
+			name:    "valid getall page command",
+			input:   "getall 10 key",
+			wantErr: false,
+			wantCommand: &command{
+				Type: GetAllItems,
+				Args: []string{"10", "key"},
+			},
+		},
+		{
+			name:    "getall invalid limit",
+			input:   "getall 0",
+			wantErr: true,
+		},
+		{
+			name:    "getall too many arguments",
+			input:   "getall 10 key other",
+			wantErr: true,
+		},
+		{
+			name:    "valid insertbefore command",
+			input:   "insertbefore mark key some value",
+			wantErr: false,
+			wantCommand: &command{
+				Type:  InsertBefore,
+				Key:   "key",
+				Value: "some value",
+			},
+		},
+		{
+			name:    "invalid insertafter command - missing value",
+			input:   "insertafter mark key",
+			wantErr: true,
+		},
+		{
+			name:    "invalid movetofront command - missing key",
+			input:   "movetofront",
+			wantErr: true,
+		},
+		{
+			name:    "valid poplast command",
+			input:   "poplast",
+			wantErr: false,
+			wantCommand: &command{
+				Type: PopLast,
+			},
+		},
+		{
+			name:    "valid info command",
+			input:   "info MEMORY",
+			wantErr: false,
+			wantCommand: &command{
+				Type: Info,
+				Key:  InfoMemory,
+			},
+		},
+		{
+			name:    "invalid info command - unknown section",
+			input:   "info cpu",
+			wantErr: true,
+		},
 		{
 			name:    "unknown command",
 			input:   "invalid cmd",
@@ -188,6 +338,29 @@ func TestCommandRegistry_HandleCommand(t *testing.T) {
 			wantErr:    false,
 			wantOutput: "key1 = value1\nkey2 = value2\n",
 		},
+		{
+			name: "getall page",
+			command: &command{
+				Type: GetAllItems,
+				Args: []string{"1", "key1"},
+			},
+			setup: func() {
+				store.Add("key1", "value1")
+				store.Add("key2", "value2")
+				store.Add("key3", "value3")
+			},
+			wantErr:    false,
+			wantOutput: "key2 = value2\n",
+		},
+		{
+			name: "getall page after missing key",
+			command: &command{
+				Type: GetAllItems,
+				Args: []string{"1", "missing"},
+			},
+			setup:   func() {},
+			wantErr: true,
+		},
 		{
 			name: "unknown command type",
 			command: &command{
@@ -205,7 +378,7 @@ func TestCommandRegistry_HandleCommand(t *testing.T) {
 			out.output = strings.Builder{}
 
 			tt.setup()
-			err := registry.HandleCommand(tt.command, store, out)
+			err := registry.HandleCommand(tt.command, &mockNamespaces{store: store}, out)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("HandleCommand() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -245,6 +418,21 @@ func TestCommandRegistry_IsReadCommand(t *testing.T) {
 			command: &command{Type: DeleteItem},
 			want:    false,
 		},
+		{
+			name:    "namespaces command",
+			command: &command{Type: ListNamespaces},
+			want:    true,
+		},
+		{
+			name:    "nsinfo command",
+			command: &command{Type: NamespaceInfo},
+			want:    true,
+		},
+		{
+			name:    "dropns command",
+			command: &command{Type: DropNamespace},
+			want:    false,
+		},
 		{
 			name:    "unknown command",
 			command: &command{Type: "unknown"},
@@ -260,3 +448,274 @@ func TestCommandRegistry_IsReadCommand(t *testing.T) {
 		})
 	}
 }
+
+func TestCommandRegistry_Category(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	tests := []struct {
+		cmdType CommandType
+		want    CommandCategory
+	}{
+		{GetItem, ReadCategory},
+		{AddItem, WriteCategory},
+		{DropNamespace, WriteCategory},
+		{ResizePool, AdminCategory},
+		{Pools, AdminCategory},
+		{Rejections, AdminCategory},
+		{"unknown", WriteCategory},
+	}
+	for _, tt := range tests {
+		if got := registry.Category(&command{Type: tt.cmdType}); got != tt.want {
+			t.Errorf("Category(%s) = %s, want %s", tt.cmdType, got, tt.want)
+		}
+	}
+}
+
+func TestCommandRegistry_Namespaces(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	out := &mockOutput{}
+
+	handle := func(line, namespace string) {
+		t.Helper()
+		cmd, err := registry.ParseCommand(line)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", line, err)
+		}
+		if err := registry.HandleCommand(WithNamespace(cmd, namespace), spaces, out); err != nil {
+			t.Fatalf("HandleCommand(%q) error = %v", line, err)
+		}
+	}
+
+	handle("add key1 value1", "")
+	handle("add key1 team-a", "team-a")
+	handle("add key2 team-a", "team-a")
+
+	// Keys are isolated between namespaces
+	out.output = strings.Builder{}
+	handle("get key1", "team-a")
+	if got := out.output.String(); got != "key1 = team-a\n" {
+		t.Errorf("get in team-a output = %q, want %q", got, "key1 = team-a\n")
+	}
+	out.output = strings.Builder{}
+	handle("get key1", storage.DefaultNamespace)
+	if got := out.output.String(); got != "key1 = value1\n" {
+		t.Errorf("get in default output = %q, want %q", got, "key1 = value1\n")
+	}
+
+	out.output = strings.Builder{}
+	handle("namespaces", "")
+	if got, want := out.output.String(), "default: 1 items\nteam-a: 2 items\n"; got != want {
+		t.Errorf("namespaces output = %q, want %q", got, want)
+	}
+
+	out.output = strings.Builder{}
+	handle("nsinfo team-a", "")
+	if got, want := out.output.String(), "team-a: 2 items\n"; got != want {
+		t.Errorf("nsinfo output = %q, want %q", got, want)
+	}
+
+	handle("dropns team-a", "")
+	if _, exists := spaces.Lookup("team-a"); exists {
+		t.Error("Expected team-a namespace to be dropped")
+	}
+
+	out.output = strings.Builder{}
+	handle("nsinfo team-a", "")
+	if got := out.output.String(); got != "" {
+		t.Errorf("nsinfo for dropped namespace output = %q, want empty", got)
+	}
+}
+
+func TestCommandRegistry_OrderCommands(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	out := &mockOutput{}
+
+	for _, line := range []string{
+		"add a 1",
+		"add b 2",
+		"add c 3",
+		"movetofront c",
+		"movetoback a",
+		"insertbefore b x 9",
+		"insertafter b y 8",
+	} {
+		cmd, err := registry.ParseCommand(line)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", line, err)
+		}
+		if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+			t.Fatalf("HandleCommand(%q) error = %v", line, err)
+		}
+	}
+
+	cmd, _ := registry.ParseCommand("getall")
+	if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+		t.Fatalf("HandleCommand(getall) error = %v", err)
+	}
+	if got, want := out.output.String(), "c = 3\nx = 9\nb = 2\ny = 8\na = 1\n"; got != want {
+		t.Errorf("getall output = %q, want %q", got, want)
+	}
+
+	out.output = strings.Builder{}
+	for _, line := range []string{"popfirst", "poplast"} {
+		cmd, _ := registry.ParseCommand(line)
+		if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+			t.Fatalf("HandleCommand(%q) error = %v", line, err)
+		}
+	}
+	if got, want := out.output.String(), "c = 3\na = 1\n"; got != want {
+		t.Errorf("pop output = %q, want %q", got, want)
+	}
+
+	// A raw command without a mark must be rejected instead of panicking
+	if err := registry.HandleCommand(&command{Type: InsertAfter, Key: "k", Value: "v"}, spaces, out); err == nil {
+		t.Error("Expected error for insertafter without mark")
+	}
+}
+
+func TestCommandRegistry_InfoMemory(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{MaxEntries: 1, Policy: storage.EvictLRU}, nil)
+	out := &mockOutput{}
+
+	spaces.Namespace("").Add("key1", "value1")
+	spaces.Namespace("").Add("key2", "value2")
+
+	cmd, err := registry.ParseCommand("info memory")
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+	if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+		t.Fatalf("HandleCommand() error = %v", err)
+	}
+
+	got := out.output.String()
+	for _, want := range []string{
+		"default: entries=1 ",
+		"max_entries=1 ",
+		"policy=lru evictions=1\n",
+		"total: entries=1 ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("info memory output = %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestCommandRegistry_GetMany(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	out := &mockOutput{}
+
+	spaces.Namespace("").Add("key1", "value1")
+	spaces.Namespace("").Add("key3", "value3")
+
+	if _, err := registry.ParseCommand("mget"); err == nil {
+		t.Error("Expected error for mget without keys")
+	}
+
+	cmd, err := registry.ParseCommand("mget key1 key2 key3")
+	if err != nil {
+		t.Fatalf("ParseCommand() error = %v", err)
+	}
+	if !registry.IsReadCommand(cmd) {
+		t.Error("Expected mget to be a read command")
+	}
+	if err := registry.HandleCommand(cmd, spaces, out); err != nil {
+		t.Fatalf("HandleCommand() error = %v", err)
+	}
+
+	if got, want := out.output.String(), "key1 = value1\nkey3 = value3\n"; got != want {
+		t.Errorf("mget output = %q, want %q", got, want)
+	}
+}
+
+func TestSplitKeys(t *testing.T) {
+	cmd := WithNamespace(NewCommand(GetMany, "", "", "a1", "b1", "a2"), "ns")
+
+	groups := SplitKeys(cmd, func(key string) string { return key[:1] })
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	if got := groups["a"].GetArgs(); strings.Join(got, ",") != "a1,a2" {
+		t.Errorf("Expected group a to have a1,a2, got %v", got)
+	}
+	if got := groups["b"].GetArgs(); strings.Join(got, ",") != "b1" {
+		t.Errorf("Expected group b to have b1, got %v", got)
+	}
+	if groups["a"].GetNamespace() != "ns" || groups["a"].GetType() != GetMany {
+		t.Errorf("Expected split command to keep type and namespace, got %s in %s", groups["a"].GetType(), groups["a"].GetNamespace())
+	}
+}
+
+func TestCommandRegistry_RetryableErrors(t *testing.T) {
+	registry := NewCommandRegistry()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	out := &mockOutput{}
+
+	// A newer server may know the command
+	err := registry.HandleCommand(NewCommand("fromNewerVersion", "key", ""), spaces, out)
+	if !IsRetryable(err) {
+		t.Errorf("Expected retryable error for unknown command, got %v", err)
+	}
+
+	err = registry.HandleCommand(&command{Type: InsertAfter, Key: "k", Value: "v"}, spaces, out)
+	if err == nil || IsRetryable(err) {
+		t.Errorf("Expected permanent error for invalid command, got %v", err)
+	}
+
+	// A write racing a drop of its namespace runs again on the new one
+	dropping := &droppingNamespaces{Namespaces: spaces}
+	err = registry.HandleCommand(NewCommand(AddItem, "key", "value"), dropping, out)
+	if !IsRetryable(err) || !errors.Is(err, storage.ErrDropped) {
+		t.Errorf("Expected retryable dropped error for add, got %v", err)
+	}
+	err = registry.HandleCommand(NewCommand(IncrBy, "counter", "", "1"), dropping, out)
+	if !IsRetryable(err) || !errors.Is(err, storage.ErrDropped) {
+		t.Errorf("Expected retryable dropped error for incrby, got %v", err)
+	}
+	if store, exists := spaces.Lookup(storage.DefaultNamespace); exists && store.Size() != 0 {
+		t.Errorf("Expected no writes to the new namespace, got %d items", store.Size())
+	}
+}
+
+// droppingNamespaces drops every namespace right after handing it out
+type droppingNamespaces struct {
+	storage.Namespaces
+}
+
+func (d *droppingNamespaces) Namespace(name string) storage.Storage {
+	store := d.Namespaces.Namespace(name)
+	d.Drop(name)
+	return store
+}
+
+func TestCommandRegistry_Scope(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	tests := []struct {
+		line      string
+		namespace string
+		want      CommandScope
+	}{
+		{"get user:1", "", CommandScope{Namespace: storage.DefaultNamespace, Keys: []string{"user:1"}}},
+		{"add user:1 alice", "users", CommandScope{Namespace: "users", Keys: []string{"user:1"}}},
+		{"insertafter user:1 user:2 bob", "users", CommandScope{Namespace: "users", Keys: []string{"user:2", "user:1"}}},
+		{"mget a b", "users", CommandScope{Namespace: "users", Keys: []string{"a", "b"}}},
+		{"getall", "users", CommandScope{Namespace: "users"}},
+		{"dropns users", "users", CommandScope{}},
+		{"pools", "users", CommandScope{}},
+	}
+
+	for _, tt := range tests {
+		cmd, err := registry.ParseCommand(tt.line)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", tt.line, err)
+		}
+		if got := registry.Scope(WithNamespace(cmd, tt.namespace)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Scope(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"eoracle-client-server/internal/storage"
+)
+
+// Result is the reply to a command published as a request. Commands that
+// failed carry the error and its code, if it has one.
+type Result struct {
+	Items []Item `json:"items,omitempty"`
+	// Output holds the lines written to the server output by commands
+	// without items; the lines of items would repeat them
+	Output string    `json:"output,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Code   ErrorCode `json:"code,omitempty"`
+}
+
+// Item is a key-value pair of a result
+type Item struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// NewResult creates the result of a handled command
+func NewResult(text string, items []storage.KeyValue, err error) Result {
+	var result Result
+	for _, item := range items {
+		result.Items = append(result.Items, Item{Key: item.Key, Value: item.Value})
+	}
+	if len(items) == 0 {
+		result.Output = text
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Code = Code(err)
+	}
+	return result
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrorCode identifies why a command failed validation
+type ErrorCode string
+
+const (
+	CodeInvalidUTF8    ErrorCode = "INVALID_UTF8"
+	CodeInvalidKey     ErrorCode = "INVALID_KEY"
+	CodeKeyTooLong     ErrorCode = "KEY_TOO_LONG"
+	CodeValueTooLong   ErrorCode = "VALUE_TOO_LONG"
+	CodeReservedPrefix ErrorCode = "RESERVED_PREFIX"
+	CodeBatchTooLarge  ErrorCode = "BATCH_TOO_LARGE"
+	CodeMissingKey     ErrorCode = "MISSING_KEY"
+	// CodeAccessDenied is the code of commands the access policy denied
+	CodeAccessDenied ErrorCode = "ACCESS_DENIED"
+	// CodeInvalidCursor is the code of getall pages after a missing key
+	CodeInvalidCursor ErrorCode = "INVALID_CURSOR"
+	// Codes of mutations that cannot be applied to the stored value
+	CodeNotInteger    ErrorCode = "NOT_INTEGER"
+	CodeNotFloat      ErrorCode = "NOT_FLOAT"
+	CodeOverflow      ErrorCode = "OVERFLOW"
+	CodeInvalidOffset ErrorCode = "INVALID_OFFSET"
+	// Codes of messages that cannot be decoded
+	CodeMalformed              ErrorCode = "MALFORMED"
+	CodeUnsupportedVersion     ErrorCode = "UNSUPPORTED_VERSION"
+	CodeUnsupportedContentType ErrorCode = "UNSUPPORTED_CONTENT_TYPE"
+)
+
+// ValidationError is returned for commands that violate the limits
+type ValidationError struct {
+	Code   ErrorCode
+	Field  string
+	Detail string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s (%s): %s", e.Field, e.Code, e.Detail)
+}
+
+// Code returns the code of a validation or coded error, empty for other
+// errors
+func Code(err error) ErrorCode {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Code
+	}
+	var codedErr *CodedError
+	if errors.As(err, &codedErr) {
+		return codedErr.Code
+	}
+	return ""
+}
+
+// Limits bound the commands a registry accepts. Zero sizes are unlimited.
+// Keys and namespaces never contain whitespace or control characters, and
+// every string must be valid UTF-8.
+type Limits struct {
+	MaxKeySize   int
+	MaxValueSize int
+	// MaxBatchSize bounds the extra arguments, e.g. the keys of mget
+	MaxBatchSize int
+	// KeyPattern is a regular expression whole keys and namespaces must
+	// match, empty to allow any
+	KeyPattern string
+	// ReservedPrefixes are key prefixes clients may not use
+	ReservedPrefixes []string
+}
+
+// DefaultLimits are the limits of a new registry
+var DefaultLimits = Limits{
+	MaxKeySize:   512,
+	MaxValueSize: 1 << 20,
+	MaxBatchSize: 1000,
+}
+
+// RegisterFlags registers the limit flags with the current values as defaults
+func (l *Limits) RegisterFlags(flags *flag.FlagSet) {
+	flags.IntVar(&l.MaxKeySize, "max-key-size", l.MaxKeySize, "Maximum key and namespace size in bytes, 0 for unlimited")
+	flags.IntVar(&l.MaxValueSize, "max-value-size", l.MaxValueSize, "Maximum value size in bytes, 0 for unlimited")
+	flags.IntVar(&l.MaxBatchSize, "max-batch-size", l.MaxBatchSize, "Maximum number of keys or arguments of a command, 0 for unlimited")
+	flags.StringVar(&l.KeyPattern, "key-pattern", l.KeyPattern, "Regular expression keys and namespaces must match, empty to allow any")
+	flags.Var((*prefixList)(&l.ReservedPrefixes), "reserved-key-prefixes", "Comma separated key prefixes clients may not use")
+}
+
+// prefixList is a comma separated flag value
+type prefixList []string
+
+func (p *prefixList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *prefixList) Set(value string) error {
+	*p = nil
+	for _, prefix := range strings.Split(value, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			*p = append(*p, prefix)
+		}
+	}
+	return nil
+}
+
+// validator checks commands against compiled limits
+type validator struct {
+	limits     Limits
+	keyPattern *regexp.Regexp
+}
+
+func newValidator(limits Limits) (validator, error) {
+	v := validator{limits: limits}
+	if limits.KeyPattern != "" {
+		pattern, err := regexp.Compile("^(?:" + limits.KeyPattern + ")$")
+		if err != nil {
+			return validator{}, fmt.Errorf("invalid key pattern: %w", err)
+		}
+		v.keyPattern = pattern
+	}
+	return v, nil
+}
+
+// validate checks the strings of the command and the keys in its scope
+func (v validator) validate(cmd Command, scope CommandScope) error {
+	fields := []struct{ name, value string }{
+		{"key", cmd.GetKey()},
+		{"value", cmd.GetValue()},
+		{"namespace", cmd.GetNamespace()},
+	}
+	for _, arg := range cmd.GetArgs() {
+		fields = append(fields, struct{ name, value string }{"argument", arg})
+	}
+	for _, field := range fields {
+		if !utf8.ValidString(field.value) {
+			return &ValidationError{Code: CodeInvalidUTF8, Field: field.name, Detail: "not valid UTF-8"}
+		}
+	}
+
+	if max := v.limits.MaxBatchSize; max > 0 && len(cmd.GetArgs()) > max {
+		return &ValidationError{Code: CodeBatchTooLarge, Field: "arguments",
+			Detail: fmt.Sprintf("%d arguments exceed the limit of %d", len(cmd.GetArgs()), max)}
+	}
+	if err := v.validateValueSize(len(cmd.GetValue())); err != nil {
+		return err
+	}
+	if namespace := cmd.GetNamespace(); namespace != "" {
+		if err := v.validateName("namespace", namespace); err != nil {
+			return err
+		}
+	}
+	for _, key := range scope.Keys {
+		if err := v.validateName("key", key); err != nil {
+			return err
+		}
+		for _, prefix := range v.limits.ReservedPrefixes {
+			if strings.HasPrefix(key, prefix) {
+				return &ValidationError{Code: CodeReservedPrefix, Field: "key",
+					Detail: fmt.Sprintf("prefix %q is reserved", prefix)}
+			}
+		}
+	}
+	return nil
+}
+
+// validateValueSize checks the size of a value, also of values commands
+// build from the stored one
+func (v validator) validateValueSize(size int) error {
+	if max := v.limits.MaxValueSize; max > 0 && size > max {
+		return &ValidationError{Code: CodeValueTooLong, Field: "value",
+			Detail: fmt.Sprintf("%d bytes exceed the limit of %d", size, max)}
+	}
+	return nil
+}
+
+// validateName checks the size and characters of a key or namespace
+func (v validator) validateName(field, name string) error {
+	if max := v.limits.MaxKeySize; max > 0 && len(name) > max {
+		return &ValidationError{Code: CodeKeyTooLong, Field: field,
+			Detail: fmt.Sprintf("%d bytes exceed the limit of %d", len(name), max)}
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return &ValidationError{Code: CodeInvalidKey, Field: field,
+				Detail: fmt.Sprintf("character %U is not allowed", r)}
+		}
+	}
+	if v.keyPattern != nil && !v.keyPattern.MatchString(name) {
+		return &ValidationError{Code: CodeInvalidKey, Field: field,
+			Detail: fmt.Sprintf("%q does not match %s", name, v.limits.KeyPattern)}
+	}
+	return nil
+}
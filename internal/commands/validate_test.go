@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandRegistry_Validate(t *testing.T) {
+	registry := NewCommandRegistry()
+	err := registry.SetLimits(Limits{
+		MaxKeySize:       8,
+		MaxValueSize:     16,
+		MaxBatchSize:     3,
+		KeyPattern:       `[a-z0-9:]+`,
+		ReservedPrefixes: []string{"sys:"},
+	})
+	if err != nil {
+		t.Fatalf("SetLimits() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cmd  Command
+		want ErrorCode
+	}{
+		{"valid", NewCommand(AddItem, "user:1", "john"), ""},
+		{"key too long", NewCommand(AddItem, "user:123456", "john"), CodeKeyTooLong},
+		{"value too long", NewCommand(AddItem, "user:1", strings.Repeat("x", 17)), CodeValueTooLong},
+		{"key outside pattern", NewCommand(GetItem, "User:1", ""), CodeInvalidKey},
+		{"control character", NewCommand(GetItem, "a\x00b", ""), CodeInvalidKey},
+		{"reserved prefix", NewCommand(DeleteItem, "sys:conf", ""), CodeReservedPrefix},
+		{"reserved mark", NewCommand(InsertAfter, "user:1", "john", "sys:x"), CodeReservedPrefix},
+		{"too many keys", NewCommand(GetMany, "", "", "a", "b", "c", "d"), CodeBatchTooLarge},
+		{"invalid utf-8 value", NewCommand(AddItem, "user:1", "\xff"), CodeInvalidUTF8},
+		{"invalid namespace", WithNamespace(NewCommand(GetItem, "user:1", ""), "my space"), CodeInvalidKey},
+		{"namespace command", NewCommand(DropNamespace, "prices", ""), ""},
+		{"namespace command outside pattern", NewCommand(DropNamespace, "Prices", ""), CodeInvalidKey},
+		{"namespace command control character", NewCommand(NamespaceInfo, "a\x00b", ""), CodeInvalidKey},
+	}
+	for _, tt := range tests {
+		if got := Code(registry.Validate(tt.cmd)); got != tt.want {
+			t.Errorf("%s: Code(Validate()) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommandRegistry_ParseCommandValidates(t *testing.T) {
+	registry := NewCommandRegistry()
+
+	if _, err := registry.ParseCommand("add " + strings.Repeat("k", DefaultLimits.MaxKeySize+1) + " value"); Code(err) != CodeKeyTooLong {
+		t.Errorf("ParseCommand() of long key error = %v, want %s", err, CodeKeyTooLong)
+	}
+	if _, err := registry.ParseCommand("get \xffkey"); Code(err) != CodeInvalidUTF8 {
+		t.Errorf("ParseCommand() of invalid UTF-8 error = %v, want %s", err, CodeInvalidUTF8)
+	}
+	if _, err := FromJSON([]byte("{\"type\":\"getItem\",\"key\":\"\xff\"}")); Code(err) != CodeInvalidUTF8 {
+		t.Errorf("FromJSON() of invalid UTF-8 error = %v, want %s", err, CodeInvalidUTF8)
+	}
+	if err := registry.SetLimits(Limits{KeyPattern: "["}); err == nil {
+		t.Error("Expected error for invalid key pattern")
+	}
+}
+
+func TestLimits_RegisterFlags(t *testing.T) {
+	limits := DefaultLimits
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	limits.RegisterFlags(flags)
+
+	if err := flags.Parse([]string{"-max-key-size", "64", "-reserved-key-prefixes", "sys:, internal:"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if limits.MaxKeySize != 64 || limits.MaxValueSize != DefaultLimits.MaxValueSize {
+		t.Errorf("Limits = %+v, want max key size 64 and default max value size", limits)
+	}
+	if want := []string{"sys:", "internal:"}; !reflect.DeepEqual(limits.ReservedPrefixes, want) {
+		t.Errorf("ReservedPrefixes = %v, want %v", limits.ReservedPrefixes, want)
+	}
+}
@@ -0,0 +1,60 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Gzip is the content encoding of compressed data
+const Gzip = "gzip"
+
+// ErrTooLarge is returned for data that decompresses beyond the limit
+var ErrTooLarge = errors.New("decompressed data exceeds the limit")
+
+// Writers are reused, a new one allocates the compression tables
+var writers = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+// Compress returns the gzip compressed data
+func Compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w := writers.Get().(*gzip.Writer)
+	defer writers.Put(w)
+
+	w.Reset(&buf)
+	// Writes to a bytes.Buffer do not fail
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Decompress returns the data of a gzip stream. Streams that decompress to
+// more than limit bytes fail with ErrTooLarge, 0 for no limit.
+func Decompress(data []byte, limit int64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	defer r.Close()
+
+	var src io.Reader = r
+	if limit > 0 {
+		src = io.LimitReader(r, limit+1)
+	}
+	out, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	if limit > 0 && int64(len(out)) > limit {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
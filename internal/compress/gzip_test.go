@@ -0,0 +1,45 @@
+package compress
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompress_RoundTrip(t *testing.T) {
+	for _, data := range [][]byte{nil, []byte("a"), []byte(strings.Repeat("price:42.0;", 1000))} {
+		compressed := Compress(data)
+		got, err := Decompress(compressed, 0)
+		if err != nil {
+			t.Fatalf("Decompress() error = %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Decompress(Compress(%d bytes)) = %d bytes", len(data), len(got))
+		}
+	}
+
+	data := []byte(strings.Repeat("a", 10000))
+	if compressed := Compress(data); len(compressed) >= len(data)/10 {
+		t.Errorf("Expected repetitive data to compress, got %d of %d bytes", len(compressed), len(data))
+	}
+}
+
+func TestDecompress_Limit(t *testing.T) {
+	compressed := Compress([]byte(strings.Repeat("a", 1000)))
+	if _, err := Decompress(compressed, 1000); err != nil {
+		t.Errorf("Decompress() at the limit error = %v", err)
+	}
+	if _, err := Decompress(compressed, 999); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Decompress() error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+func TestDecompress_Invalid(t *testing.T) {
+	compressed := Compress([]byte("value"))
+	for _, data := range [][]byte{[]byte("not gzip"), compressed[:len(compressed)-4]} {
+		if _, err := Decompress(data, 0); err == nil {
+			t.Errorf("Decompress(%q) expected error", data)
+		}
+	}
+}
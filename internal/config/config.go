@@ -0,0 +1,155 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// EnvPrefix is the prefix of environment variables overriding options
+	EnvPrefix = "EORACLE_"
+	// FileFlag is the flag naming the config file
+	FileFlag = "config"
+)
+
+// Source tells where the value of an option came from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// secretWords end the names of options whose values are never printed,
+// while e.g. -rabbit-key-file only names a file
+var secretWords = []string{"password", "secret", "token", "key", "credentials"}
+
+// Config is the effective configuration of a flag set after merging the
+// config file and environment variables into it
+type Config struct {
+	flags   *flag.FlagSet
+	sources map[string]Source
+}
+
+// Load merges the config file and EORACLE_* environment variables into the
+// parsed flag set. Flags given on the command line take precedence over
+// environment variables, which take precedence over the config file. The
+// file is named by the -config flag or EORACLE_CONFIG.
+func Load(flags *flag.FlagSet, environ []string) (*Config, error) {
+	c := &Config{flags: flags, sources: make(map[string]Source)}
+	flags.VisitAll(func(f *flag.Flag) {
+		c.sources[f.Name] = SourceDefault
+	})
+	flags.Visit(func(f *flag.Flag) {
+		c.sources[f.Name] = SourceFlag
+	})
+
+	env := envValues(environ)
+	// The config file is named before it is read, so only a flag or the
+	// environment can set it
+	path := lookup(flags, FileFlag)
+	if flags.Lookup(FileFlag) != nil && c.sources[FileFlag] != SourceFlag && env[FileFlag] != "" {
+		path = env[FileFlag]
+		flags.Set(FileFlag, path)
+		c.sources[FileFlag] = SourceEnv
+	}
+	delete(env, FileFlag)
+	// Variables may be shared by the binaries, so unknown ones are ignored
+	for name := range env {
+		if flags.Lookup(name) == nil {
+			delete(env, name)
+		}
+	}
+
+	if path != "" {
+		values, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.apply(values, SourceFile, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.apply(env, SourceEnv, "environment"); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Source returns where the value of the named option came from
+func (c *Config) Source(name string) Source {
+	return c.sources[name]
+}
+
+// Print writes the effective options in name order with their source.
+// Secrets and URL passwords are masked.
+func (c *Config) Print(w io.Writer) {
+	c.flags.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "%s = %s (%s)\n", f.Name, Mask(f.Name, f.Value.String()), c.sources[f.Name])
+	})
+}
+
+// apply sets the options not given on the command line
+func (c *Config) apply(values map[string]string, source Source, origin string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if c.flags.Lookup(name) == nil || name == FileFlag {
+			return fmt.Errorf("unknown option %s in %s", name, origin)
+		}
+		if c.sources[name] == SourceFlag {
+			continue
+		}
+		if err := c.flags.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value %q for %s in %s: %w", values[name], name, origin, err)
+		}
+		c.sources[name] = source
+	}
+	return nil
+}
+
+// envValues returns the EORACLE_* variables by option name, so
+// EORACLE_READ_WORKERS sets -read-workers
+func envValues(environ []string) map[string]string {
+	values := make(map[string]string)
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		values[optionName(strings.TrimPrefix(key, EnvPrefix))] = value
+	}
+	return values
+}
+
+// Mask hides the value of secret options and the password of URLs
+func Mask(name, value string) string {
+	if value == "" {
+		return value
+	}
+	words := strings.Split(name, "-")
+	for _, secret := range secretWords {
+		if words[len(words)-1] == secret {
+			return "*****"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// optionName normalizes a file key or variable name to a flag name
+func optionName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "_", "-")
+}
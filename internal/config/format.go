@@ -0,0 +1,125 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadFile reads the options of a config file by option name. The format
+// follows the extension: .json, .yaml, .yml or .toml. Options are flat
+// key/value pairs named like the flags; underscores may replace dashes.
+func ReadFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]string
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		values, err = parseJSON(data)
+	case ".yaml", ".yml":
+		values, err = parseLines(data, ":")
+	case ".toml":
+		values, err = parseLines(data, "=")
+	default:
+		return nil, fmt.Errorf("unsupported config file format: %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// parseJSON reads an object of scalar values
+func parseJSON(data []byte) (map[string]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(object))
+	for key, value := range object {
+		switch v := value.(type) {
+		case string:
+			values[optionName(key)] = v
+		case json.Number:
+			values[optionName(key)] = v.String()
+		case bool:
+			values[optionName(key)] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s: expected a string, number or boolean", key)
+		}
+	}
+	return values, nil
+}
+
+// parseLines reads "key: value" (YAML) or "key = value" (TOML) lines.
+// Comments start with #; nested sections and lists are not supported.
+func parseLines(data []byte, separator string) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, separator)
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.HasPrefix(key, "-") || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected a key and value separated by %q", n, separator)
+		}
+		value, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		values[optionName(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+// parseValue unquotes a scalar value and strips a trailing comment
+func parseValue(value string) (string, error) {
+	if value == "" {
+		return "", fmt.Errorf("missing value, nested sections are not supported")
+	}
+
+	switch value[0] {
+	case '"':
+		end := strings.LastIndex(value, `"`)
+		if end == 0 || strings.TrimSpace(stripComment(value[end+1:])) != "" {
+			return "", fmt.Errorf("invalid quoted value %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case '\'':
+		end := strings.LastIndex(value, "'")
+		if end == 0 || strings.TrimSpace(stripComment(value[end+1:])) != "" {
+			return "", fmt.Errorf("invalid quoted value %s", value)
+		}
+		return value[1:end], nil
+	case '[', '{':
+		return "", fmt.Errorf("lists and tables are not supported")
+	}
+	return strings.TrimSpace(stripComment(value)), nil
+}
+
+// stripComment removes a comment starting with " #"
+func stripComment(value string) string {
+	if i := strings.Index(value, " #"); i >= 0 {
+		return value[:i]
+	}
+	if strings.HasPrefix(value, "#") {
+		return ""
+	}
+	return value
+}
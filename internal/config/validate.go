@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Check validates options of the effective configuration
+type Check func(flags *flag.FlagSet) error
+
+// Validate runs the checks and returns all failures at once
+func (c *Config) Validate(checks ...Check) error {
+	var errs []error
+	for _, check := range checks {
+		if err := check(c.flags); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Positive checks that the integer options are at least 1
+func Positive(names ...string) Check {
+	return intCheck(names, 1, "at least 1")
+}
+
+// NonNegative checks that the integer options are at least 0
+func NonNegative(names ...string) Check {
+	return intCheck(names, 0, "not negative")
+}
+
+func intCheck(names []string, lo int64, want string) Check {
+	return func(flags *flag.FlagSet) error {
+		var errs []error
+		for _, name := range names {
+			value := lookup(flags, name)
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < lo {
+				errs = append(errs, fmt.Errorf("%s must be %s, got %s", name, want, value))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// URL checks that the option is an absolute URL with one of the schemes.
+// An empty value passes, for optional features.
+func URL(name string, schemes ...string) Check {
+	return func(flags *flag.FlagSet) error {
+		value := lookup(flags, name)
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil {
+			// The parse error quotes the URL, which may hold a password
+			return fmt.Errorf("%s is not a valid URL", name)
+		}
+		for _, scheme := range schemes {
+			if u.Scheme == scheme && u.Host != "" {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be a %v URL with a host, got %s", name, schemes, Mask(name, value))
+	}
+}
+
+// Dir checks that the option names an existing directory. An empty value
+// passes, for optional features.
+func Dir(name string) Check {
+	return func(flags *flag.FlagSet) error {
+		if value := lookup(flags, name); value != "" {
+			return checkDir(name, value)
+		}
+		return nil
+	}
+}
+
+// File checks that the options name existing files. Empty values pass, for
+// optional features.
+func File(names ...string) Check {
+	return func(flags *flag.FlagSet) error {
+		var errs []error
+		for _, name := range names {
+			value := lookup(flags, name)
+			if value == "" {
+				continue
+			}
+			if info, err := os.Stat(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			} else if info.IsDir() {
+				errs = append(errs, fmt.Errorf("%s: %s is a directory", name, value))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// FileDir checks that the directory of the file named by the option exists,
+// so the file can be created
+func FileDir(name string) Check {
+	return func(flags *flag.FlagSet) error {
+		if value := lookup(flags, name); value != "" {
+			return checkDir(name, filepath.Dir(value))
+		}
+		return nil
+	}
+}
+
+func checkDir(name, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %s is not a directory", name, path)
+	}
+	return nil
+}
+
+// lookup returns the value of an option, empty if there is no such flag
+func lookup(flags *flag.FlagSet, name string) string {
+	if f := flags.Lookup(name); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
@@ -0,0 +1,427 @@
+package failover
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"eoracle-client-server/internal/replication"
+	"eoracle-client-server/internal/storage"
+)
+
+const (
+	// stateFileName holds the last snapshot of the active server
+	stateFileName = "state.json"
+	// noncesFileName holds the nonces the active server accepted as of its
+	// last snapshot
+	noncesFileName = "nonces.json"
+	// walBufferSize is the number of change events buffered for the log.
+	// The feed disconnects the log once it is full, forcing a snapshot.
+	walBufferSize = 8192
+)
+
+// ErrDumperClosed is returned by Sync and Dump once the dumper is closed
+var ErrDumperClosed = errors.New("state dump closed")
+
+// NonceCache is the cache of the accepted nonces of signed commands,
+// implemented by auth.Verifier. It is dumped with the state, so a server
+// taking over keeps rejecting replays of the commands already run.
+type NonceCache interface {
+	Nonces() map[string]time.Time
+	Remember(nonces map[string]time.Time)
+}
+
+// Dumper maintains the state dump of the active server: a snapshot written
+// every interval plus a log of the change events since. Each snapshot starts
+// a new log segment and removes the segments it covers.
+type Dumper struct {
+	dir    string
+	feed   *storage.ChangeFeed
+	spaces storage.Namespaces
+	nonces NonceCache
+
+	// dumping is held for a whole dump, so Stop can wait for it
+	dumping sync.Mutex
+
+	mu      sync.Mutex
+	changed *sync.Cond // signaled when written grows or the dumper closes
+	wal     *os.File
+	segment int
+	written uint64 // version up to which the dump has every event
+	synced  uint64 // version up to which the dump is on disk
+	err     error  // failed log write, until a dump covers the event
+	failed  uint64 // version of the event of err
+	stopped bool
+
+	unsubscribe func()
+	closing     bool
+	done        chan struct{}
+}
+
+// NewDumper writes an initial dump of the namespaces and starts logging
+// their changes. It must be called before the server applies writes. The
+// nonces are dumped with every snapshot unless nil.
+func NewDumper(dir string, feed *storage.ChangeFeed, spaces storage.Namespaces, nonces NonceCache) (*Dumper, error) {
+	segments, err := walSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dumper{
+		dir:    dir,
+		feed:   feed,
+		spaces: spaces,
+		nonces: nonces,
+		done:   make(chan struct{}),
+	}
+	d.changed = sync.NewCond(&d.mu)
+	if len(segments) > 0 {
+		d.segment = segments[len(segments)-1]
+	}
+
+	events, unsubscribe := feed.Subscribe(walBufferSize)
+	d.unsubscribe = unsubscribe
+	if err := d.Dump(); err != nil {
+		unsubscribe()
+		if d.wal != nil {
+			d.wal.Close()
+		}
+		return nil, err
+	}
+
+	go d.log(events)
+	return d, nil
+}
+
+// Run dumps the state every interval and once more when the context is done
+func (d *Dumper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := d.Dump(); err != nil {
+				log.Printf("Failed to write final state dump: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := d.Dump(); err != nil {
+				log.Printf("Failed to write state dump: %v", err)
+			}
+		}
+	}
+}
+
+// Dump starts a new log segment, writes a snapshot and removes the older
+// segments. Every event in an older segment was emitted before the snapshot
+// version was read, so the snapshot covers it.
+func (d *Dumper) Dump() error {
+	d.dumping.Lock()
+	defer d.dumping.Unlock()
+
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return ErrDumperClosed
+	}
+	previous := d.segment
+	if err := d.rotate(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	d.mu.Unlock()
+
+	// The snapshot is taken without holding the log lock, so the log keeps
+	// draining events of writers blocked on the feed
+	snapshot := replication.TakeSnapshot(d.feed, d.spaces)
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(d.dir, stateFileName), data); err != nil {
+		return err
+	}
+	if d.nonces != nil {
+		data, err := json.Marshal(d.nonces.Nonces())
+		if err != nil {
+			return fmt.Errorf("failed to encode nonces: %w", err)
+		}
+		if err := writeFileAtomic(filepath.Join(d.dir, noncesFileName), data); err != nil {
+			return err
+		}
+	}
+
+	// The snapshot holds every event up to its version, also those the log
+	// missed after falling behind
+	d.mu.Lock()
+	d.written = max(d.written, snapshot.Version)
+	d.synced = max(d.synced, snapshot.Version)
+	if snapshot.Version >= d.failed {
+		d.err = nil
+	}
+	d.changed.Broadcast()
+	d.mu.Unlock()
+
+	segments, err := walSegments(d.dir)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment <= previous {
+			os.Remove(walPath(d.dir, segment))
+		}
+	}
+	return nil
+}
+
+// Sync waits until every change applied so far is in the dump on disk. The
+// server calls it before it acknowledges a write, so a server taking over
+// restores every acknowledged write. Concurrent calls share one fsync.
+func (d *Dumper) Sync() error {
+	version := d.feed.Version()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for d.written < version && !d.closing && d.err == nil {
+		d.changed.Wait()
+	}
+	if d.synced >= version {
+		return nil
+	}
+	if d.closing {
+		return ErrDumperClosed
+	}
+	if d.written < version {
+		return d.err
+	}
+	written := d.written
+	if err := d.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync change log: %w", err)
+	}
+	d.synced = written
+	return nil
+}
+
+// Stop closes the dumper without writing another dump, once this server
+// lost the lease and another one may be writing the dump. Writes waiting in
+// Sync fail, so they are not acknowledged.
+func (d *Dumper) Stop() error {
+	d.mu.Lock()
+	d.stopped = true
+	d.mu.Unlock()
+	// Wait for a dump in progress
+	d.dumping.Lock()
+	d.dumping.Unlock()
+	return d.Close()
+}
+
+// Close stops logging and waits for the logged events to be written
+func (d *Dumper) Close() error {
+	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closing = true
+	d.changed.Broadcast()
+	unsubscribe := d.unsubscribe
+	d.mu.Unlock()
+	unsubscribe()
+	<-d.done
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.wal.Close()
+}
+
+// log appends events to the current segment until the dumper is closed. If
+// the feed disconnects the log for falling behind, the log misses events, so
+// it subscribes again and writes a new dump covering them.
+func (d *Dumper) log(events <-chan storage.ChangeEvent) {
+	defer close(d.done)
+	for {
+		for event := range events {
+			if err := d.append(event); err != nil {
+				log.Printf("Failed to write change log, writing a new state dump: %v", err)
+				if err := d.Dump(); err != nil {
+					log.Printf("Failed to write state dump: %v", err)
+				}
+			}
+		}
+
+		d.mu.Lock()
+		if d.closing {
+			d.mu.Unlock()
+			return
+		}
+		log.Printf("Change log fell behind, writing a new state dump")
+		events, d.unsubscribe = d.feed.Subscribe(walBufferSize)
+		d.mu.Unlock()
+		if err := d.Dump(); err != nil {
+			log.Printf("Failed to write state dump: %v", err)
+		}
+	}
+}
+
+// append writes an event to the current segment. After a failed write the
+// log misses an event, so it covers no later version until a dump covers
+// the missed one.
+func (d *Dumper) append(event storage.ChangeEvent) error {
+	data, err := json.Marshal(event)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		_, err = d.wal.Write(append(data, '\n'))
+	}
+	if err != nil {
+		d.err = fmt.Errorf("failed to write change log: %w", err)
+		d.failed = event.Version
+	} else if d.err == nil {
+		d.written = max(d.written, event.Version)
+	}
+	d.changed.Broadcast()
+	return err
+}
+
+// rotate syncs and closes the current segment and opens the next one. It
+// is called with the lock held.
+func (d *Dumper) rotate() error {
+	if d.wal != nil {
+		if err := d.wal.Sync(); err != nil {
+			return fmt.Errorf("failed to sync change log: %w", err)
+		}
+		d.synced = d.written
+	}
+	file, err := os.OpenFile(walPath(d.dir, d.segment+1), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open change log: %w", err)
+	}
+	if d.wal != nil {
+		d.wal.Close()
+	}
+	d.wal = file
+	d.segment++
+	return nil
+}
+
+// Restore loads the state dump into the namespaces: the snapshot first, then
+// the logged events in version order. The dumped nonces are added to the
+// cache unless it is nil. It returns false if there is no dump.
+func Restore(dir string, spaces storage.Namespaces, nonces NonceCache) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read state dump: %w", err)
+	}
+
+	var snapshot replication.Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return false, fmt.Errorf("failed to parse state dump: %w", err)
+	}
+	replica := replication.NewReplica(spaces)
+	replica.Load(snapshot)
+
+	segments, err := walSegments(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, segment := range segments {
+		complete, err := replay(walPath(dir, segment), replica)
+		if err != nil {
+			return false, err
+		}
+		if !complete {
+			break
+		}
+	}
+
+	if nonces != nil {
+		if err := restoreNonces(dir, nonces); err != nil {
+			return false, err
+		}
+	}
+
+	_, version := replica.Position()
+	log.Printf("Restored state dump at version %d", version)
+	return true, nil
+}
+
+// restoreNonces adds the dumped nonces to the cache. Dumps of servers that
+// did not verify commands have none.
+func restoreNonces(dir string, cache NonceCache) error {
+	data, err := os.ReadFile(filepath.Join(dir, noncesFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read nonces: %w", err)
+	}
+
+	var nonces map[string]time.Time
+	if err := json.Unmarshal(data, &nonces); err != nil {
+		return fmt.Errorf("failed to parse nonces: %w", err)
+	}
+	cache.Remember(nonces)
+	log.Printf("Restored %d nonces", len(nonces))
+	return nil
+}
+
+// replay applies the events of a log segment. A truncated last line from a
+// crash during a write is skipped. It returns false when an event could not
+// be applied, since no later event can be applied either.
+func replay(path string, replica *replication.Replica) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open change log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var event storage.ChangeEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.Printf("Skipping invalid change log entry in %s: %v", path, err)
+			continue
+		}
+		if err := replica.Apply(event); err != nil {
+			log.Printf("Stopping replay of %s at version %d: %v", path, event.Version, err)
+			return false, nil
+		}
+	}
+	return true, scanner.Err()
+}
+
+// walSegments returns the numbers of the log segments in ascending order
+func walSegments(dir string) ([]int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []int
+	for _, path := range paths {
+		var segment int
+		if _, err := fmt.Sscanf(filepath.Base(path), "wal-%d.log", &segment); err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func walPath(dir string, segment int) string {
+	return filepath.Join(dir, fmt.Sprintf("wal-%08d.log", segment))
+}
@@ -0,0 +1,182 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/storage"
+)
+
+func itemsOf(store storage.Storage) []string {
+	var items []string
+	for _, item := range store.GetAll() {
+		items = append(items, item.Key+"="+item.Value)
+	}
+	return items
+}
+
+// TestDumpAndRestore tests that a standby rebuilds the map from the snapshot
+// and the change log written after it
+func TestDumpAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	feed := storage.NewChangeFeed()
+	active := storage.NewNamespaces(storage.Limits{}, feed)
+
+	active.Namespace("").Add("key1", "value1")
+	active.Namespace("").Add("key2", "value2")
+
+	dumper, err := NewDumper(dir, feed, active, nil)
+	if err != nil {
+		t.Fatalf("NewDumper() error = %v", err)
+	}
+
+	// Changes after the initial dump are only in the change log
+	active.Namespace("").Add("key3", "value3")
+	active.Namespace("").Delete("key1")
+	active.Namespace("").MoveToFront("key3")
+	active.Namespace("other").Add("key1", "other1")
+	if err := dumper.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	standby := storage.NewNamespaces(storage.Limits{}, nil)
+	restored, err := Restore(dir, standby, nil)
+	if err != nil || !restored {
+		t.Fatalf("Restore() = %v, %v, want true, nil", restored, err)
+	}
+
+	for _, name := range []string{storage.DefaultNamespace, "other"} {
+		got := itemsOf(standby.Namespace(name))
+		want := itemsOf(active.Namespace(name))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Namespace %s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestDumpRemovesCoveredSegments tests that a dump keeps only the current
+// change log segment
+func TestDumpRemovesCoveredSegments(t *testing.T) {
+	dir := t.TempDir()
+	feed := storage.NewChangeFeed()
+	spaces := storage.NewNamespaces(storage.Limits{}, feed)
+
+	dumper, err := NewDumper(dir, feed, spaces, nil)
+	if err != nil {
+		t.Fatalf("NewDumper() error = %v", err)
+	}
+	defer dumper.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		dumper.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	for i := 0; i < 5; i++ {
+		spaces.Namespace("").Add("key", "value")
+		time.Sleep(15 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "wal-*.log"))
+	if len(segments) != 1 {
+		t.Errorf("Expected 1 change log segment, got %v", segments)
+	}
+}
+
+// TestRestoreWithoutDump tests that a missing dump is not an error
+func TestRestoreWithoutDump(t *testing.T) {
+	restored, err := Restore(t.TempDir(), storage.NewNamespaces(storage.Limits{}, nil), nil)
+	if err != nil || restored {
+		t.Errorf("Restore() = %v, %v, want false, nil", restored, err)
+	}
+}
+
+// TestDumperSync tests that synced changes are restored without closing the
+// dumper, and that a stopped dumper fails Sync
+func TestDumperSync(t *testing.T) {
+	dir := t.TempDir()
+	feed := storage.NewChangeFeed()
+	active := storage.NewNamespaces(storage.Limits{}, feed)
+
+	dumper, err := NewDumper(dir, feed, active, nil)
+	if err != nil {
+		t.Fatalf("NewDumper() error = %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		active.Namespace("").Add(strconv.Itoa(i), "value")
+	}
+	if err := dumper.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// The server taking over restores what the active server acknowledged
+	standby := storage.NewNamespaces(storage.Limits{}, nil)
+	if _, err := Restore(dir, standby, nil); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if size := standby.Namespace("").Size(); size != 100 {
+		t.Errorf("Expected 100 restored keys, got %d", size)
+	}
+
+	if err := dumper.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	active.Namespace("").Add("late", "value")
+	if err := dumper.Sync(); !errors.Is(err, ErrDumperClosed) {
+		t.Errorf("Sync() after Stop error = %v, want %v", err, ErrDumperClosed)
+	}
+	if err := dumper.Dump(); !errors.Is(err, ErrDumperClosed) {
+		t.Errorf("Dump() after Stop error = %v, want %v", err, ErrDumperClosed)
+	}
+	if err := dumper.Close(); err != nil {
+		t.Errorf("Close() after Stop error = %v", err)
+	}
+}
+
+// nonceCache is a NonceCache of a map
+type nonceCache map[string]time.Time
+
+func (c nonceCache) Nonces() map[string]time.Time {
+	return c
+}
+
+func (c nonceCache) Remember(nonces map[string]time.Time) {
+	for id, expires := range nonces {
+		c[id] = expires
+	}
+}
+
+// TestDumpNonces tests that the server taking over restores the nonces of
+// the last dump
+func TestDumpNonces(t *testing.T) {
+	dir := t.TempDir()
+	feed := storage.NewChangeFeed()
+	active := nonceCache{}
+
+	dumper, err := NewDumper(dir, feed, storage.NewNamespaces(storage.Limits{}, feed), active)
+	if err != nil {
+		t.Fatalf("NewDumper() error = %v", err)
+	}
+	expires := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	active["client/nonce"] = expires
+	if err := dumper.Dump(); err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	dumper.Close()
+
+	standby := nonceCache{}
+	if _, err := Restore(dir, storage.NewNamespaces(storage.Limits{}, nil), standby); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got := standby["client/nonce"]; !got.Equal(expires) {
+		t.Errorf("Restored nonce expiry = %v, want %v", got, expires)
+	}
+}
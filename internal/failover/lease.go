@@ -0,0 +1,249 @@
+package failover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// leaseFileName is the lease file in the shared directory
+const leaseFileName = "leader.lease"
+
+// ErrLeaseLost is returned when another server took over the lease
+var ErrLeaseLost = errors.New("leader lease lost")
+
+// leaseRecord is the content of the lease file. The generation grows with
+// every acquisition, so a holder notices a takeover even by its own name.
+type leaseRecord struct {
+	Holder     string    `json:"holder"`
+	Generation uint64    `json:"generation"`
+	Expires    time.Time `json:"expires"`
+}
+
+// Lease elects a single active server through a lease file on a shared disk.
+// Updates of the file are serialized with flock on a lock file next to it.
+// The holder renews the lease every third of its TTL and steps down a
+// quarter TTL before it expires unless renewed; standbys take it over once
+// it expired.
+type Lease struct {
+	path       string
+	holder     string
+	ttl        time.Duration
+	generation uint64 // generation of the held lease
+}
+
+// NewLease creates a lease in the directory for the named server
+func NewLease(dir, holder string, ttl time.Duration) *Lease {
+	return &Lease{
+		path:   filepath.Join(dir, leaseFileName),
+		holder: holder,
+		ttl:    ttl,
+	}
+}
+
+// Acquire waits until this server holds the lease or the context is done
+func (l *Lease) Acquire(ctx context.Context) error {
+	for {
+		acquired, err := l.tryAcquire()
+		if err != nil {
+			log.Printf("Failed to acquire leader lease: %v", err)
+		}
+		if acquired {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.ttl / 3):
+		}
+	}
+}
+
+// Hold renews the lease until the context is done. It returns ErrLeaseLost
+// when another server took the lease, or when renewals failed until a
+// quarter TTL before the lease expires, so the server stops before a
+// standby may take over.
+func (l *Lease) Hold(ctx context.Context) error {
+	margin := l.ttl / 4
+	stepDown := time.NewTimer(l.ttl - margin)
+	defer stepDown.Stop()
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	// Renewals run apart from the timer, a hanging disk must not keep the
+	// server active past the lease
+	type renewal struct {
+		start time.Time
+		err   error
+	}
+	renewed := make(chan renewal, 1)
+	renewing := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			// A renewal finishing later would undo Release
+			if renewing {
+				<-renewed
+			}
+			return nil
+		case <-stepDown.C:
+			return fmt.Errorf("%w: not renewed before it expires", ErrLeaseLost)
+		case <-ticker.C:
+			if !renewing {
+				renewing = true
+				go func() {
+					start := time.Now()
+					renewed <- renewal{start: start, err: l.renew(start.Add(l.ttl))}
+				}()
+			}
+		case r := <-renewed:
+			renewing = false
+			if errors.Is(r.err, ErrLeaseLost) {
+				return r.err
+			}
+			if r.err != nil {
+				log.Printf("Failed to renew leader lease: %v", r.err)
+				continue
+			}
+			if !stepDown.Stop() {
+				<-stepDown.C
+			}
+			stepDown.Reset(time.Until(r.start.Add(l.ttl - margin)))
+		}
+	}
+}
+
+// Release expires the lease right away, so a standby does not have to wait
+// for the TTL on a graceful shutdown
+func (l *Lease) Release() error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record, err := l.read()
+	if err != nil {
+		return err
+	}
+	if !l.holds(record) {
+		return nil
+	}
+	return l.write(time.Now())
+}
+
+// tryAcquire takes the lease if it is free, expired or held by this server
+// under its name, e.g. before a restart
+func (l *Lease) tryAcquire() (bool, error) {
+	unlock, err := l.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	record, err := l.read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if err == nil && record.Holder != l.holder && time.Now().Before(record.Expires) {
+		return false, nil
+	}
+
+	l.generation = record.Generation + 1
+	if err := l.write(time.Now().Add(l.ttl)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// renew extends the held lease until expires. It returns ErrLeaseLost if
+// another server took the lease.
+func (l *Lease) renew(expires time.Time) error {
+	unlock, err := l.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record, err := l.read()
+	if err != nil {
+		return err
+	}
+	if !l.holds(record) {
+		return fmt.Errorf("%w: held by %s", ErrLeaseLost, record.Holder)
+	}
+	return l.write(expires)
+}
+
+// holds tells whether the record is the lease this server acquired
+func (l *Lease) holds(record leaseRecord) bool {
+	return record.Holder == l.holder && record.Generation == l.generation
+}
+
+// lock takes the exclusive lock of the lease file and returns the function
+// releasing it. The kernel releases it when the server dies.
+func (l *Lease) lock() (func(), error) {
+	file, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lease lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock lease: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+func (l *Lease) read() (leaseRecord, error) {
+	var record leaseRecord
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("failed to parse lease: %w", err)
+	}
+	return record, nil
+}
+
+func (l *Lease) write(expires time.Time) error {
+	data, err := json.Marshal(leaseRecord{Holder: l.holder, Generation: l.generation, Expires: expires})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// writeFileAtomic replaces the file through a rename, so readers never see
+// a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,136 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testTTL = 60 * time.Millisecond
+
+// TestLeaseSingleHolder tests that a standby waits until the lease expires
+func TestLeaseSingleHolder(t *testing.T) {
+	dir := t.TempDir()
+	active := NewLease(dir, "server-a", testTTL)
+	standby := NewLease(dir, "server-b", testTTL)
+
+	if err := active.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// The standby cannot take over a lease that is still valid
+	ctx, cancel := context.WithTimeout(context.Background(), testTTL/2)
+	defer cancel()
+	if err := standby.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected standby to wait for the lease, got %v", err)
+	}
+
+	// Without renewal the lease expires and the standby takes over
+	start := time.Now()
+	if err := standby.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*testTTL {
+		t.Errorf("Expected takeover within %v, took %v", 3*testTTL, elapsed)
+	}
+
+	// The former holder notices the takeover on its next renewal
+	holdCtx, holdCancel := context.WithTimeout(context.Background(), 3*testTTL)
+	defer holdCancel()
+	if err := active.Hold(holdCtx); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, got %v", err)
+	}
+}
+
+// TestLeaseHoldAndRelease tests that renewal keeps the lease and release
+// hands it over right away
+func TestLeaseHoldAndRelease(t *testing.T) {
+	dir := t.TempDir()
+	active := NewLease(dir, "server-a", testTTL)
+	standby := NewLease(dir, "server-b", testTTL)
+
+	if err := active.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	holdCtx, stopHolding := context.WithCancel(context.Background())
+	held := make(chan error, 1)
+	go func() { held <- active.Hold(holdCtx) }()
+
+	// Renewals keep the lease valid for longer than its TTL
+	ctx, cancel := context.WithTimeout(context.Background(), 3*testTTL)
+	defer cancel()
+	if err := standby.Acquire(ctx); err == nil {
+		t.Fatal("Expected standby not to acquire a renewed lease")
+	}
+
+	stopHolding()
+	if err := <-held; err != nil {
+		t.Fatalf("Hold() error = %v", err)
+	}
+	if err := active.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+
+	acquired, err := standby.tryAcquire()
+	if err != nil || !acquired {
+		t.Errorf("Expected standby to acquire a released lease, got %v, %v", acquired, err)
+	}
+}
+
+// TestLeaseRace tests that only one of several standbys starting together
+// acquires the lease
+func TestLeaseRace(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithTimeout(context.Background(), testTTL/2)
+	defer cancel()
+
+	var acquired atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(lease *Lease) {
+			defer wg.Done()
+			<-start
+			if lease.Acquire(ctx) == nil {
+				acquired.Add(1)
+			}
+		}(NewLease(dir, fmt.Sprintf("server-%d", i), time.Minute))
+	}
+	close(start)
+	wg.Wait()
+
+	if n := acquired.Load(); n != 1 {
+		t.Errorf("Expected 1 server to acquire the lease, got %d", n)
+	}
+}
+
+// TestLeaseStepsDown tests that a holder whose renewals fail gives up the
+// lease before it expires
+func TestLeaseStepsDown(t *testing.T) {
+	dir := t.TempDir()
+	active := NewLease(dir, "server-a", testTTL)
+	if err := active.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	acquired := time.Now()
+
+	// The lease cannot be read anymore, so renewals fail
+	path := filepath.Join(dir, leaseFileName)
+	os.Remove(path)
+	os.Mkdir(path, 0755)
+
+	if err := active.Hold(context.Background()); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("Expected ErrLeaseLost, got %v", err)
+	}
+	if elapsed := time.Since(acquired); elapsed >= testTTL {
+		t.Errorf("Expected to step down before the lease expires after %v, took %v", testTTL, elapsed)
+	}
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Caller is an HTTP client of the gateway. Its commands are signed with its
+// own key, so the servers authorize them by the client of that key.
+type Caller struct {
+	// TokenSHA256 is the hex encoded SHA-256 of the bearer token, so the
+	// file does not hold the tokens
+	TokenSHA256 string `json:"token_sha256"`
+	// SigningKeyFile is the key file the commands of the caller are signed
+	// with
+	SigningKeyFile string `json:"signing_key_file"`
+}
+
+// LoadCallers reads a callers file, a JSON object of the callers by name
+func LoadCallers(path string) (map[string]Caller, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read callers: %w", err)
+	}
+	var callers map[string]Caller
+	if err := json.Unmarshal(data, &callers); err != nil {
+		return nil, fmt.Errorf("failed to parse callers: %w", err)
+	}
+	if _, err := tokenIndex(callers); err != nil {
+		return nil, err
+	}
+	for name, caller := range callers {
+		if caller.SigningKeyFile == "" {
+			return nil, fmt.Errorf("caller %s has no signing key file", name)
+		}
+	}
+	return callers, nil
+}
+
+// TokenHash returns the TokenSHA256 of a bearer token
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenIndex maps the token hashes to the caller names
+func tokenIndex(callers map[string]Caller) (map[string]string, error) {
+	index := make(map[string]string, len(callers))
+	for name, caller := range callers {
+		hash := strings.ToLower(caller.TokenSHA256)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha256.Size {
+			return nil, fmt.Errorf("caller %s: invalid token_sha256", name)
+		}
+		if other, ok := index[hash]; ok {
+			return nil, fmt.Errorf("callers %s and %s have the same token", other, name)
+		}
+		index[hash] = name
+	}
+	return index, nil
+}
+
+// authenticate returns the name of the caller of the bearer token of the
+// request, false if it has none of a known caller
+func (g *gateway) authenticate(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	name, ok := g.tokens[TokenHash(token)]
+	return name, ok
+}
@@ -0,0 +1,247 @@
+// Package gateway serves the key commands over HTTP, for clients that do not
+// speak AMQP. Requests are sent as commands through the client SDK and
+// answered once their result arrived. Callers authenticate with a bearer
+// token and their commands are signed with their own key.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"eoracle-client-server/client"
+	"eoracle-client-server/internal/commands"
+)
+
+const (
+	keysPath = "/v1/keys"
+	// DefaultPageSize and MaxPageSize bound the items of a GET /v1/keys page
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// Client is the part of the client SDK the gateway uses
+type Client interface {
+	Add(ctx context.Context, key, value string) error
+	Get(ctx context.Context, key string) (string, error)
+	Delete(ctx context.Context, key string) error
+	GetPage(ctx context.Context, cursor string, limit int) ([]client.KeyValue, string, error)
+}
+
+// Config configures a gateway
+type Config struct {
+	// MaxBodySize bounds PUT bodies in bytes, 0 for unlimited
+	MaxBodySize int64
+	// Callers are the callers by name, see LoadCallers. Without callers
+	// requests are not authenticated and run as the caller "".
+	Callers map[string]Caller
+}
+
+// Item is a key-value pair in a response
+type Item struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Page is a page of GET /v1/keys. NextCursor is the cursor of the next
+// page, absent on the last page.
+type Page struct {
+	Items      []Item `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// errorBody is the response of failed requests
+type errorBody struct {
+	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+// putBody is the request of PUT /v1/keys/{key}
+type putBody struct {
+	Value *string `json:"value"`
+}
+
+type gateway struct {
+	clients func(caller, namespace string) Client
+	config  Config
+	tokens  map[string]string // caller names by token hash, nil without callers
+}
+
+// callerKey is the context key of the authenticated caller name
+type callerKey struct{}
+
+// NewHandler creates the HTTP handler of the gateway. clients returns the
+// client of a caller for a namespace, set by the namespace query parameter.
+func NewHandler(clients func(caller, namespace string) Client, config Config) (http.Handler, error) {
+	g := &gateway{clients: clients, config: config}
+	if len(config.Callers) > 0 {
+		var err error
+		if g.tokens, err = tokenIndex(config.Callers); err != nil {
+			return nil, err
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(keysPath, g.serveKeys)
+	mux.HandleFunc(keysPath+"/", g.serveKey)
+	if g.tokens == nil {
+		return mux, nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := g.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gateway"`)
+			writeError(w, http.StatusUnauthorized, "missing or unknown bearer token", "")
+			return
+		}
+		mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, caller)))
+	}), nil
+}
+
+// serveKeys lists the key-value pairs in order, a page at a time. Pages are
+// read on the server, following the cursor of the previous page.
+func (g *gateway) serveKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	limit, err := queryInt(r, "limit", DefaultPageSize, MaxPageSize)
+	if err != nil || limit == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxPageSize), "")
+		return
+	}
+
+	items, next, err := g.client(r).GetPage(r.Context(), r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	page := Page{Items: make([]Item, 0, len(items)), NextCursor: next}
+	for _, item := range items {
+		page.Items = append(page.Items, Item{Key: item.Key, Value: item.Value})
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// serveKey gets, sets or deletes the key of the path
+func (g *gateway) serveKey(w http.ResponseWriter, r *http.Request) {
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), keysPath+"/"))
+	if err != nil || key == "" {
+		writeError(w, http.StatusBadRequest, "invalid key in path", "")
+		return
+	}
+	c := g.client(r)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, err := c.Get(r.Context(), key)
+		if err != nil {
+			writeClientError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, Item{Key: key, Value: value})
+
+	case http.MethodPut:
+		if g.config.MaxBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, g.config.MaxBodySize)
+		}
+		var body putBody
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, http.StatusRequestEntityTooLarge, err.Error(), "")
+				return
+			}
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err), "")
+			return
+		}
+		if body.Value == nil {
+			writeError(w, http.StatusBadRequest, "invalid body: missing value", "")
+			return
+		}
+		if err := c.Add(r.Context(), key, *body.Value); err != nil {
+			writeClientError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodDelete:
+		if err := c.Delete(r.Context(), key); err != nil {
+			writeClientError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+	}
+}
+
+func (g *gateway) client(r *http.Request) Client {
+	caller, _ := r.Context().Value(callerKey{}).(string)
+	return g.clients(caller, r.URL.Query().Get("namespace"))
+}
+
+// queryInt parses a non-negative query parameter up to max
+func queryInt(r *http.Request, name string, def, max int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return n, nil
+}
+
+// writeClientError maps an error of the client SDK to a status code
+func writeClientError(w http.ResponseWriter, err error) {
+	var clientErr *client.Error
+	switch {
+	case errors.Is(err, client.ErrNotFound):
+		writeError(w, http.StatusNotFound, err.Error(), "")
+	case errors.As(err, &clientErr) && clientErr.Code == string(commands.CodeAccessDenied):
+		writeError(w, http.StatusForbidden, clientErr.Message, clientErr.Code)
+	case errors.As(err, &clientErr) && clientErr.Code != "":
+		writeError(w, http.StatusBadRequest, clientErr.Message, clientErr.Code)
+	case errors.As(err, &clientErr):
+		// Failed on the server, e.g. rejected by authentication
+		writeError(w, http.StatusUnprocessableEntity, clientErr.Message, "")
+	case errors.Is(err, client.ErrClosed):
+		writeError(w, http.StatusServiceUnavailable, err.Error(), "")
+	case errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, "timed out waiting for the server", "")
+	case errors.Is(err, context.Canceled):
+		// The caller went away, nobody reads the response
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		log.Printf("Gateway request failed: %v", err)
+		writeError(w, http.StatusBadGateway, err.Error(), "")
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed", "")
+}
+
+func writeError(w http.ResponseWriter, status int, message, code string) {
+	writeJSON(w, status, errorBody{Error: message, Code: code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"eoracle-client-server/client"
+)
+
+// fakeClient keeps the pairs of every namespace in insertion order
+type fakeClient struct {
+	mu     sync.Mutex
+	spaces map[string][]client.KeyValue
+	err    error // returned by every call if set
+}
+
+type fakeNamespace struct {
+	c    *fakeClient
+	name string
+}
+
+func (n fakeNamespace) Add(ctx context.Context, key, value string) error {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+	if n.c.err != nil {
+		return n.c.err
+	}
+	if strings.ContainsAny(key, " \t") {
+		return &client.Error{Code: "INVALID_KEY", Message: "invalid key"}
+	}
+	items := n.c.spaces[n.name]
+	for i := range items {
+		if items[i].Key == key {
+			items[i].Value = value
+			return nil
+		}
+	}
+	n.c.spaces[n.name] = append(items, client.KeyValue{Key: key, Value: value})
+	return nil
+}
+
+func (n fakeNamespace) Get(ctx context.Context, key string) (string, error) {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+	if n.c.err != nil {
+		return "", n.c.err
+	}
+	for _, item := range n.c.spaces[n.name] {
+		if item.Key == key {
+			return item.Value, nil
+		}
+	}
+	return "", client.ErrNotFound
+}
+
+func (n fakeNamespace) Delete(ctx context.Context, key string) error {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+	if n.c.err != nil {
+		return n.c.err
+	}
+	items := n.c.spaces[n.name]
+	for i := range items {
+		if items[i].Key == key {
+			n.c.spaces[n.name] = append(items[:i], items[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetPage uses the index of the next item as cursor
+func (n fakeNamespace) GetPage(ctx context.Context, cursor string, limit int) ([]client.KeyValue, string, error) {
+	n.c.mu.Lock()
+	defer n.c.mu.Unlock()
+	if n.c.err != nil {
+		return nil, "", n.c.err
+	}
+	items := n.c.spaces[n.name]
+	start := 0
+	if cursor != "" {
+		var err error
+		if start, err = strconv.Atoi(cursor); err != nil || start > len(items) {
+			return nil, "", &client.Error{Code: "INVALID_CURSOR", Message: "invalid page cursor"}
+		}
+	}
+	end := min(start+limit, len(items))
+	next := ""
+	if end < len(items) {
+		next = strconv.Itoa(end)
+	}
+	return append([]client.KeyValue(nil), items[start:end]...), next, nil
+}
+
+func newTestHandler(c *fakeClient) http.Handler {
+	handler, err := NewHandler(func(caller, name string) Client {
+		return fakeNamespace{c: c, name: name}
+	}, Config{MaxBodySize: 64})
+	if err != nil {
+		panic(err)
+	}
+	return handler
+}
+
+func TestGateway(t *testing.T) {
+	handler := newTestHandler(&fakeClient{spaces: make(map[string][]client.KeyValue)})
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"put", http.MethodPut, "/v1/keys/a", `{"value":"1"}`, http.StatusNoContent, ""},
+		{"put update", http.MethodPut, "/v1/keys/b", `{"value":"2"}`, http.StatusNoContent, ""},
+		{"put escaped key", http.MethodPut, "/v1/keys/user%2F1", `{"value":"3"}`, http.StatusNoContent, ""},
+		{"put namespace", http.MethodPut, "/v1/keys/a?namespace=prices", `{"value":"4"}`, http.StatusNoContent, ""},
+		{"get", http.MethodGet, "/v1/keys/a", "", http.StatusOK, `{"key":"a","value":"1"}`},
+		{"get escaped key", http.MethodGet, "/v1/keys/user%2F1", "", http.StatusOK, `{"key":"user/1","value":"3"}`},
+		{"get namespace", http.MethodGet, "/v1/keys/a?namespace=prices", "", http.StatusOK, `{"key":"a","value":"4"}`},
+		{"get missing", http.MethodGet, "/v1/keys/missing", "", http.StatusNotFound, `{"error":"key not found"}`},
+		{"list", http.MethodGet, "/v1/keys", "", http.StatusOK, `{"items":[{"key":"a","value":"1"},{"key":"b","value":"2"},{"key":"user/1","value":"3"}]}`},
+		{"list first page", http.MethodGet, "/v1/keys?limit=2", "", http.StatusOK, `{"items":[{"key":"a","value":"1"},{"key":"b","value":"2"}],"next_cursor":"2"}`},
+		{"list last page", http.MethodGet, "/v1/keys?limit=2&cursor=2", "", http.StatusOK, `{"items":[{"key":"user/1","value":"3"}]}`},
+		{"list empty namespace", http.MethodGet, "/v1/keys?namespace=empty", "", http.StatusOK, `{"items":[]}`},
+		{"list invalid limit", http.MethodGet, "/v1/keys?limit=0", "", http.StatusBadRequest, `{"error":"limit must be between 1 and 1000"}`},
+		{"list invalid cursor", http.MethodGet, "/v1/keys?cursor=10", "", http.StatusBadRequest, `{"error":"invalid page cursor","code":"INVALID_CURSOR"}`},
+		{"delete", http.MethodDelete, "/v1/keys/a", "", http.StatusNoContent, ""},
+		{"get deleted", http.MethodGet, "/v1/keys/a", "", http.StatusNotFound, `{"error":"key not found"}`},
+		{"put invalid key", http.MethodPut, "/v1/keys/has%20space", `{"value":"1"}`, http.StatusBadRequest, `{"error":"invalid key","code":"INVALID_KEY"}`},
+		{"put missing value", http.MethodPut, "/v1/keys/a", `{}`, http.StatusBadRequest, `{"error":"invalid body: missing value"}`},
+		{"put unknown field", http.MethodPut, "/v1/keys/a", `{"value":"1","ttl":5}`, http.StatusBadRequest, `{"error":"invalid body: json: unknown field \"ttl\""}`},
+		{"put too large", http.MethodPut, "/v1/keys/a", `{"value":"` + strings.Repeat("v", 100) + `"}`, http.StatusRequestEntityTooLarge, `{"error":"http: request body too large"}`},
+		{"empty key", http.MethodGet, "/v1/keys/", "", http.StatusBadRequest, `{"error":"invalid key in path"}`},
+		{"method not allowed", http.MethodPost, "/v1/keys/a", "", http.StatusMethodNotAllowed, `{"error":"method not allowed"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.wantBody {
+				t.Errorf("%s %s body = %s, want %s", tt.method, tt.target, got, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGateway_ClientErrors(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+	}{
+		{&client.Error{Code: "ACCESS_DENIED", Message: "access denied"}, http.StatusForbidden},
+		{&client.Error{Message: "unsigned command"}, http.StatusUnprocessableEntity},
+		{&client.Error{Code: "VALUE_TOO_LONG", Message: "value too long"}, http.StatusBadRequest},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{client.ErrClosed, http.StatusServiceUnavailable},
+		{errors.New("channel closed"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		handler := newTestHandler(&fakeClient{err: tt.err})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/keys/a", nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("status for %v = %d, want %d", tt.err, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type for %v = %q, want application/json", tt.err, got)
+		}
+	}
+}
+
+func TestGateway_Callers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "callers.json")
+	os.WriteFile(path, []byte(`{
+		"grafana": {"token_sha256": "`+TokenHash("grafana-token")+`", "signing_key_file": "grafana.key"},
+		"ingest": {"token_sha256": "`+TokenHash("ingest-token")+`", "signing_key_file": "ingest.key"}
+	}`), 0600)
+	callers, err := LoadCallers(path)
+	if err != nil {
+		t.Fatalf("LoadCallers() error = %v", err)
+	}
+
+	// Every caller has its own namespaces, like clients signing with
+	// their own keys
+	c := &fakeClient{spaces: make(map[string][]client.KeyValue)}
+	handler, err := NewHandler(func(caller, name string) Client {
+		return fakeNamespace{c: c, name: caller + "/" + name}
+	}, Config{Callers: callers})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+	serve := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/keys/a", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "unknown"} {
+		rec := serve(http.MethodGet, token, "")
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("GET with token %q status = %d, want %d", token, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	if rec := serve(http.MethodPut, "ingest-token", `{"value":"1"}`); rec.Code != http.StatusNoContent {
+		t.Errorf("PUT as ingest status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := serve(http.MethodGet, "ingest-token", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET as ingest status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serve(http.MethodGet, "grafana-token", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET as grafana status = %d, want %d from the client of grafana", rec.Code, http.StatusNotFound)
+	}
+
+	for _, content := range []string{
+		`{"a": {"token_sha256": "abc", "signing_key_file": "a.key"}}`,
+		`{"a": {"token_sha256": "` + TokenHash("t") + `"}}`,
+		`{"a": {"token_sha256": "` + TokenHash("t") + `", "signing_key_file": "a.key"}, "b": {"token_sha256": "` + TokenHash("t") + `", "signing_key_file": "b.key"}}`,
+	} {
+		os.WriteFile(path, []byte(content), 0600)
+		if _, err := LoadCallers(path); err == nil {
+			t.Errorf("LoadCallers(%s) error = nil", content)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package output
+
+import (
+	"strings"
+	"sync"
+
+	"eoracle-client-server/internal/storage"
+)
+
+// ItemWriter is implemented by outputs that also take the key-value pairs
+// of a result in structured form
+type ItemWriter interface {
+	WriteItems(items []storage.KeyValue)
+}
+
+// Recorder keeps the output of one command, e.g. to send it back to the
+// client, and passes the text on to the next output
+type Recorder struct {
+	next Output
+
+	mu    sync.Mutex
+	text  strings.Builder
+	items []storage.KeyValue
+}
+
+// NewRecorder creates a recorder writing through to next, nil to keep the
+// output only
+func NewRecorder(next Output) *Recorder {
+	return &Recorder{next: next}
+}
+
+func (r *Recorder) Write(data string) {
+	r.mu.Lock()
+	r.text.WriteString(data)
+	r.mu.Unlock()
+	if r.next != nil {
+		r.next.Write(data)
+	}
+}
+
+func (r *Recorder) WriteItems(items []storage.KeyValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, items...)
+}
+
+// Text returns everything written so far
+func (r *Recorder) Text() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.text.String()
+}
+
+// Items returns the key-value pairs written so far
+func (r *Recorder) Items() []storage.KeyValue {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]storage.KeyValue(nil), r.items...)
+}
+
+// Close does not close the next output, it is shared by other commands
+func (r *Recorder) Close() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package output
+
+import (
+	"reflect"
+	"testing"
+
+	"eoracle-client-server/internal/storage"
+)
+
+type collectOutput struct {
+	data string
+}
+
+func (c *collectOutput) Write(data string) { c.data += data }
+func (c *collectOutput) Close() error      { return nil }
+
+func TestRecorder(t *testing.T) {
+	next := &collectOutput{}
+	r := NewRecorder(next)
+
+	r.Write("a = 1\n")
+	r.WriteItems([]storage.KeyValue{{Key: "a", Value: "1"}})
+	r.Write("b = 2\n")
+	r.WriteItems([]storage.KeyValue{{Key: "b", Value: "2"}})
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if r.Text() != "a = 1\nb = 2\n" || next.data != r.Text() {
+		t.Errorf("Text() = %q, next output got %q", r.Text(), next.data)
+	}
+	want := []storage.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}
+	if got := r.Items(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Items() = %v, want %v", got, want)
+	}
+
+	// Without a next output the text is only recorded
+	NewRecorder(nil).Write("ignored")
+}
@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"fmt"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/compress"
+
+	"github.com/streadway/amqp"
+)
+
+// maxBodySize bounds decompressed bodies, it is the default maximum message
+// size of RabbitMQ
+const maxBodySize = 128 << 20
+
+// compressBody gzips a body of at least threshold bytes and returns it with
+// its content encoding. Bodies that do not shrink are sent as they are.
+func compressBody(body []byte, threshold int) ([]byte, string) {
+	if threshold <= 0 || len(body) < threshold {
+		return body, ""
+	}
+	compressed := compress.Compress(body)
+	if len(compressed) >= len(body) {
+		return body, ""
+	}
+	return compressed, compress.Gzip
+}
+
+// decodeMessage decompresses the body of a message and decodes the command
+// with the codec of its content type. An unknown content encoding is
+// retryable like an unknown content type, a newer server may read it.
+func decodeMessage(msg amqp.Delivery) (commands.Command, error) {
+	body := msg.Body
+	switch msg.ContentEncoding {
+	case "", "identity":
+	case compress.Gzip:
+		var err error
+		if body, err = compress.Decompress(body, maxBodySize); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, commands.Retryable(fmt.Errorf("unsupported content encoding %q", msg.ContentEncoding))
+	}
+	return commands.Decode(msg.ContentType, body)
+}
@@ -0,0 +1,101 @@
+package queue
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/compress"
+
+	"github.com/streadway/amqp"
+)
+
+func TestCompressBody(t *testing.T) {
+	large := []byte(strings.Repeat("value ", 100))
+	tests := []struct {
+		name      string
+		body      []byte
+		threshold int
+		want      string
+	}{
+		{"disabled", large, 0, ""},
+		{"below threshold", large, len(large) + 1, ""},
+		{"at threshold", large, len(large), compress.Gzip},
+		{"does not shrink", []byte("ab"), 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, encoding := compressBody(tt.body, tt.threshold)
+			if encoding != tt.want {
+				t.Fatalf("compressBody() encoding = %q, want %q", encoding, tt.want)
+			}
+			if encoding == "" && string(body) != string(tt.body) {
+				t.Errorf("compressBody() changed an uncompressed body")
+			}
+		})
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	cmd := commands.NewCommand(commands.AddItem, "feed:eth", strings.Repeat("42.0,", 1000))
+	plain, _ := cmd.ToJSON()
+	compressed, encoding := compressBody(plain, 1)
+	if encoding != compress.Gzip {
+		t.Fatalf("Expected the command to be compressed")
+	}
+
+	tests := []struct {
+		name      string
+		msg       amqp.Delivery
+		wantErr   bool
+		retryable bool
+	}{
+		{"plain", amqp.Delivery{Body: plain}, false, false},
+		{"gzip", amqp.Delivery{ContentEncoding: compress.Gzip, Body: compressed}, false, false},
+		{"gzip binary codec", amqp.Delivery{ContentType: commands.ContentTypeMsgPack, ContentEncoding: compress.Gzip,
+			Body: compress.Compress(mustMarshal(t, commands.MsgPack, cmd))}, false, false},
+		{"corrupt gzip", amqp.Delivery{ContentEncoding: compress.Gzip, Body: plain}, true, false},
+		{"unknown encoding", amqp.Delivery{ContentEncoding: "br", Body: compressed}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMessage(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if commands.IsRetryable(err) != tt.retryable {
+					t.Errorf("IsRetryable(%v) = %v, want %v", err, commands.IsRetryable(err), tt.retryable)
+				}
+				return
+			}
+			if got.GetValue() != cmd.GetValue() {
+				t.Errorf("decodeMessage() value of %d bytes, want %d", len(got.GetValue()), len(cmd.GetValue()))
+			}
+		})
+	}
+}
+
+// TestSettle_KeepsEncoding tests that retried messages keep their encoding
+func TestSettle_KeepsEncoding(t *testing.T) {
+	s, publisher := newTestSettler(3, "")
+	msg := amqp.Delivery{Acknowledger: &recordingAcknowledger{}, ContentType: commands.ContentTypeMsgPack,
+		ContentEncoding: compress.Gzip, Body: []byte{1}}
+
+	s.settle(msg, errors.New("not executed"))
+
+	retried := publisher.published["write-commands"]
+	if len(retried) != 1 || retried[0].ContentType != msg.ContentType || retried[0].ContentEncoding != msg.ContentEncoding {
+		t.Errorf("Expected retry with content type and encoding, got %+v", retried)
+	}
+}
+
+func mustMarshal(t *testing.T, codec commands.Codec, cmd commands.Command) []byte {
+	t.Helper()
+	data, err := codec.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	return data
+}
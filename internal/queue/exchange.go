@@ -0,0 +1,159 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"eoracle-client-server/internal/storage"
+
+	"github.com/streadway/amqp"
+)
+
+// RabbitMQExchange publishes change events to a RabbitMQ fanout exchange
+type RabbitMQExchange struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	exchange string
+}
+
+// NewRabbitMQExchange connects to RabbitMQ and declares a durable fanout exchange
+func NewRabbitMQExchange(broker *Broker, exchangeName string) (*RabbitMQExchange, error) {
+	conn, err := broker.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	err = ch.ExchangeDeclare(
+		exchangeName, // name
+		"fanout",     // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	return &RabbitMQExchange{
+		conn:     conn,
+		channel:  ch,
+		exchange: exchangeName,
+	}, nil
+}
+
+// PublishChange sends a change event to the exchange
+func (r *RabbitMQExchange) PublishChange(event storage.ChangeEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize change event: %w", err)
+	}
+
+	err = r.channel.Publish(
+		r.exchange, // exchange
+		"",         // routing key
+		false,      // mandatory
+		false,      // immediate
+		amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         body,
+		})
+	if err != nil {
+		return fmt.Errorf("failed to publish change event: %w", err)
+	}
+
+	return nil
+}
+
+// PublishChanges publishes events in the order they are received until the
+// channel is closed. It keeps draining after publish failures so that writers
+// emitting to the feed are never blocked by a broken broker connection.
+func (r *RabbitMQExchange) PublishChanges(events <-chan storage.ChangeEvent) {
+	for event := range events {
+		if err := r.PublishChange(event); err != nil {
+			log.Printf("Failed to publish change event %d: %v", event.Version, err)
+		}
+	}
+	log.Println("Stopped change publishing")
+}
+
+// SubscribeChanges binds a private, auto-deleted queue to the exchange and
+// calls handler for every change event until the context is done
+func (r *RabbitMQExchange) SubscribeChanges(ctx context.Context, handler func(storage.ChangeEvent)) error {
+	q, err := r.channel.QueueDeclare(
+		"",    // name, generated by the server
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare watch queue: %w", err)
+	}
+
+	if err := r.channel.QueueBind(q.Name, "", r.exchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind watch queue: %w", err)
+	}
+
+	msgs, err := r.channel.Consume(
+		q.Name, // queue
+		"",     // consumer
+		true,   // auto-ack
+		true,   // exclusive
+		false,  // no-local
+		false,  // no-wait
+		nil,    // args
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	// Deleting the queue also cancels the consumer and frees the broker side
+	defer func() {
+		if _, err := r.channel.QueueDelete(q.Name, false, false, false); err != nil {
+			log.Printf("Failed to delete watch queue: %v", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return errors.New("change subscription closed by broker")
+			}
+			var event storage.ChangeEvent
+			if err := json.Unmarshal(msg.Body, &event); err != nil {
+				log.Printf("Failed to parse change event: %v", err)
+				continue
+			}
+			handler(event)
+		}
+	}
+}
+
+// Close closes the connection
+func (r *RabbitMQExchange) Close() error {
+	if r.channel != nil {
+		r.channel.Close()
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// MemoryQueue is an in-process Queue, e.g. to test clients and servers
+// without a broker. It follows the Subscribe contract: handled messages are
+// removed, messages not executed are requeued, rejected ones are dropped and
+// other failures are retried up to maxRetries times. Requests get their
+// result like through RabbitMQ.
+type MemoryQueue struct {
+	maxRetries int
+
+	mu       sync.Mutex
+	messages []memoryMessage
+	ready    chan struct{} // signaled when a message is added
+	closed   bool
+}
+
+type memoryMessage struct {
+	cmd     commands.Command
+	retries int
+	reply   chan commands.Result // nil for published commands
+}
+
+// ErrQueueClosed is returned by a closed MemoryQueue
+var ErrQueueClosed = errors.New("queue closed")
+
+// NewMemoryQueue creates an empty queue retrying failed commands up to
+// maxRetries times
+func NewMemoryQueue(maxRetries int) *MemoryQueue {
+	return &MemoryQueue{
+		maxRetries: maxRetries,
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// Publish adds a command to the back of the queue
+func (q *MemoryQueue) Publish(cmd commands.Command) error {
+	return q.push(memoryMessage{cmd: cmd})
+}
+
+// Request publishes a command and waits for its result
+func (q *MemoryQueue) Request(ctx context.Context, cmd commands.Command) (commands.Result, error) {
+	reply := make(chan commands.Result, 1)
+	if err := q.push(memoryMessage{cmd: cmd, reply: reply}); err != nil {
+		return commands.Result{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return commands.Result{}, ctx.Err()
+	case result := <-reply:
+		return result, nil
+	}
+}
+
+// Subscribe hands messages to the handler concurrently until the context is
+// done, then waits for the running handlers
+func (q *MemoryQueue) Subscribe(ctx context.Context, handler func(commands.Command) error) error {
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+
+	for {
+		msg, ok := q.pop(ctx)
+		if !ok {
+			return nil
+		}
+
+		cmd := msg.cmd
+		if msg.reply != nil {
+			cmd = request{Command: msg.cmd, reply: func(result commands.Result) error {
+				sendResult(msg.reply, result)
+				return nil
+			}}
+		}
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			q.settle(msg, handler(cmd))
+		}()
+	}
+}
+
+// Depth returns the number of waiting messages
+func (q *MemoryQueue) Depth() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages), nil
+}
+
+// Close stops taking messages, subscriptions return once their handlers did
+func (q *MemoryQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ready)
+	}
+	return nil
+}
+
+func (q *MemoryQueue) push(msg memoryMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.messages = append(q.messages, msg)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pop waits for the next message, false once the context is done or the
+// queue is closed
+func (q *MemoryQueue) pop(ctx context.Context) (memoryMessage, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return memoryMessage{}, false
+		}
+		if len(q.messages) > 0 {
+			msg := q.messages[0]
+			q.messages = q.messages[1:]
+			if len(q.messages) > 0 {
+				// Wake up other subscribers
+				select {
+				case q.ready <- struct{}{}:
+				default:
+				}
+			}
+			q.mu.Unlock()
+			return msg, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return memoryMessage{}, false
+		case <-q.ready:
+		}
+	}
+}
+
+// settle applies the handler result like the RabbitMQ settler
+func (q *MemoryQueue) settle(msg memoryMessage, err error) {
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		q.requeue(msg)
+		return
+	case !isRejected(err) && msg.retries < q.maxRetries:
+		msg.retries++
+		q.requeue(msg)
+		return
+	}
+
+	log.Printf("Dropped command: %v", err)
+	if msg.reply != nil {
+		var rejectErr *RejectError
+		if errors.As(err, &rejectErr) {
+			err = rejectErr.Err
+		}
+		sendResult(msg.reply, commands.NewResult("", nil, err))
+	}
+}
+
+// requeue adds a message back, dropping it if the queue was closed
+func (q *MemoryQueue) requeue(msg memoryMessage) {
+	if err := q.push(msg); err != nil && msg.reply != nil {
+		sendResult(msg.reply, commands.NewResult("", nil, err))
+	}
+}
+
+// sendResult delivers the first result of a request, later ones are dropped
+func sendResult(reply chan commands.Result, result commands.Result) {
+	select {
+	case reply <- result:
+	default:
+	}
+}
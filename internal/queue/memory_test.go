@@ -0,0 +1,84 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+)
+
+func TestMemoryQueue_Requests(t *testing.T) {
+	q := NewMemoryQueue(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var attempts atomic.Int32
+	go q.Subscribe(ctx, func(cmd commands.Command) error {
+		switch cmd.GetKey() {
+		case "flaky":
+			if attempts.Add(1) < 3 {
+				return errors.New("not yet")
+			}
+		case "failing":
+			return errors.New("always")
+		case "invalid":
+			return Reject(&commands.ValidationError{Code: commands.CodeInvalidKey, Field: "key", Detail: "invalid"})
+		}
+		if request, ok := cmd.(Request); ok {
+			return request.Reply(commands.Result{Output: cmd.GetKey()})
+		}
+		return nil
+	})
+
+	tests := []struct {
+		key     string
+		want    string
+		wantErr string
+		code    commands.ErrorCode
+	}{
+		{key: "ok", want: "ok"},
+		{key: "flaky", want: "flaky"},
+		{key: "failing", wantErr: "always"},
+		{key: "invalid", wantErr: "invalid key (INVALID_KEY): invalid", code: commands.CodeInvalidKey},
+	}
+	for _, tt := range tests {
+		result, err := q.Request(ctx, commands.NewCommand(commands.GetItem, tt.key, ""))
+		if err != nil {
+			t.Fatalf("Request(%s) error = %v", tt.key, err)
+		}
+		if result.Output != tt.want || result.Error != tt.wantErr || result.Code != tt.code {
+			t.Errorf("Request(%s) = %+v, want output %q, error %q, code %q", tt.key, result, tt.want, tt.wantErr, tt.code)
+		}
+	}
+}
+
+func TestMemoryQueue_RequestTimeout(t *testing.T) {
+	q := NewMemoryQueue(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := q.Request(ctx, commands.NewCommand(commands.GetItem, "a", "")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Request() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if depth, _ := q.Depth(); depth != 1 {
+		t.Errorf("Depth() = %d, want the unanswered request to stay queued", depth)
+	}
+}
+
+func TestMemoryQueue_Close(t *testing.T) {
+	q := NewMemoryQueue(0)
+	done := make(chan struct{})
+	go func() {
+		q.Subscribe(context.Background(), func(commands.Command) error { return nil })
+		close(done)
+	}()
+
+	q.Close()
+	<-done
+	if err := q.Publish(commands.NewCommand(commands.GetItem, "a", "")); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Publish() error = %v, want %v", err, ErrQueueClosed)
+	}
+}
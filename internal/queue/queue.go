@@ -2,12 +2,67 @@ package queue
 
 import (
 	"context"
+	"errors"
+
 	"eoracle-client-server/internal/commands"
 )
 
-// Queue interface defines the operations for message queue
+// Queue interface defines the operations for message queue. Subscribe may
+// call the handler concurrently; a message is acknowledged only after the
+// handler returned nil. On a context error it is requeued, on a RejectError
+// it is dead-lettered and on other errors it is retried. When the context is
+// done, Subscribe stops taking messages and returns once the running
+// handlers have returned.
 type Queue interface {
 	Publish(command commands.Command) error
 	Subscribe(ctx context.Context, handleCommand func(commands.Command) error) error
 	Close() error
 }
+
+// DepthReporter is implemented by queues that can report how many messages
+// are waiting to be consumed
+type DepthReporter interface {
+	Depth() (int, error)
+}
+
+// InFlightLimiter is implemented by queues that can bound the number of
+// messages delivered to a consumer but not yet acknowledged
+type InFlightLimiter interface {
+	SetInFlightLimit(limit int) error
+}
+
+// CodecSetter is implemented by queues that can publish commands in another
+// encoding than JSON
+type CodecSetter interface {
+	SetCodec(codec commands.Codec)
+}
+
+// CompressionSetter is implemented by queues that can compress large
+// message bodies
+type CompressionSetter interface {
+	SetCompression(threshold int)
+}
+
+// RejectError marks a command that failed permanently and must not be retried
+type RejectError struct {
+	Err error
+}
+
+func (e *RejectError) Error() string {
+	return "rejected: " + e.Err.Error()
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// Reject wraps err so the message is dead-lettered instead of retried
+func Reject(err error) error {
+	return &RejectError{Err: err}
+}
+
+// isRejected tells whether a handler error rejects the message
+func isRejected(err error) bool {
+	var rejectErr *RejectError
+	return errors.As(err, &rejectErr)
+}
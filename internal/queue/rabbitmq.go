@@ -2,24 +2,70 @@ package queue
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"sync"
 
 	"eoracle-client-server/internal/commands"
 
 	"github.com/streadway/amqp"
 )
 
+// ConsumerConfig tunes how Subscribe consumes a queue
+type ConsumerConfig struct {
+	// Prefetch is the number of unacked messages per channel, 0 to follow
+	// the in-flight limit of the consumer or to not limit them at all
+	Prefetch int
+	// Channels is the number of consumer channels, 0 for one
+	Channels int
+	// Tag is the consumer tag prefix, empty for broker generated tags
+	Tag string
+	// Priority makes the broker prefer consumers with a higher priority
+	Priority int
+	// MaxRetries is how often a command failing with a retryable error is
+	// executed again before it is dead-lettered
+	MaxRetries int
+	// DeadLetterQueue receives commands that failed permanently or ran out
+	// of retries, empty to drop them
+	DeadLetterQueue string
+}
+
 // RabbitMQQueue implements Queue interface using RabbitMQ
 type RabbitMQQueue struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	queue   amqp.Queue
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	queue    amqp.Queue
+	consumer ConsumerConfig
+
+	mu            sync.Mutex
+	codec         commands.Codec
+	compressAbove int
+	// pending requests by correlation id, nil until the first request
+	pending          map[string]chan commands.Result
+	inFlightLimit    int
+	consumerChannels []*amqp.Channel
 }
 
 // NewRabbitMQQueue creates a new RabbitMQ queue
-func NewRabbitMQQueue(url, queueName string) (Queue, error) {
-	conn, err := amqp.Dial(url)
+func NewRabbitMQQueue(broker *Broker, queueName string) (Queue, error) {
+	return NewRabbitMQConsumerQueue(broker, queueName, ConsumerConfig{})
+}
+
+// NewRabbitMQConsumerQueue creates a new RabbitMQ queue consumed with the
+// given config
+func NewRabbitMQConsumerQueue(broker *Broker, queueName string, consumer ConsumerConfig) (Queue, error) {
+	if consumer.Prefetch < 0 || consumer.Channels < 0 || consumer.MaxRetries < 0 {
+		return nil, fmt.Errorf("invalid consumer config: prefetch %d, channels %d, max retries %d",
+			consumer.Prefetch, consumer.Channels, consumer.MaxRetries)
+	}
+	if consumer.Channels == 0 {
+		consumer.Channels = 1
+	}
+
+	conn, err := broker.dial()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
@@ -44,29 +90,149 @@ func NewRabbitMQQueue(url, queueName string) (Queue, error) {
 		return nil, fmt.Errorf("failed to declare queue: %w", err)
 	}
 
+	if consumer.DeadLetterQueue != "" {
+		_, err = ch.QueueDeclare(consumer.DeadLetterQueue, true, false, false, false, nil)
+		if err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, fmt.Errorf("failed to declare dead letter queue: %w", err)
+		}
+	}
+
 	return &RabbitMQQueue{
-		conn:    conn,
-		channel: ch,
-		queue:   q,
+		conn:     conn,
+		channel:  ch,
+		queue:    q,
+		consumer: consumer,
+		codec:    commands.JSON,
 	}, nil
 }
 
+// SetCodec selects the encoding of published commands. Consumers decode
+// every codec by the content type of the message.
+func (r *RabbitMQQueue) SetCodec(codec commands.Codec) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codec = codec
+}
+
+// SetCompression gzips published bodies of at least threshold bytes, 0 to
+// disable. Consumers decompress by the content encoding of the message.
+func (r *RabbitMQQueue) SetCompression(threshold int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.compressAbove = threshold
+}
+
 // Publish sends a command to the queue
 func (r *RabbitMQQueue) Publish(command commands.Command) error {
-	body, err := command.ToJSON()
+	return r.publish(command, amqp.Publishing{})
+}
+
+// Request publishes a command and waits for its result. Replies arrive
+// through direct reply-to on the publishing channel, so no reply queue is
+// declared.
+func (r *RabbitMQQueue) Request(ctx context.Context, command commands.Command) (commands.Result, error) {
+	id, err := newCorrelationID()
+	if err != nil {
+		return commands.Result{}, err
+	}
+	reply := make(chan commands.Result, 1)
+	if err := r.addPending(id, reply); err != nil {
+		return commands.Result{}, err
+	}
+	defer r.removePending(id)
+
+	if err := r.publish(command, amqp.Publishing{ReplyTo: directReplyTo, CorrelationId: id}); err != nil {
+		return commands.Result{}, err
+	}
+	select {
+	case <-ctx.Done():
+		return commands.Result{}, ctx.Err()
+	case result, ok := <-reply:
+		if !ok {
+			return commands.Result{}, errors.New("reply channel closed")
+		}
+		return result, nil
+	}
+}
+
+// addPending registers a request, consuming replies on the first one
+func (r *RabbitMQQueue) addPending(id string, reply chan commands.Result) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending == nil {
+		replies, err := r.channel.Consume(
+			directReplyTo, // queue
+			"",            // consumer
+			true,          // auto-ack, required by direct reply-to
+			false,         // exclusive
+			false,         // no-local
+			false,         // no-wait
+			nil,           // args
+		)
+		if err != nil {
+			return fmt.Errorf("failed to consume replies: %w", err)
+		}
+		r.pending = make(map[string]chan commands.Result)
+		go r.dispatchReplies(replies)
+	}
+	r.pending[id] = reply
+	return nil
+}
+
+func (r *RabbitMQQueue) removePending(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.pending, id)
+}
+
+// dispatchReplies hands replies to the waiting requests until the channel
+// is closed, then fails the requests still waiting
+func (r *RabbitMQQueue) dispatchReplies(replies <-chan amqp.Delivery) {
+	for msg := range replies {
+		var result commands.Result
+		if err := json.Unmarshal(msg.Body, &result); err != nil {
+			log.Printf("Failed to decode reply %s: %v", msg.CorrelationId, err)
+			continue
+		}
+		r.mu.Lock()
+		if reply, ok := r.pending[msg.CorrelationId]; ok {
+			reply <- result
+			delete(r.pending, msg.CorrelationId)
+		}
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, reply := range r.pending {
+		close(reply)
+	}
+	// The next request fails to consume on the closed channel
+	r.pending = nil
+}
+
+// publish encodes the command with the properties of msg
+func (r *RabbitMQQueue) publish(command commands.Command, msg amqp.Publishing) error {
+	r.mu.Lock()
+	codec, threshold := r.codec, r.compressAbove
+	r.mu.Unlock()
+
+	body, err := codec.Marshal(command)
 	if err != nil {
 		return fmt.Errorf("failed to serialize command: %w", err)
 	}
+	msg.Body, msg.ContentEncoding = compressBody(body, threshold)
+	msg.ContentType = codec.ContentType()
 
 	err = r.channel.Publish(
 		"",           // exchange
 		r.queue.Name, // routing key
 		false,        // mandatory
 		false,        // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		})
+		msg)
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
@@ -74,43 +240,165 @@ func (r *RabbitMQQueue) Publish(command commands.Command) error {
 	return nil
 }
 
-// Subscribe listens for messages and handles them
+// Subscribe listens for messages on every consumer channel and handles them
+// concurrently. The number of running handlers is bounded by the prefetch.
 func (r *RabbitMQQueue) Subscribe(ctx context.Context, handler func(commands.Command) error) error {
-	msgs, err := r.channel.Consume(
-		r.queue.Name, // queue
-		"",           // consumer
-		false,        // auto-ack
-		false,        // exclusive
-		false,        // no-local
-		false,        // no-wait
-		nil,          // args
-	)
-	if err != nil {
-		return fmt.Errorf("failed to register consumer: %w", err)
+	channels := make([]*amqp.Channel, r.consumer.Channels)
+	deliveries := make([]<-chan amqp.Delivery, r.consumer.Channels)
+	defer func() {
+		r.mu.Lock()
+		r.consumerChannels = nil
+		r.mu.Unlock()
+		for _, ch := range channels {
+			if ch != nil {
+				ch.Close()
+			}
+		}
+	}()
+
+	r.mu.Lock()
+	prefetch := r.prefetch()
+	r.mu.Unlock()
+
+	for i := range channels {
+		ch, err := r.conn.Channel()
+		if err != nil {
+			return fmt.Errorf("failed to open consumer channel: %w", err)
+		}
+		channels[i] = ch
+
+		// Bound the unacked messages the broker pushes to this channel
+		if err := ch.Qos(prefetch, 0, false); err != nil {
+			return fmt.Errorf("failed to set prefetch: %w", err)
+		}
+
+		var args amqp.Table
+		if r.consumer.Priority != 0 {
+			args = amqp.Table{"x-priority": int32(r.consumer.Priority)}
+		}
+		msgs, err := ch.Consume(
+			r.queue.Name,     // queue
+			r.consumerTag(i), // consumer
+			false,            // auto-ack
+			false,            // exclusive
+			false,            // no-local
+			false,            // no-wait
+			args,             // args
+		)
+		if err != nil {
+			return fmt.Errorf("failed to register consumer: %w", err)
+		}
+		deliveries[i] = msgs
+	}
+
+	r.mu.Lock()
+	r.consumerChannels = channels
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for i, msgs := range deliveries {
+		ch := channels[i]
+		s := settler{
+			queue:      r.queue.Name,
+			maxRetries: r.consumer.MaxRetries,
+			deadLetter: r.consumer.DeadLetterQueue,
+			publish: func(routingKey string, msg amqp.Publishing) error {
+				return ch.Publish("", routingKey, false, false, msg)
+			},
+		}
+		wg.Add(1)
+		go func(msgs <-chan amqp.Delivery) {
+			defer wg.Done()
+			consume(ctx, msgs, handler, s)
+		}(msgs)
 	}
+	wg.Wait()
+	log.Println("Stopping queue subscription")
+	return nil
+}
+
+// consume handles deliveries of one channel concurrently until the context
+// is done, then waits for the running handlers. Deliveries not taken yet stay
+// unacked and are requeued by the broker when the channel is closed.
+func consume(ctx context.Context, msgs <-chan amqp.Delivery, handler func(commands.Command) error, s settler) {
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Stopping queue subscription")
-			return nil
-		case msg := <-msgs:
-			cmd, err := commands.FromJSON(msg.Body)
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				log.Println("Consumer channel closed by broker")
+				return
+			}
+			cmd, err := decodeMessage(msg)
 			if err != nil {
-				log.Printf("Failed to parse command: %v", err)
-				msg.Nack(false, false) // Do not requeue the message
+				// A newer schema version or codec is retried, a newer
+				// server may read it
+				if !commands.IsRetryable(err) {
+					err = Reject(err)
+				}
+				s.settle(msg, err)
 				continue
 			}
 
-			if err := handler(cmd); err != nil {
-				log.Printf("Failed to handle command: %v", err)
-				msg.Nack(false, true) // Requeue the message
-				continue
+			if msg.ReplyTo != "" {
+				cmd = request{Command: cmd, reply: func(result commands.Result) error {
+					return s.replyResult(msg, result)
+				}}
 			}
 
-			msg.Ack(false)
+			inFlight.Add(1)
+			go func(msg amqp.Delivery) {
+				defer inFlight.Done()
+				s.settle(msg, handler(cmd))
+			}(msg)
+		}
+	}
+}
+
+// SetInFlightLimit spreads the limit of unacked messages over the consumer
+// channels. An explicit Prefetch in the consumer config takes precedence.
+func (r *RabbitMQQueue) SetInFlightLimit(limit int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inFlightLimit = limit
+	prefetch := r.prefetch()
+	for _, ch := range r.consumerChannels {
+		if err := ch.Qos(prefetch, 0, false); err != nil {
+			return fmt.Errorf("failed to set prefetch: %w", err)
 		}
 	}
+	return nil
+}
+
+// prefetch returns the prefetch count per channel, 0 for unlimited. It is
+// called with the lock held.
+func (r *RabbitMQQueue) prefetch() int {
+	if r.consumer.Prefetch > 0 || r.inFlightLimit <= 0 {
+		return r.consumer.Prefetch
+	}
+	return (r.inFlightLimit + r.consumer.Channels - 1) / r.consumer.Channels
+}
+
+// consumerTag returns the tag of the i-th consumer channel
+func (r *RabbitMQQueue) consumerTag(i int) string {
+	if r.consumer.Tag == "" {
+		return ""
+	}
+	return r.consumer.Tag + "-" + r.queue.Name + "-" + strconv.Itoa(i)
+}
+
+// Depth returns the number of messages ready in the queue
+func (r *RabbitMQQueue) Depth() (int, error) {
+	q, err := r.channel.QueueInspect(r.queue.Name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect queue: %w", err)
+	}
+	return q.Messages, nil
 }
 
 // Close closes the connection
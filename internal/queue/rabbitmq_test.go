@@ -0,0 +1,307 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+
+	"github.com/streadway/amqp"
+)
+
+func TestRabbitMQQueue_Prefetch(t *testing.T) {
+	tests := []struct {
+		name          string
+		consumer      ConsumerConfig
+		inFlightLimit int
+		want          int
+	}{
+		{"unlimited", ConsumerConfig{Channels: 1}, 0, 0},
+		{"explicit prefetch", ConsumerConfig{Prefetch: 50, Channels: 2}, 10, 50},
+		{"aligned with limit", ConsumerConfig{Channels: 1}, 10, 10},
+		{"limit spread over channels", ConsumerConfig{Channels: 3}, 10, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RabbitMQQueue{consumer: tt.consumer, inFlightLimit: tt.inFlightLimit}
+			if got := r.prefetch(); got != tt.want {
+				t.Errorf("prefetch() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRabbitMQQueue_ConsumerTag(t *testing.T) {
+	r := &RabbitMQQueue{queue: amqp.Queue{Name: "write-commands"}}
+	if got := r.consumerTag(0); got != "" {
+		t.Errorf("consumerTag() = %q, want broker generated tag", got)
+	}
+
+	r.consumer.Tag = "server-a"
+	if got := r.consumerTag(1); got != "server-a-write-commands-1" {
+		t.Errorf("consumerTag() = %q, want server-a-write-commands-1", got)
+	}
+}
+
+// recordingAcknowledger records how deliveries were settled
+type recordingAcknowledger struct {
+	mu       sync.Mutex
+	acked    []uint64
+	requeued []uint64
+	rejected []uint64
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if requeue {
+		a.requeued = append(a.requeued, tag)
+	} else {
+		a.rejected = append(a.rejected, tag)
+	}
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// recordingPublisher records forwarded messages by routing key
+type recordingPublisher struct {
+	mu        sync.Mutex
+	published map[string][]amqp.Publishing
+}
+
+func (p *recordingPublisher) publish(routingKey string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published[routingKey] = append(p.published[routingKey], msg)
+	return nil
+}
+
+func newTestSettler(maxRetries int, deadLetter string) (settler, *recordingPublisher) {
+	publisher := &recordingPublisher{published: make(map[string][]amqp.Publishing)}
+	return settler{
+		queue:      "write-commands",
+		maxRetries: maxRetries,
+		deadLetter: deadLetter,
+		publish:    publisher.publish,
+	}, publisher
+}
+
+// TestConsume tests that messages are settled only after they were handled
+// and that consume waits for running handlers when stopped
+func TestConsume(t *testing.T) {
+	ack := &recordingAcknowledger{}
+	msgs := make(chan amqp.Delivery, 10)
+	for i, key := range []string{"ok", "fail", "bad", "", "slow"} {
+		body, _ := commands.NewCommand(commands.GetItem, key, "").ToJSON()
+		if key == "" {
+			body = []byte("not json")
+		}
+		msgs <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), Body: body}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var handled atomic.Int32
+	handler := func(cmd commands.Command) error {
+		defer handled.Add(1)
+		switch cmd.GetKey() {
+		case "fail":
+			return errors.New("not executed")
+		case "bad":
+			return Reject(errors.New("invalid"))
+		case "slow":
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	}
+
+	s, publisher := newTestSettler(1, "write-commands.dead")
+	done := make(chan struct{})
+	go func() {
+		consume(ctx, msgs, handler, s)
+		close(done)
+	}()
+
+	// Stop while the slow command is running
+	<-started
+	cancel()
+	<-done
+
+	if handled.Load() != 4 {
+		t.Errorf("Expected consume to wait for 4 handlers, %d returned", handled.Load())
+	}
+	sort.Slice(ack.acked, func(i, j int) bool { return ack.acked[i] < ack.acked[j] })
+	if !reflect.DeepEqual(ack.acked, []uint64{1, 2, 3, 4, 5}) {
+		t.Errorf("Expected all deliveries acked, got %v", ack.acked)
+	}
+	if len(publisher.published["write-commands"]) != 1 {
+		t.Errorf("Expected 1 retried message, got %d", len(publisher.published["write-commands"]))
+	}
+	if len(publisher.published["write-commands.dead"]) != 2 {
+		t.Errorf("Expected 2 dead-lettered messages, got %d", len(publisher.published["write-commands.dead"]))
+	}
+}
+
+func TestSettle(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name        string
+		err         error
+		retries     int32
+		deadLetter  string
+		wantAck     bool
+		wantRequeue bool
+		wantDrop    bool
+		wantQueue   string
+		wantRetries int32
+	}{
+		{"success", nil, 0, "dead", true, false, false, "", 0},
+		{"not executed", context.Canceled, 2, "dead", false, true, false, "", 0},
+		{"retry", failed, 1, "dead", true, false, false, "write-commands", 2},
+		{"retries exhausted", failed, 3, "dead", true, false, false, "dead", 3},
+		{"rejected", Reject(failed), 0, "dead", true, false, false, "dead", 0},
+		{"rejected without dead letter queue", Reject(failed), 0, "", false, false, true, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack := &recordingAcknowledger{}
+			s, publisher := newTestSettler(3, tt.deadLetter)
+			msg := amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Body: []byte("{}")}
+			if tt.retries > 0 {
+				msg.Headers = amqp.Table{retryCountHeader: tt.retries}
+			}
+
+			s.settle(msg, tt.err)
+
+			if (len(ack.acked) == 1) != tt.wantAck || (len(ack.requeued) == 1) != tt.wantRequeue || (len(ack.rejected) == 1) != tt.wantDrop {
+				t.Errorf("settle() acked %v, requeued %v, dropped %v", ack.acked, ack.requeued, ack.rejected)
+			}
+			if tt.wantQueue == "" {
+				if len(publisher.published) != 0 {
+					t.Errorf("Expected no forwarded message, got %v", publisher.published)
+				}
+				return
+			}
+			forwarded := publisher.published[tt.wantQueue]
+			if len(forwarded) != 1 {
+				t.Fatalf("Expected 1 message forwarded to %s, got %v", tt.wantQueue, publisher.published)
+			}
+			if got := forwarded[0].Headers[retryCountHeader]; got != tt.wantRetries {
+				t.Errorf("Expected retry count %d, got %v", tt.wantRetries, got)
+			}
+			if got := forwarded[0].Headers[errorHeader]; got != tt.err.Error() {
+				t.Errorf("Expected error header %q, got %v", tt.err.Error(), got)
+			}
+		})
+	}
+}
+
+// TestConsume_ContentTypes tests that messages are decoded by their content
+// type and that unknown content types are retried with it
+func TestConsume_ContentTypes(t *testing.T) {
+	ack := &recordingAcknowledger{}
+	msgs := make(chan amqp.Delivery, 10)
+	for i, codec := range []commands.Codec{commands.JSON, commands.MsgPack, commands.Protobuf} {
+		body, err := codec.Marshal(commands.NewCommand(commands.GetItem, codec.ContentType(), ""))
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		msgs <- amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), ContentType: codec.ContentType(), Body: body}
+	}
+	msgs <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 4, ContentType: "application/cbor", Body: []byte{0xa0}}
+	close(msgs)
+
+	var mu sync.Mutex
+	var keys []string
+	handler := func(cmd commands.Command) error {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, cmd.GetKey())
+		return nil
+	}
+	s, publisher := newTestSettler(1, "write-commands.dead")
+	consume(context.Background(), msgs, handler, s)
+
+	sort.Strings(keys)
+	want := []string{commands.ContentTypeJSON, commands.ContentTypeMsgPack, commands.ContentTypeProtobuf}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected handled keys %v, got %v", want, keys)
+	}
+	retried := publisher.published["write-commands"]
+	if len(retried) != 1 || retried[0].ContentType != "application/cbor" {
+		t.Errorf("Expected unknown content type retried with it, got %v", retried)
+	}
+}
+
+// TestConsume_Requests tests that requests are handed to the handler with a
+// reply address and that failed requests get the error as their result
+func TestConsume_Requests(t *testing.T) {
+	ack := &recordingAcknowledger{}
+	msgs := make(chan amqp.Delivery, 10)
+	for i, key := range []string{"ok", "bad", "plain"} {
+		body, _ := commands.NewCommand(commands.GetItem, key, "").ToJSON()
+		msg := amqp.Delivery{Acknowledger: ack, DeliveryTag: uint64(i + 1), Body: body}
+		if key != "plain" {
+			msg.ReplyTo, msg.CorrelationId = "reply-"+key, key
+		}
+		msgs <- msg
+	}
+	msgs <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 4, Body: []byte("not json"), ReplyTo: "reply-malformed"}
+	close(msgs)
+
+	handler := func(cmd commands.Command) error {
+		request, isRequest := cmd.(Request)
+		switch {
+		case cmd.GetKey() == "plain" && isRequest:
+			return errors.New("plain message delivered as a request")
+		case cmd.GetKey() == "bad":
+			return Reject(errors.New("invalid"))
+		case isRequest:
+			return request.Reply(commands.Result{Output: "ok"})
+		}
+		return nil
+	}
+	s, publisher := newTestSettler(0, "")
+	consume(context.Background(), msgs, handler, s)
+
+	results := map[string]commands.Result{}
+	for queueName, published := range publisher.published {
+		for _, msg := range published {
+			var result commands.Result
+			if err := json.Unmarshal(msg.Body, &result); err != nil {
+				t.Fatalf("Reply to %s is not a result: %v", queueName, err)
+			}
+			if msg.CorrelationId != strings.TrimPrefix(queueName, "reply-") && queueName != "reply-malformed" {
+				t.Errorf("Reply to %s has correlation id %q", queueName, msg.CorrelationId)
+			}
+			results[queueName] = result
+		}
+	}
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 replies, got %v", results)
+	}
+	if results["reply-ok"].Output != "ok" || results["reply-bad"].Error != "invalid" || results["reply-malformed"].Code != commands.CodeMalformed {
+		t.Errorf("Unexpected replies %+v", results)
+	}
+}
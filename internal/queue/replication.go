@@ -0,0 +1,216 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"eoracle-client-server/internal/storage"
+
+	"github.com/streadway/amqp"
+)
+
+// snapshotTimeout bounds how long a replica waits for a snapshot reply
+const snapshotTimeout = 30 * time.Second
+
+// snapshotQueueName returns the queue the primary serves snapshots on
+func snapshotQueueName(exchangeName string) string {
+	return exchangeName + ".snapshots"
+}
+
+// ServeSnapshots answers snapshot requests of replicas with the output of
+// snapshot until the context is done
+func (r *RabbitMQExchange) ServeSnapshots(ctx context.Context, snapshot func() ([]byte, error)) error {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclare(
+		snapshotQueueName(r.exchange), // name
+		false,                         // durable
+		false,                         // delete when unused
+		false,                         // exclusive
+		false,                         // no-wait
+		nil,                           // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare snapshot queue: %w", err)
+	}
+
+	msgs, err := ch.Consume(q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping snapshot serving")
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("snapshot queue closed by broker")
+			}
+			body, err := snapshot()
+			if err != nil {
+				log.Printf("Failed to take snapshot: %v", err)
+				msg.Nack(false, true) // Requeue the request
+				continue
+			}
+			err = ch.Publish("", msg.ReplyTo, false, false, amqp.Publishing{
+				ContentType:   "application/json",
+				CorrelationId: msg.CorrelationId,
+				Body:          body,
+			})
+			if err != nil {
+				log.Printf("Failed to send snapshot: %v", err)
+			}
+			msg.Ack(false)
+		}
+	}
+}
+
+// RequestSnapshot asks the server publishing to the exchange for a snapshot
+// of its namespaces and waits for the reply
+func (r *RabbitMQExchange) RequestSnapshot(ctx context.Context) ([]byte, error) {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	replies, err := ch.Consume(
+		"amq.rabbitmq.reply-to", // direct reply-to pseudo queue
+		"",                      // consumer
+		true,                    // auto-ack
+		false,                   // exclusive
+		false,                   // no-local
+		false,                   // no-wait
+		nil,                     // args
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume snapshot replies: %w", err)
+	}
+
+	correlationID := strconv.FormatInt(time.Now().UnixNano(), 10)
+	err = ch.Publish("", snapshotQueueName(r.exchange), false, false, amqp.Publishing{
+		CorrelationId: correlationID,
+		ReplyTo:       "amq.rabbitmq.reply-to",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to request snapshot: %w", err)
+	}
+
+	timeout := time.NewTimer(snapshotTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, fmt.Errorf("timed out waiting for snapshot")
+		case msg, ok := <-replies:
+			if !ok {
+				return nil, fmt.Errorf("snapshot reply channel closed")
+			}
+			if msg.CorrelationId == correlationID {
+				return msg.Body, nil
+			}
+		}
+	}
+}
+
+// RabbitMQReplicaSource reads the write log of a primary from a durable
+// per-replica queue bound to the change exchange. The queue keeps at most
+// maxLag events; older events are dropped by the broker, which the replica
+// detects as a version gap and answers with a snapshot reload.
+type RabbitMQReplicaSource struct {
+	exchange  *RabbitMQExchange
+	queueName string
+}
+
+// NewRabbitMQReplicaSource declares and binds the replica queue right away,
+// so no events are lost between the first snapshot and consuming
+func NewRabbitMQReplicaSource(exchange *RabbitMQExchange, replicaID string, maxLag int) (*RabbitMQReplicaSource, error) {
+	queueName := exchange.exchange + ".replica." + replicaID
+	_, err := exchange.channel.QueueDeclare(
+		queueName, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		amqp.Table{
+			"x-max-length": int32(maxLag),
+			"x-overflow":   "drop-head",
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to declare replica queue: %w", err)
+	}
+
+	if err := exchange.channel.QueueBind(queueName, "", exchange.exchange, false, nil); err != nil {
+		return nil, fmt.Errorf("failed to bind replica queue: %w", err)
+	}
+
+	return &RabbitMQReplicaSource{
+		exchange:  exchange,
+		queueName: queueName,
+	}, nil
+}
+
+// RequestSnapshot asks the primary for a snapshot and waits for the reply
+func (s *RabbitMQReplicaSource) RequestSnapshot(ctx context.Context) ([]byte, error) {
+	return s.exchange.RequestSnapshot(ctx)
+}
+
+// ConsumeChanges passes events to the handler in queue order. An event is
+// acked once handled; on a handler error it is requeued and the error is
+// returned, so the caller can resync and consume again.
+func (s *RabbitMQReplicaSource) ConsumeChanges(ctx context.Context, handler func(storage.ChangeEvent) error) error {
+	ch, err := s.exchange.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	msgs, err := ch.Consume(
+		s.queueName, // queue
+		"",          // consumer
+		false,       // auto-ack
+		true,        // exclusive, events must be applied by a single consumer
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping replication")
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("replica queue closed by broker")
+			}
+			var event storage.ChangeEvent
+			if err := json.Unmarshal(msg.Body, &event); err != nil {
+				log.Printf("Failed to parse change event: %v", err)
+				msg.Nack(false, false) // Do not requeue the message
+				continue
+			}
+			if err := handler(event); err != nil {
+				msg.Nack(false, true) // Requeue the message
+				return err
+			}
+			msg.Ack(false)
+		}
+	}
+}
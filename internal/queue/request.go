@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"eoracle-client-server/internal/commands"
+
+	"github.com/streadway/amqp"
+)
+
+// directReplyTo is the RabbitMQ pseudo queue delivering replies straight to
+// the consumer on the publishing channel
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// ErrNoRequests is returned by queues that cannot wait for results
+var ErrNoRequests = errors.New("queue does not support requests")
+
+// Requester is implemented by queues that can publish a command and wait
+// for its result. Failed commands are reported in the result; the error is
+// for commands whose result did not arrive.
+type Requester interface {
+	Request(ctx context.Context, cmd commands.Command) (commands.Result, error)
+}
+
+// Request is a delivered command whose publisher waits for its result. The
+// handler replies once the command succeeded; when it fails for good, the
+// queue replies with the error.
+type Request interface {
+	commands.Command
+	Reply(result commands.Result) error
+}
+
+// request is a command delivered with a reply address
+type request struct {
+	commands.Command
+	reply func(result commands.Result) error
+}
+
+func (r request) Reply(result commands.Result) error {
+	return r.reply(result)
+}
+
+// replyResult publishes the result of a request to its reply address
+func (s settler) replyResult(msg amqp.Delivery, result commands.Result) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to serialize result: %w", err)
+	}
+	err = s.publish(msg.ReplyTo, amqp.Publishing{
+		ContentType:   commands.ContentTypeJSON,
+		CorrelationId: msg.CorrelationId,
+		Body:          body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send result: %w", err)
+	}
+	return nil
+}
+
+// replyError tells the publisher of a request that its command failed for
+// good. Messages without a reply address are skipped.
+func (s settler) replyError(msg amqp.Delivery, err error) {
+	if msg.ReplyTo == "" {
+		return
+	}
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		err = rejectErr.Err
+	}
+	if replyErr := s.replyResult(msg, commands.NewResult("", nil, err)); replyErr != nil {
+		log.Printf("Failed to reply to %s: %v", msg.ReplyTo, replyErr)
+	}
+}
+
+// newCorrelationID returns a random id matching a reply to its request
+func newCorrelationID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate correlation id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
@@ -0,0 +1,105 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/streadway/amqp"
+)
+
+// Headers of retried and dead-lettered messages
+const (
+	retryCountHeader    = "x-retry-count"
+	errorHeader         = "x-error"
+	originalQueueHeader = "x-original-queue"
+)
+
+// settler acks, retries or dead-letters handled deliveries of a queue.
+// Retries are republished to the back of the queue with an incremented
+// retry count, since a broker requeue does not count redeliveries.
+type settler struct {
+	queue      string
+	maxRetries int
+	deadLetter string // empty to drop failed messages
+	publish    func(routingKey string, msg amqp.Publishing) error
+}
+
+// settle decides the fate of a delivery from the handler result:
+//   - nil: acked
+//   - context canceled: requeued as is, the command was not executed
+//   - RejectError: dead-lettered right away
+//   - other errors: retried until maxRetries, then dead-lettered
+//
+// Requests that are dead-lettered or dropped get the error as their reply.
+func (s settler) settle(msg amqp.Delivery, err error) {
+	switch {
+	case err == nil:
+		msg.Ack(false)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		msg.Nack(false, true) // Requeue the message
+	case isRejected(err):
+		log.Printf("Rejected command: %v", err)
+		s.replyError(msg, err)
+		s.deadLetterOrDrop(msg, err)
+	case retryCount(msg) < s.maxRetries:
+		log.Printf("Retrying command: %v", err)
+		s.forward(msg, s.queue, err, retryCount(msg)+1)
+	default:
+		log.Printf("Command failed after %d retries: %v", s.maxRetries, err)
+		s.replyError(msg, err)
+		s.deadLetterOrDrop(msg, err)
+	}
+}
+
+// deadLetterOrDrop moves the message to the dead letter queue, if any
+func (s settler) deadLetterOrDrop(msg amqp.Delivery, err error) {
+	if s.deadLetter == "" {
+		msg.Nack(false, false) // Do not requeue the message
+		return
+	}
+	s.forward(msg, s.deadLetter, err, retryCount(msg))
+}
+
+// forward publishes a copy of the message with retry headers and acks the
+// original. If publishing fails the original is requeued instead, so the
+// message is never lost.
+func (s settler) forward(msg amqp.Delivery, routingKey string, err error, retries int) {
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int32(retries)
+	headers[errorHeader] = err.Error()
+	headers[originalQueueHeader] = s.queue
+
+	publishErr := s.publish(routingKey, amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		ReplyTo:         msg.ReplyTo,
+		CorrelationId:   msg.CorrelationId,
+		Body:            msg.Body,
+	})
+	if publishErr != nil {
+		log.Printf("Failed to forward message to %s: %v", routingKey, publishErr)
+		msg.Nack(false, true) // Requeue the message
+		return
+	}
+	msg.Ack(false)
+}
+
+// retryCount returns how often the message was retried
+func retryCount(msg amqp.Delivery) int {
+	switch count := msg.Headers[retryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	default:
+		return 0
+	}
+}
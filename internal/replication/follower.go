@@ -0,0 +1,61 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"eoracle-client-server/internal/storage"
+)
+
+// resyncRetryDelay is the pause between failed snapshot requests
+const resyncRetryDelay = 2 * time.Second
+
+// Source delivers the write log and snapshots of a primary
+type Source interface {
+	RequestSnapshot(ctx context.Context) ([]byte, error)
+	ConsumeChanges(ctx context.Context, handler func(storage.ChangeEvent) error) error
+}
+
+// Follow keeps the replica in sync with the source until the context is done.
+// The replica is loaded from a snapshot first and reloaded whenever it falls
+// behind so far that events are missing.
+func Follow(ctx context.Context, source Source, replica *Replica) error {
+	for {
+		if err := resync(ctx, source, replica); err != nil {
+			return err
+		}
+
+		err := source.ConsumeChanges(ctx, replica.Apply)
+		if !errors.Is(err, ErrResyncRequired) {
+			return err
+		}
+		log.Printf("Replica fell behind, reloading from snapshot")
+	}
+}
+
+// resync loads a snapshot, retrying until it succeeds or the context is done
+func resync(ctx context.Context, source Source, replica *Replica) error {
+	for {
+		data, err := source.RequestSnapshot(ctx)
+		if err == nil {
+			var snapshot Snapshot
+			if err = json.Unmarshal(data, &snapshot); err == nil {
+				replica.Load(snapshot)
+				log.Printf("Loaded snapshot at version %d", snapshot.Version)
+				return nil
+			}
+			err = fmt.Errorf("failed to parse snapshot: %w", err)
+		}
+		log.Printf("Failed to load snapshot: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(resyncRetryDelay):
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package replication
+
+import (
+	"errors"
+	"sync"
+
+	"eoracle-client-server/internal/storage"
+)
+
+// ErrResyncRequired is returned when a replica cannot apply an event and has
+// to be reloaded from a snapshot first
+var ErrResyncRequired = errors.New("replica requires a snapshot")
+
+// Snapshot is a copy of all namespaces of a primary. It is fuzzy: writes may
+// be applied while it is taken, so a replica loading it replays every event
+// after Version, relying on events being state based.
+type Snapshot struct {
+	Epoch      int64                         `json:"epoch"`
+	Version    uint64                        `json:"version"`
+	Namespaces map[string][]storage.KeyValue `json:"namespaces"`
+}
+
+// TakeSnapshot copies all namespaces. The version is read before the data,
+// so every write missing from the snapshot has a later version.
+func TakeSnapshot(feed *storage.ChangeFeed, spaces storage.Namespaces) Snapshot {
+	snapshot := Snapshot{
+		Epoch:      feed.Epoch(),
+		Version:    feed.Version(),
+		Namespaces: make(map[string][]storage.KeyValue),
+	}
+	for _, name := range spaces.Names() {
+		if store, exists := spaces.Lookup(name); exists {
+			snapshot.Namespaces[name] = store.GetAll()
+		}
+	}
+	return snapshot
+}
+
+// Replica applies the change events of a primary to local namespaces in
+// version order
+type Replica struct {
+	mu      sync.Mutex
+	spaces  storage.Namespaces
+	loaded  bool
+	epoch   int64
+	version uint64
+}
+
+// NewReplica creates a replica that needs a snapshot before applying events
+func NewReplica(spaces storage.Namespaces) *Replica {
+	return &Replica{
+		spaces: spaces,
+	}
+}
+
+// Load replaces all local namespaces with the snapshot
+func (r *Replica) Load(snapshot Snapshot) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range r.spaces.Names() {
+		r.spaces.Drop(name)
+	}
+	for name, items := range snapshot.Namespaces {
+		store := r.spaces.Namespace(name)
+		for _, item := range items {
+			store.Add(item.Key, item.Value)
+		}
+	}
+
+	r.loaded = true
+	r.epoch = snapshot.Epoch
+	r.version = snapshot.Version
+}
+
+// Apply applies the next event. Events already covered by the loaded state
+// are skipped; a gap in versions or a new primary epoch requires a resync.
+func (r *Replica) Apply(event storage.ChangeEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.loaded || event.Epoch > r.epoch {
+		return ErrResyncRequired
+	}
+	if event.Epoch < r.epoch || event.Version <= r.version {
+		return nil
+	}
+	if event.Version > r.version+1 {
+		return ErrResyncRequired
+	}
+
+	r.apply(event)
+	r.version = event.Version
+	return nil
+}
+
+// Position returns the epoch and version of the last applied event
+func (r *Replica) Position() (int64, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.epoch, r.version
+}
+
+// apply performs the mutation described by the event
+func (r *Replica) apply(event storage.ChangeEvent) {
+	if event.Op == storage.OpDrop {
+		r.spaces.Drop(event.Namespace)
+		return
+	}
+
+	store := r.spaces.Namespace(event.Namespace)
+	switch event.Op {
+	case storage.OpAdd, storage.OpUpdate:
+		store.Add(event.Key, event.NewValue)
+	case storage.OpDelete, storage.OpEvict:
+		store.Delete(event.Key)
+	case storage.OpMove:
+		switch event.Position {
+		case storage.PositionFront:
+			store.MoveToFront(event.Key)
+		case storage.PositionBack:
+			store.MoveToBack(event.Key)
+		case storage.PositionBefore, storage.PositionAfter:
+			value, exists := store.Get(event.Key)
+			if !exists {
+				return
+			}
+			if event.Position == storage.PositionBefore {
+				store.InsertBefore(event.Mark, event.Key, value)
+			} else {
+				store.InsertAfter(event.Mark, event.Key, value)
+			}
+		}
+	}
+}
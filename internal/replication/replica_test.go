@@ -0,0 +1,224 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"eoracle-client-server/internal/storage"
+)
+
+// primary is an in-process primary whose events are recorded for replicas
+type primary struct {
+	feed   *storage.ChangeFeed
+	spaces storage.Namespaces
+	events <-chan storage.ChangeEvent
+	cancel func()
+}
+
+func newPrimary() *primary {
+	feed := storage.NewChangeFeed()
+	events, cancel := feed.Subscribe(1000)
+	return &primary{
+		feed:   feed,
+		spaces: storage.NewNamespaces(storage.Limits{MaxEntries: 3}, feed),
+		events: events,
+		cancel: cancel,
+	}
+}
+
+// drain returns all events emitted so far
+func (p *primary) drain() []storage.ChangeEvent {
+	var events []storage.ChangeEvent
+	for {
+		select {
+		case event := <-p.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// dump returns the content of all namespaces in order
+func dump(spaces storage.Namespaces) map[string][]storage.KeyValue {
+	result := make(map[string][]storage.KeyValue)
+	for _, name := range spaces.Names() {
+		store, _ := spaces.Lookup(name)
+		result[name] = store.GetAll()
+	}
+	return result
+}
+
+// writeWorkload applies writes covering every event type
+func writeWorkload(spaces storage.Namespaces) {
+	a := spaces.Namespace("a")
+	a.Add("key1", "value1")
+	a.Add("key2", "value2")
+	a.Add("key3", "value3")
+	a.Add("key1", "updated")
+	a.Add("key4", "value4") // evicts key1
+	a.MoveToFront("key4")
+	a.InsertAfter("key4", "key5", "value5") // evicts key2
+	a.InsertBefore("key4", "key3", "moved")
+	a.Delete("key5")
+	a.PopLast()
+
+	b := spaces.Namespace("b")
+	b.Add("key1", "value1")
+	spaces.Drop("b")
+	spaces.Namespace("c").Add("key1", "value1")
+}
+
+func TestReplicaAppliesWriteLog(t *testing.T) {
+	p := newPrimary()
+	defer p.cancel()
+
+	replica := NewReplica(storage.NewNamespaces(storage.Limits{}, nil))
+	replica.Load(TakeSnapshot(p.feed, p.spaces))
+
+	writeWorkload(p.spaces)
+	for _, event := range p.drain() {
+		if err := replica.Apply(event); err != nil {
+			t.Fatalf("Apply(%+v) error = %v", event, err)
+		}
+	}
+
+	if got, want := dump(replica.spaces), dump(p.spaces); !reflect.DeepEqual(got, want) {
+		t.Errorf("Replica state = %v, want %v", got, want)
+	}
+	if _, version := replica.Position(); version != p.feed.Version() {
+		t.Errorf("Replica version = %d, want %d", version, p.feed.Version())
+	}
+}
+
+func TestReplicaRequiresResync(t *testing.T) {
+	p := newPrimary()
+	defer p.cancel()
+
+	replica := NewReplica(storage.NewNamespaces(storage.Limits{}, nil))
+
+	p.spaces.Namespace("").Add("key1", "value1")
+	p.spaces.Namespace("").Add("key2", "value2")
+	p.spaces.Namespace("").Add("key3", "value3")
+	events := p.drain()
+
+	// Nothing can be applied before a snapshot is loaded
+	if err := replica.Apply(events[0]); !errors.Is(err, ErrResyncRequired) {
+		t.Errorf("Apply() before Load error = %v, want ErrResyncRequired", err)
+	}
+
+	replica.Load(Snapshot{Epoch: p.feed.Epoch()})
+
+	// Duplicates are skipped, gaps require a resync
+	if err := replica.Apply(events[0]); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if err := replica.Apply(events[0]); err != nil {
+		t.Errorf("Apply() of duplicate error = %v", err)
+	}
+	if err := replica.Apply(events[2]); !errors.Is(err, ErrResyncRequired) {
+		t.Errorf("Apply() after gap error = %v, want ErrResyncRequired", err)
+	}
+
+	// Events of a restarted primary require a resync, stale ones are skipped
+	newer := events[1]
+	newer.Epoch++
+	if err := replica.Apply(newer); !errors.Is(err, ErrResyncRequired) {
+		t.Errorf("Apply() of new epoch error = %v, want ErrResyncRequired", err)
+	}
+	older := events[1]
+	older.Epoch--
+	if err := replica.Apply(older); err != nil {
+		t.Errorf("Apply() of old epoch error = %v", err)
+	}
+}
+
+func TestReplicaFuzzySnapshot(t *testing.T) {
+	p := newPrimary()
+	defer p.cancel()
+
+	p.spaces.Namespace("").Add("key1", "value1")
+	snapshot := TakeSnapshot(p.feed, p.spaces)
+
+	// Writes racing with the snapshot are part of it and replayed again
+	p.spaces.Namespace("").Add("key2", "value2")
+	snapshot.Namespaces[storage.DefaultNamespace] = p.spaces.Namespace("").GetAll()
+	p.spaces.Namespace("").Add("key2", "updated")
+
+	replica := NewReplica(storage.NewNamespaces(storage.Limits{}, nil))
+	replica.Load(snapshot)
+	for _, event := range p.drain() {
+		if err := replica.Apply(event); err != nil {
+			t.Fatalf("Apply(%+v) error = %v", event, err)
+		}
+	}
+
+	if got, want := dump(replica.spaces), dump(p.spaces); !reflect.DeepEqual(got, want) {
+		t.Errorf("Replica state = %v, want %v", got, want)
+	}
+}
+
+// fakeSource replays recorded snapshots and event batches
+type fakeSource struct {
+	snapshots []Snapshot
+	batches   [][]storage.ChangeEvent
+	requests  int
+	cancel    func()
+}
+
+func (f *fakeSource) RequestSnapshot(ctx context.Context) ([]byte, error) {
+	snapshot := f.snapshots[f.requests]
+	f.requests++
+	return json.Marshal(snapshot)
+}
+
+func (f *fakeSource) ConsumeChanges(ctx context.Context, handler func(storage.ChangeEvent) error) error {
+	for len(f.batches) > 0 {
+		batch := f.batches[0]
+		f.batches = f.batches[1:]
+		for _, event := range batch {
+			if err := handler(event); err != nil {
+				return err
+			}
+		}
+	}
+	// Like the broker source, only return without error once stopped
+	f.cancel()
+	return nil
+}
+
+func TestFollowReloadsWhenLagging(t *testing.T) {
+	p := newPrimary()
+	defer p.cancel()
+
+	initial := TakeSnapshot(p.feed, p.spaces)
+	p.spaces.Namespace("").Add("key1", "value1")
+	first := p.drain()
+	p.spaces.Namespace("").Add("key2", "value2")
+	p.spaces.Namespace("").Add("key3", "value3")
+	lost := p.drain()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := &fakeSource{
+		snapshots: []Snapshot{initial, TakeSnapshot(p.feed, p.spaces)},
+		// The broker dropped the first event of the second batch
+		batches: [][]storage.ChangeEvent{first, lost[1:]},
+		cancel:  cancel,
+	}
+
+	replica := NewReplica(storage.NewNamespaces(storage.Limits{}, nil))
+	if err := Follow(ctx, source, replica); err != nil {
+		t.Fatalf("Follow() error = %v", err)
+	}
+
+	if source.requests != 2 {
+		t.Errorf("Expected 2 snapshot requests, got %d", source.requests)
+	}
+	if got, want := dump(replica.spaces), dump(p.spaces); !reflect.DeepEqual(got, want) {
+		t.Errorf("Replica state = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,347 @@
+// gRPC front-end of the ordered map, implemented by Service in service.go.
+// The Go bindings in rpcpb are generated with "make proto". Field numbers
+// must never be reused.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: internal/rpc/service.proto
+
+package rpcpb
+
+import (
+	context "context"
+	commandpb "eoracle-client-server/internal/commands/commandpb"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	OrderedMap_Execute_FullMethodName = "/eoracle.rpc.v1.OrderedMap/Execute"
+	OrderedMap_Add_FullMethodName     = "/eoracle.rpc.v1.OrderedMap/Add"
+	OrderedMap_Get_FullMethodName     = "/eoracle.rpc.v1.OrderedMap/Get"
+	OrderedMap_Delete_FullMethodName  = "/eoracle.rpc.v1.OrderedMap/Delete"
+	OrderedMap_GetAll_FullMethodName  = "/eoracle.rpc.v1.OrderedMap/GetAll"
+	OrderedMap_Watch_FullMethodName   = "/eoracle.rpc.v1.OrderedMap/Watch"
+)
+
+// OrderedMapClient is the client API for OrderedMap service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type OrderedMapClient interface {
+	// Execute runs any registry command, e.g. type "insertAfter" with key,
+	// value and the mark in extra. Signatures in metadata are verified like
+	// those of queued commands.
+	Execute(ctx context.Context, in *commandpb.Envelope, opts ...grpc.CallOption) (*Result, error)
+	// Add, Get and Delete run the addItem, getItem and deleteItem commands
+	// of the request. Servers that authenticate commands need the signature
+	// of that command in auth.
+	Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*Result, error)
+	Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Result, error)
+	Delete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Result, error)
+	// GetAll streams the pairs of a namespace in order, read in pages. The
+	// pages are unsigned commands, so servers that authenticate commands
+	// refuse it; page with signed getAllItems commands through Execute.
+	GetAll(ctx context.Context, in *GetAllRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Item], error)
+	// Watch streams the changes of matching keys until the call is canceled.
+	// Servers that authenticate commands need the signature of a "watch"
+	// command with the namespace and the pattern as key in auth.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChangeEvent], error)
+}
+
+type orderedMapClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewOrderedMapClient(cc grpc.ClientConnInterface) OrderedMapClient {
+	return &orderedMapClient{cc}
+}
+
+func (c *orderedMapClient) Execute(ctx context.Context, in *commandpb.Envelope, opts ...grpc.CallOption) (*Result, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Result)
+	err := c.cc.Invoke(ctx, OrderedMap_Execute_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderedMapClient) Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*Result, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Result)
+	err := c.cc.Invoke(ctx, OrderedMap_Add_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderedMapClient) Get(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Result, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Result)
+	err := c.cc.Invoke(ctx, OrderedMap_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderedMapClient) Delete(ctx context.Context, in *KeyRequest, opts ...grpc.CallOption) (*Result, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Result)
+	err := c.cc.Invoke(ctx, OrderedMap_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderedMapClient) GetAll(ctx context.Context, in *GetAllRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Item], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrderedMap_ServiceDesc.Streams[0], OrderedMap_GetAll_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[GetAllRequest, Item]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderedMap_GetAllClient = grpc.ServerStreamingClient[Item]
+
+func (c *orderedMapClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChangeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &OrderedMap_ServiceDesc.Streams[1], OrderedMap_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, ChangeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderedMap_WatchClient = grpc.ServerStreamingClient[ChangeEvent]
+
+// OrderedMapServer is the server API for OrderedMap service.
+// All implementations must embed UnimplementedOrderedMapServer
+// for forward compatibility.
+type OrderedMapServer interface {
+	// Execute runs any registry command, e.g. type "insertAfter" with key,
+	// value and the mark in extra. Signatures in metadata are verified like
+	// those of queued commands.
+	Execute(context.Context, *commandpb.Envelope) (*Result, error)
+	// Add, Get and Delete run the addItem, getItem and deleteItem commands
+	// of the request. Servers that authenticate commands need the signature
+	// of that command in auth.
+	Add(context.Context, *AddRequest) (*Result, error)
+	Get(context.Context, *KeyRequest) (*Result, error)
+	Delete(context.Context, *KeyRequest) (*Result, error)
+	// GetAll streams the pairs of a namespace in order, read in pages. The
+	// pages are unsigned commands, so servers that authenticate commands
+	// refuse it; page with signed getAllItems commands through Execute.
+	GetAll(*GetAllRequest, grpc.ServerStreamingServer[Item]) error
+	// Watch streams the changes of matching keys until the call is canceled.
+	// Servers that authenticate commands need the signature of a "watch"
+	// command with the namespace and the pattern as key in auth.
+	Watch(*WatchRequest, grpc.ServerStreamingServer[ChangeEvent]) error
+	mustEmbedUnimplementedOrderedMapServer()
+}
+
+// UnimplementedOrderedMapServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedOrderedMapServer struct{}
+
+func (UnimplementedOrderedMapServer) Execute(context.Context, *commandpb.Envelope) (*Result, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Execute not implemented")
+}
+func (UnimplementedOrderedMapServer) Add(context.Context, *AddRequest) (*Result, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedOrderedMapServer) Get(context.Context, *KeyRequest) (*Result, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedOrderedMapServer) Delete(context.Context, *KeyRequest) (*Result, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedOrderedMapServer) GetAll(*GetAllRequest, grpc.ServerStreamingServer[Item]) error {
+	return status.Errorf(codes.Unimplemented, "method GetAll not implemented")
+}
+func (UnimplementedOrderedMapServer) Watch(*WatchRequest, grpc.ServerStreamingServer[ChangeEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedOrderedMapServer) mustEmbedUnimplementedOrderedMapServer() {}
+func (UnimplementedOrderedMapServer) testEmbeddedByValue()                    {}
+
+// UnsafeOrderedMapServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to OrderedMapServer will
+// result in compilation errors.
+type UnsafeOrderedMapServer interface {
+	mustEmbedUnimplementedOrderedMapServer()
+}
+
+func RegisterOrderedMapServer(s grpc.ServiceRegistrar, srv OrderedMapServer) {
+	// If the following call pancis, it indicates UnimplementedOrderedMapServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&OrderedMap_ServiceDesc, srv)
+}
+
+func _OrderedMap_Execute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(commandpb.Envelope)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderedMapServer).Execute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderedMap_Execute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderedMapServer).Execute(ctx, req.(*commandpb.Envelope))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderedMap_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderedMapServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderedMap_Add_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderedMapServer).Add(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderedMap_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderedMapServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderedMap_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderedMapServer).Get(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderedMap_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(OrderedMapServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: OrderedMap_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(OrderedMapServer).Delete(ctx, req.(*KeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _OrderedMap_GetAll_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetAllRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderedMapServer).GetAll(m, &grpc.GenericServerStream[GetAllRequest, Item]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderedMap_GetAllServer = grpc.ServerStreamingServer[Item]
+
+func _OrderedMap_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrderedMapServer).Watch(m, &grpc.GenericServerStream[WatchRequest, ChangeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type OrderedMap_WatchServer = grpc.ServerStreamingServer[ChangeEvent]
+
+// OrderedMap_ServiceDesc is the grpc.ServiceDesc for OrderedMap service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var OrderedMap_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "eoracle.rpc.v1.OrderedMap",
+	HandlerType: (*OrderedMapServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Execute",
+			Handler:    _OrderedMap_Execute_Handler,
+		},
+		{
+			MethodName: "Add",
+			Handler:    _OrderedMap_Add_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _OrderedMap_Get_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _OrderedMap_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetAll",
+			Handler:       _OrderedMap_GetAll_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _OrderedMap_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "internal/rpc/service.proto",
+}
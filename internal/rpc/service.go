@@ -0,0 +1,329 @@
+// Package rpc serves the OrderedMap gRPC service of service.proto. Commands
+// are executed by the server like requests taken from its queues, so they
+// are authenticated, validated, audited and authorized the same way. Watches
+// read the change feed of the server; they are signed like a command and
+// only see the keys the access policy lets the client read.
+//
+// The bindings in rpcpb are generated from service.proto with "make proto".
+package rpc
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strconv"
+	"sync"
+
+	"eoracle-client-server/internal/auth"
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/commands/commandpb"
+	"eoracle-client-server/internal/rpc/rpcpb"
+	"eoracle-client-server/internal/server"
+	"eoracle-client-server/internal/storage"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// watchBuffer is the number of changes of the feed a watcher may fall
+	// behind
+	watchBuffer = 1024
+	// getAllPageSize is the number of pairs GetAll reads per command
+	getAllPageSize = 1000
+)
+
+// WatchCommand is the type of the command signed for a watch. It is not a
+// registry command; the policy allows it by this type or the READ category.
+const WatchCommand commands.CommandType = "watch"
+
+var (
+	// ErrNoChangeFeed is returned by Watch on a server without change feed
+	ErrNoChangeFeed = errors.New("changes are not recorded by this server")
+	// ErrWatchOverflow ends a watch that fell too far behind, the client
+	// has to read the keys again and start a new watch
+	ErrWatchOverflow = errors.New("watcher fell behind, changes were dropped")
+	// ErrClosed ends the watches of a service that is shutting down
+	ErrClosed = errors.New("server is shutting down")
+	// ErrUnsignedPages is returned by GetAll on servers that authenticate
+	// commands, since one signature cannot cover several pages
+	ErrUnsignedPages = errors.New("getall pages are unsigned, run signed getAllItems commands with Execute")
+)
+
+// Executor runs commands, implemented by server.Server
+type Executor interface {
+	Execute(ctx context.Context, cmd commands.Command) (commands.Result, error)
+}
+
+// Config configures the authentication of the requests the service does
+// not hand to the executor. It must match the server configuration.
+type Config struct {
+	// Verifier authenticates watches, nil to accept unsigned ones
+	Verifier *auth.Verifier
+	// Policy filters the changes sent to watchers, nil to send all
+	Policy *auth.Policy
+}
+
+// Service implements the OrderedMap service
+type Service struct {
+	rpcpb.UnimplementedOrderedMapServer
+
+	executor Executor
+	feed     *storage.ChangeFeed
+	config   Config
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewService creates a service running commands on the executor. feed is
+// the change feed of the server namespaces, nil if the server has none.
+func NewService(executor Executor, feed *storage.ChangeFeed, config Config) *Service {
+	return &Service{
+		executor: executor,
+		feed:     feed,
+		config:   config,
+		closed:   make(chan struct{}),
+	}
+}
+
+// Close ends the running watches, so a graceful stop of the gRPC server
+// does not wait for them
+func (s *Service) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// Execute decodes the envelope like a queued protobuf command and runs it.
+// Failed commands return their error in the result.
+func (s *Service) Execute(ctx context.Context, envelope *commandpb.Envelope) (*rpcpb.Result, error) {
+	data, err := proto.Marshal(envelope)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	cmd, err := commands.Protobuf.Unmarshal(data)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.execute(ctx, cmd)
+}
+
+// Add sets the value of a key. The auth of the request is the signature of
+// the addItem command with its namespace, key and value.
+func (s *Service) Add(ctx context.Context, req *rpcpb.AddRequest) (*rpcpb.Result, error) {
+	cmd := commands.WithNamespace(commands.NewCommand(commands.AddItem, req.GetKey(), req.GetValue()), req.GetNamespace())
+	return s.execute(ctx, withAuth(cmd, req.GetAuth()))
+}
+
+// Get returns the value of a key, no items if it does not exist
+func (s *Service) Get(ctx context.Context, req *rpcpb.KeyRequest) (*rpcpb.Result, error) {
+	cmd := commands.WithNamespace(commands.NewCommand(commands.GetItem, req.GetKey(), ""), req.GetNamespace())
+	return s.execute(ctx, withAuth(cmd, req.GetAuth()))
+}
+
+// Delete removes a key
+func (s *Service) Delete(ctx context.Context, req *rpcpb.KeyRequest) (*rpcpb.Result, error) {
+	cmd := commands.WithNamespace(commands.NewCommand(commands.DeleteItem, req.GetKey(), ""), req.GetNamespace())
+	return s.execute(ctx, withAuth(cmd, req.GetAuth()))
+}
+
+func (s *Service) execute(ctx context.Context, cmd commands.Command) (*rpcpb.Result, error) {
+	result, err := s.executor.Execute(ctx, cmd)
+	if err != nil {
+		return nil, executeError(err)
+	}
+	return toResult(result), nil
+}
+
+// GetAll sends the pairs of a namespace in order. The pairs are read with
+// getall commands of one page each, so a change between two pages may be
+// sent. A failed page ends the call with the error of its command.
+func (s *Service) GetAll(req *rpcpb.GetAllRequest, stream rpcpb.OrderedMap_GetAllServer) error {
+	if s.config.Verifier != nil {
+		return status.Error(codes.FailedPrecondition, ErrUnsignedPages.Error())
+	}
+	ctx := stream.Context()
+	after := ""
+	for {
+		args := []string{strconv.Itoa(getAllPageSize)}
+		if after != "" {
+			args = append(args, after)
+		}
+		cmd := commands.WithNamespace(commands.NewCommand(commands.GetAllItems, "", "", args...), req.GetNamespace())
+		result, err := s.executor.Execute(ctx, cmd)
+		if err != nil {
+			return executeError(err)
+		}
+		if result.Error != "" {
+			return status.Error(statusCode(result.Code), result.Error)
+		}
+
+		for _, item := range result.Items {
+			if err := stream.Send(&rpcpb.Item{Key: item.Key, Value: item.Value}); err != nil {
+				return err
+			}
+		}
+		if len(result.Items) < getAllPageSize {
+			return nil
+		}
+		after = result.Items[len(result.Items)-1].Key
+	}
+}
+
+// Watch sends the changes of the namespace keys matching the glob pattern
+// until the call is canceled or the service closed. A watcher that falls
+// behind is ended with ErrWatchOverflow rather than blocking writers. The
+// auth of the request is the signature of NewWatch; changes of keys the
+// policy does not let the client watch are skipped.
+func (s *Service) Watch(req *rpcpb.WatchRequest, stream rpcpb.OrderedMap_WatchServer) error {
+	if s.feed == nil {
+		return status.Error(codes.FailedPrecondition, ErrNoChangeFeed.Error())
+	}
+	namespace, pattern := req.GetNamespace(), req.GetPattern()
+	if _, err := path.Match(pattern, ""); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	cmd := withAuth(NewWatch(namespace, pattern), req.GetAuth())
+	if s.config.Verifier != nil {
+		if err := s.config.Verifier.Verify(cmd); err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+	}
+	client := ""
+	if signature := cmd.GetAuth(); signature != nil {
+		client = signature.Client
+	}
+
+	// The feed disconnects a watcher that falls behind by more than the
+	// buffer, closing the events
+	events, cancel := s.feed.Subscribe(watchBuffer)
+	defer cancel()
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.closed:
+			return status.Error(codes.Unavailable, ErrClosed.Error())
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, ErrWatchOverflow.Error())
+			}
+			if !event.Matches(namespace, pattern) || !s.allowed(client, event) {
+				continue
+			}
+			if err := stream.Send(toChangeEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// NewWatch returns the command signed for a watch of the namespace keys
+// matching the pattern
+func NewWatch(namespace, pattern string) commands.Command {
+	return commands.WithNamespace(commands.NewCommand(WatchCommand, pattern, ""), namespace)
+}
+
+// allowed tells whether the policy lets the client watch the changed key.
+// Drops of a namespace have no key, so only rules without key patterns
+// allow them.
+func (s *Service) allowed(client string, event storage.ChangeEvent) bool {
+	if s.config.Policy == nil {
+		return true
+	}
+	scope := commands.CommandScope{Namespace: event.Namespace}
+	if scope.Namespace == "" {
+		scope.Namespace = storage.DefaultNamespace
+	}
+	if event.Key != "" {
+		scope.Keys = []string{event.Key}
+	}
+	return s.config.Policy.Allowed(client, WatchCommand, commands.ReadCategory, scope)
+}
+
+// ToAuth converts the signature of a command for the auth of a request
+func ToAuth(signature *commands.Auth) *commandpb.Auth {
+	if signature == nil {
+		return nil
+	}
+	return &commandpb.Auth{
+		Client: signature.Client,
+		KeyId:  signature.KeyID,
+		Ts:     signature.Timestamp,
+		Nonce:  signature.Nonce,
+		Sig:    signature.Signature,
+	}
+}
+
+// withAuth sets the auth of a request on its command, unless it has none
+func withAuth(cmd commands.Command, signature *commandpb.Auth) commands.Command {
+	if signature == nil {
+		return cmd
+	}
+	return commands.WithAuth(cmd, &commands.Auth{
+		Client:    signature.GetClient(),
+		KeyID:     signature.GetKeyId(),
+		Timestamp: signature.GetTs(),
+		Nonce:     signature.GetNonce(),
+		Signature: signature.GetSig(),
+	})
+}
+
+// executeError maps an error of the executor to a gRPC status
+func executeError(err error) error {
+	switch {
+	case errors.Is(err, server.ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, server.ErrStopped):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// statusCode maps the code of a failed command to a gRPC status code.
+// Commands failing without a code, e.g. unauthenticated ones, are Unknown.
+func statusCode(code commands.ErrorCode) codes.Code {
+	switch code {
+	case "":
+		return codes.Unknown
+	case commands.CodeAccessDenied:
+		return codes.PermissionDenied
+	case commands.CodeInvalidCursor:
+		return codes.Aborted
+	default:
+		return codes.InvalidArgument
+	}
+}
+
+func toResult(result commands.Result) *rpcpb.Result {
+	items := make([]*rpcpb.Item, 0, len(result.Items))
+	for _, item := range result.Items {
+		items = append(items, &rpcpb.Item{Key: item.Key, Value: item.Value})
+	}
+	return &rpcpb.Result{
+		Items:  items,
+		Output: result.Output,
+		Error:  result.Error,
+		Code:   string(result.Code),
+	}
+}
+
+func toChangeEvent(event storage.ChangeEvent) *rpcpb.ChangeEvent {
+	return &rpcpb.ChangeEvent{
+		Op:        string(event.Op),
+		Namespace: event.Namespace,
+		Key:       event.Key,
+		OldValue:  event.OldValue,
+		NewValue:  event.NewValue,
+		Position:  string(event.Position),
+		Mark:      event.Mark,
+		Epoch:     event.Epoch,
+		Version:   event.Version,
+		Timestamp: event.Timestamp.UnixMilli(),
+	}
+}
@@ -0,0 +1,477 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/auth"
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/commands/commandpb"
+	"eoracle-client-server/internal/queue"
+	"eoracle-client-server/internal/rpc/rpcpb"
+	"eoracle-client-server/internal/server"
+	"eoracle-client-server/internal/storage"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+type nopOutput struct{}
+
+func (nopOutput) Write(data string) {}
+func (nopOutput) Close() error      { return nil }
+
+// testServer is a server with a change feed serving the OrderedMap service
+// on an in-memory listener
+type testServer struct {
+	client  rpcpb.OrderedMapClient
+	service *Service
+	spaces  storage.Namespaces
+}
+
+// newTestServer starts a server, read-only without write queue, and the
+// gRPC service in front of it until the test ends
+func newTestServer(t *testing.T, config server.Config, writes bool) *testServer {
+	t.Helper()
+	feed := storage.NewChangeFeed()
+	spaces := storage.NewNamespaces(storage.Limits{}, feed)
+
+	var writeQueue queue.Queue
+	if writes {
+		writeQueue = queue.NewMemoryQueue(0)
+	}
+	config.ReadPool = server.PoolConfig{Size: 2}
+	config.WritePool = server.PoolConfig{Size: 1}
+	srv, err := server.NewServer(queue.NewMemoryQueue(0), writeQueue, config, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(started)
+	}()
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	service := NewService(srv, feed, Config{Verifier: config.Verifier, Policy: config.Policy})
+	rpcpb.RegisterOrderedMapServer(grpcServer, service)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		service.Close()
+		grpcServer.GracefulStop()
+		cancel()
+		<-started
+	})
+	return &testServer{client: rpcpb.NewOrderedMapClient(conn), service: service, spaces: spaces}
+}
+
+// envelope converts a command to its protobuf message
+func envelope(t *testing.T, cmd commands.Command) *commandpb.Envelope {
+	t.Helper()
+	data, err := commands.Protobuf.Marshal(cmd)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var msg commandpb.Envelope
+	if err := proto.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	return &msg
+}
+
+func items(result *rpcpb.Result) []string {
+	var pairs []string
+	for _, item := range result.GetItems() {
+		pairs = append(pairs, item.GetKey()+"="+item.GetValue())
+	}
+	return pairs
+}
+
+func TestService_Execute(t *testing.T) {
+	ts := newTestServer(t, server.Config{Limits: &commands.Limits{MaxKeySize: 8}}, true)
+	ctx := context.Background()
+
+	if result, err := ts.client.Add(ctx, &rpcpb.AddRequest{Key: "a", Value: "1"}); err != nil || result.GetError() != "" {
+		t.Fatalf("Add() = %v, %v", result, err)
+	}
+	if result, err := ts.client.Add(ctx, &rpcpb.AddRequest{Namespace: "prices", Key: "a", Value: "2"}); err != nil || result.GetError() != "" {
+		t.Fatalf("Add() = %v, %v", result, err)
+	}
+
+	result, err := ts.client.Get(ctx, &rpcpb.KeyRequest{Key: "a"})
+	if want := []string{"a=1"}; err != nil || !reflect.DeepEqual(items(result), want) {
+		t.Errorf("Get(a) = %v, %v, want items %v", result, err, want)
+	}
+	mget := commands.WithNamespace(commands.NewCommand(commands.GetMany, "", "", "a", "b"), "prices")
+	result, err = ts.client.Execute(ctx, envelope(t, mget))
+	if want := []string{"a=2"}; err != nil || !reflect.DeepEqual(items(result), want) {
+		t.Errorf("Execute(mget) = %v, %v, want items %v", result, err, want)
+	}
+
+	if result, err := ts.client.Delete(ctx, &rpcpb.KeyRequest{Key: "a"}); err != nil || result.GetError() != "" {
+		t.Fatalf("Delete() = %v, %v", result, err)
+	}
+	if result, err := ts.client.Get(ctx, &rpcpb.KeyRequest{Key: "a"}); err != nil || len(result.GetItems()) != 0 {
+		t.Errorf("Get(a) = %v, %v, want no items", result, err)
+	}
+
+	// Commands are validated with the limits of the server
+	if result, _ := ts.client.Add(ctx, &rpcpb.AddRequest{Key: "over-limit", Value: "1"}); result.GetCode() != string(commands.CodeKeyTooLong) {
+		t.Errorf("Add(over-limit) = %v, want code %s", result, commands.CodeKeyTooLong)
+	}
+	if result, _ := ts.client.Execute(ctx, &commandpb.Envelope{Version: commands.SchemaVersion, Type: "resizePool"}); result.GetError() == "" {
+		t.Errorf("Execute(resizePool) = %v, want error", result)
+	}
+	if _, err := ts.client.Execute(ctx, &commandpb.Envelope{Version: commands.SchemaVersion + 1, Type: string(commands.GetItem)}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Execute(version 2) error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ts.client.Add(canceled, &rpcpb.AddRequest{Key: "a", Value: "1"}); status.Code(err) != codes.Canceled {
+		t.Errorf("Add() error = %v, want %s", err, codes.Canceled)
+	}
+}
+
+func TestService_Auth(t *testing.T) {
+	signing, verification, err := auth.GenerateKey("grafana", "grafana-1", auth.Ed25519)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, _ := auth.NewSigner(signing)
+	dir := t.TempDir()
+	data, _ := json.Marshal([]auth.Key{verification})
+	os.WriteFile(filepath.Join(dir, "keyring.json"), data, 0600)
+	os.WriteFile(filepath.Join(dir, "policy.json"), []byte(`{"clients": {"grafana": {"rules": [
+		{"commands": ["getItem"]},
+		{"commands": ["addItem", "watch"], "keys": ["user:*"]}
+	]}}}`), 0600)
+	keyring, err := auth.LoadKeyring(filepath.Join(dir, "keyring.json"))
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	policy, err := auth.LoadPolicy(filepath.Join(dir, "policy.json"))
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	ts := newTestServer(t, server.Config{Verifier: auth.NewVerifier(keyring, time.Minute), Policy: policy}, true)
+	ts.spaces.Namespace("").Add("key", "value")
+	ctx := context.Background()
+
+	get, _ := signer.Sign(commands.NewCommand(commands.GetItem, "key", ""))
+	result, err := ts.client.Execute(ctx, envelope(t, get))
+	if want := []string{"key=value"}; err != nil || !reflect.DeepEqual(items(result), want) {
+		t.Errorf("Execute(signed get) = %v, %v, want items %v", result, err, want)
+	}
+	if result, err := ts.client.Execute(ctx, envelope(t, get)); err != nil || result.GetError() == "" {
+		t.Errorf("Execute(replayed get) = %v, %v, want error", result, err)
+	}
+	if result, err := ts.client.Get(ctx, &rpcpb.KeyRequest{Key: "key"}); err != nil || result.GetError() == "" {
+		t.Errorf("Get(unsigned) = %v, %v, want error", result, err)
+	}
+	add, _ := signer.Sign(commands.NewCommand(commands.AddItem, "key", "new"))
+	if result, err := ts.client.Execute(ctx, envelope(t, add)); err != nil || result.GetCode() != string(commands.CodeAccessDenied) {
+		t.Errorf("Execute(add) = %v, %v, want code %s", result, err, commands.CodeAccessDenied)
+	}
+	if value, _ := ts.spaces.Namespace("").Get("key"); value != "value" {
+		t.Errorf("Denied add changed the value to %q", value)
+	}
+
+	// Typed requests carry the signature of their command
+	get, _ = signer.Sign(commands.NewCommand(commands.GetItem, "key", ""))
+	result, err = ts.client.Get(ctx, &rpcpb.KeyRequest{Key: "key", Auth: ToAuth(get.GetAuth())})
+	if want := []string{"key=value"}; err != nil || !reflect.DeepEqual(items(result), want) {
+		t.Errorf("Get(signed) = %v, %v, want items %v", result, err, want)
+	}
+	if result, err := ts.client.Get(ctx, &rpcpb.KeyRequest{Key: "other", Auth: ToAuth(get.GetAuth())}); err != nil || result.GetError() == "" {
+		t.Errorf("Get(other key) with the signature of key = %v, %v, want error", result, err)
+	}
+
+	stream, err := ts.client.GetAll(ctx, &rpcpb.GetAllRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("GetAll() error = %v, want %s", err, codes.FailedPrecondition)
+	}
+
+	watches, err := ts.client.Watch(ctx, &rpcpb.WatchRequest{Pattern: "*"})
+	if err == nil {
+		_, err = watches.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Watch(unsigned) error = %v, want %s", err, codes.Unauthenticated)
+	}
+
+	// The watch only sends the changes of keys the client may watch
+	watch, _ := signer.Sign(NewWatch("", "*"))
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watches, err = ts.client.Watch(watchCtx, &rpcpb.WatchRequest{Pattern: "*", Auth: ToAuth(watch.GetAuth())})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	received := make(chan *rpcpb.ChangeEvent, watchBuffer)
+	go func() {
+		for {
+			event, err := watches.Recv()
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- event
+		}
+	}()
+	for ready := false; !ready; {
+		ts.spaces.Namespace("").Add("user:0", "ready")
+		select {
+		case _, ok := <-received:
+			if !ok {
+				t.Fatal("Watch() ended before the first change")
+			}
+			ready = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	ts.spaces.Namespace("").Add("key", "hidden")
+	add, _ = signer.Sign(commands.NewCommand(commands.AddItem, "user:1", "a"))
+	if result, err := ts.client.Add(ctx, &rpcpb.AddRequest{Key: "user:1", Value: "a", Auth: ToAuth(add.GetAuth())}); err != nil || result.GetError() != "" {
+		t.Fatalf("Add(signed) = %v, %v", result, err)
+	}
+	for {
+		select {
+		case event := <-received:
+			if event.GetKey() == "user:0" {
+				continue
+			}
+			if event.GetKey() != "user:1" {
+				t.Errorf("Watch() sent the change of %s, want user:1 only", event.GetKey())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Watch() did not send the change of user:1")
+		}
+		break
+	}
+}
+
+func TestService_ReadOnly(t *testing.T) {
+	ts := newTestServer(t, server.Config{}, false)
+	ts.spaces.Namespace("").Add("key", "value")
+
+	if _, err := ts.client.Add(context.Background(), &rpcpb.AddRequest{Key: "key", Value: "new"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Add() on replica error = %v, want %s", err, codes.FailedPrecondition)
+	}
+	if result, err := ts.client.Get(context.Background(), &rpcpb.KeyRequest{Key: "key"}); err != nil || len(result.GetItems()) != 1 {
+		t.Errorf("Get() on replica = %v, %v, want key", result, err)
+	}
+}
+
+func recvAll[T any](stream interface{ Recv() (T, error) }) ([]T, error) {
+	var msgs []T
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return msgs, nil
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestService_GetAll(t *testing.T) {
+	ts := newTestServer(t, server.Config{}, true)
+	ctx := context.Background()
+
+	// More pairs than one page, in insertion order
+	n := getAllPageSize + getAllPageSize/2
+	var want []string
+	for i := n; i > 0; i-- {
+		key := fmt.Sprintf("key%d", i)
+		ts.spaces.Namespace("prices").Add(key, strconv.Itoa(i))
+		want = append(want, key+"="+strconv.Itoa(i))
+	}
+
+	stream, err := ts.client.GetAll(ctx, &rpcpb.GetAllRequest{Namespace: "prices"})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	sent, err := recvAll[*rpcpb.Item](stream)
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	var got []string
+	for _, item := range sent {
+		got = append(got, item.GetKey()+"="+item.GetValue())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() sent %d pairs, want %d in order", len(got), len(want))
+	}
+
+	stream, err = ts.client.GetAll(ctx, &rpcpb.GetAllRequest{Namespace: "missing"})
+	if err != nil {
+		t.Fatalf("GetAll() error = %v", err)
+	}
+	if sent, err := recvAll[*rpcpb.Item](stream); err != nil || len(sent) != 0 {
+		t.Errorf("GetAll(missing) = %v, sent %v, want nothing", err, sent)
+	}
+}
+
+func TestService_Watch(t *testing.T) {
+	ts := newTestServer(t, server.Config{}, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := ts.client.Watch(ctx, &rpcpb.WatchRequest{Pattern: "user:*"})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	// Wait for the subscription, changes before it are not sent
+	received := make(chan *rpcpb.ChangeEvent, watchBuffer)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				done <- err
+				return
+			}
+			received <- event
+		}
+	}()
+	for ready := false; !ready; {
+		ts.client.Add(context.Background(), &rpcpb.AddRequest{Key: "user:0", Value: "ready"})
+		select {
+		case <-received:
+			ready = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	ts.client.Add(context.Background(), &rpcpb.AddRequest{Key: "user:1", Value: "a"})
+	ts.client.Add(context.Background(), &rpcpb.AddRequest{Key: "order:1", Value: "b"})
+	ts.client.Add(context.Background(), &rpcpb.AddRequest{Namespace: "prices", Key: "user:1", Value: "c"})
+	ts.client.Delete(context.Background(), &rpcpb.KeyRequest{Key: "user:1"})
+
+	var got []string
+	for len(got) == 0 || got[len(got)-1] != "delete user:1" {
+		select {
+		case event := <-received:
+			if event.GetKey() != "user:0" {
+				got = append(got, event.GetOp()+" "+event.GetKey())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch() sent %v, want the delete of user:1", got)
+		}
+	}
+	if want := []string{"add user:1", "delete user:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Watch() sent %v, want %v", got, want)
+	}
+
+	// Closing the service ends the watch
+	ts.service.Close()
+	if err := <-done; status.Code(err) != codes.Unavailable {
+		t.Errorf("Watch() after Close error = %v, want %s", err, codes.Unavailable)
+	}
+
+	stream, err = ts.client.Watch(context.Background(), &rpcpb.WatchRequest{Pattern: "["})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Watch([) error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+// stalledStream is the server side of a watch whose client does not read.
+// Send blocks until wait is closed.
+type stalledStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	wait    chan struct{}
+	waiting chan struct{} // signaled when Send starts blocking
+
+	mu   sync.Mutex
+	sent int
+}
+
+func (s *stalledStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stalledStream) Send(*rpcpb.ChangeEvent) error {
+	select {
+	case s.waiting <- struct{}{}:
+	default:
+	}
+	<-s.wait
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent++
+	return nil
+}
+
+// TestService_WatchOverflow tests that writers are not blocked by a watcher
+// that does not read. gRPC flow control would buffer the changes of a real
+// stream, so the stream is stalled on the server side.
+func TestService_WatchOverflow(t *testing.T) {
+	feed := storage.NewChangeFeed()
+	store := storage.NewNamespaces(storage.Limits{}, feed).Namespace("")
+	service := NewService(nil, feed, Config{})
+	s := &stalledStream{ctx: context.Background(), wait: make(chan struct{}), waiting: make(chan struct{}, 1)}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.Watch(&rpcpb.WatchRequest{Pattern: "*"}, s)
+	}()
+	// Write until the stream stalls on the first change
+	for stalled := false; !stalled; {
+		store.Add("key", strconv.FormatUint(feed.Version(), 10))
+		select {
+		case <-s.waiting:
+			stalled = true
+		case <-time.After(time.Millisecond):
+		}
+	}
+	// Writers are not blocked by the stalled stream
+	for i := 0; i < watchBuffer+10; i++ {
+		store.Add("key", strconv.Itoa(i))
+	}
+	close(s.wait)
+	if err := <-done; status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Watch() error = %v, want %s", err, codes.ResourceExhausted)
+	}
+
+	err := NewService(nil, nil, Config{}).Watch(&rpcpb.WatchRequest{Pattern: "*"}, s)
+	if status.Code(err) != codes.FailedPrecondition || status.Convert(err).Message() != ErrNoChangeFeed.Error() {
+		t.Errorf("Watch() without feed error = %v, want %v", err, ErrNoChangeFeed)
+	}
+}
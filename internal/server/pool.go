@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/queue"
+)
+
+const (
+	// scaleUpWait is the average dispatch wait that adds workers: commands
+	// wait for a free worker instead of being taken right away
+	scaleUpWait = time.Millisecond
+	// scaleDownUtilization is the share of busy workers below which idle
+	// workers are stopped
+	scaleDownUtilization = 0.5
+)
+
+// PoolConfig sets the initial size and bounds of a worker pool. Zero bounds
+// default to the size, which keeps the pool fixed.
+type PoolConfig struct {
+	Size int
+	Min  int
+	Max  int
+}
+
+// normalize fills in default bounds and clamps the size to them
+func (c PoolConfig) normalize() (PoolConfig, error) {
+	if c.Min <= 0 {
+		c.Min = c.Size
+	}
+	if c.Max <= 0 {
+		c.Max = c.Size
+	}
+	if c.Min < 1 || c.Max < c.Min {
+		return c, fmt.Errorf("invalid worker bounds: min %d, max %d", c.Min, c.Max)
+	}
+	c.Size = clamp(c.Size, c.Min, c.Max)
+	return c, nil
+}
+
+// job is a command waiting for a worker, with the channel its result is
+// sent to
+type job struct {
+	cmd    commands.Command
+	result chan error
+}
+
+// pool is a resizable set of workers reading jobs from one channel
+type pool struct {
+	name   string
+	jobs   chan job
+	handle func(commands.Command) error
+	queue  queue.Queue
+
+	mu      sync.Mutex
+	ctx     context.Context // set once the pool is started
+	size    int             // initial size
+	min     int
+	max     int
+	workers []chan struct{} // stop channel per worker
+	wg      sync.WaitGroup
+
+	busy       atomic.Int64
+	peakBusy   atomic.Int64
+	waitNanos  atomic.Int64
+	dispatched atomic.Int64
+	lastDepth  atomic.Int64
+	lastWait   atomic.Int64
+}
+
+// newPool creates a pool without workers for the commands of the queue
+func newPool(name string, config PoolConfig, handle func(commands.Command) error, q queue.Queue) (*pool, error) {
+	config, err := config.normalize()
+	if err != nil {
+		return nil, fmt.Errorf("%s pool: %w", name, err)
+	}
+
+	return &pool{
+		name:    name,
+		jobs:    make(chan job),
+		handle:  handle,
+		queue:   q,
+		size:    config.Size,
+		min:     config.Min,
+		max:     config.Max,
+		workers: make([]chan struct{}, 0, config.Size),
+	}, nil
+}
+
+// start starts the initial workers, which run until the context is done
+func (p *pool) start(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ctx = ctx
+	p.setSize(clamp(p.size, p.min, p.max))
+	p.limitInFlight()
+}
+
+// execute hands the command to a free worker, records how long it waited
+// and returns the result. It gives up when the context is done; a command
+// already taken by a worker is still completed then.
+func (p *pool) execute(ctx context.Context, cmd commands.Command) error {
+	j := job{cmd: cmd, result: make(chan error, 1)}
+
+	start := time.Now()
+	select {
+	case p.jobs <- j:
+		p.waitNanos.Add(int64(time.Since(start)))
+		p.dispatched.Add(1)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-j.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resize changes the bounds and clamps the current size to them
+func (p *pool) resize(lo, hi int) error {
+	if lo < 1 || hi < lo {
+		return fmt.Errorf("invalid worker bounds: min %d, max %d", lo, hi)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.min, p.max = lo, hi
+	p.setSize(clamp(len(p.workers), lo, hi))
+	p.limitInFlight()
+	return nil
+}
+
+// autoscale adjusts the pool size every interval until the context is done
+func (p *pool) autoscale(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.scale()
+		}
+	}
+}
+
+// scale grows the pool by half when commands wait for workers or the queue
+// has a backlog, and shrinks it by a quarter when most workers are idle
+func (p *pool) scale() {
+	var avgWait time.Duration
+	if dispatched := p.dispatched.Swap(0); dispatched > 0 {
+		avgWait = time.Duration(p.waitNanos.Swap(0) / dispatched)
+	}
+	p.lastWait.Store(int64(avgWait))
+	peakBusy := int(p.peakBusy.Swap(p.busy.Load()))
+
+	depth := 0
+	if reporter, ok := p.queue.(queue.DepthReporter); ok {
+		if d, err := reporter.Depth(); err == nil {
+			depth = d
+		} else {
+			log.Printf("Failed to get %s queue depth: %v", p.name, err)
+		}
+	}
+	p.lastDepth.Store(int64(depth))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	size := len(p.workers)
+	target := size
+	switch {
+	case avgWait > scaleUpWait || depth > size:
+		target = size + max(1, size/2)
+	case depth == 0 && float64(peakBusy) < float64(size)*scaleDownUtilization:
+		target = size - max(1, size/4)
+	}
+
+	if target = clamp(target, p.min, p.max); target != size {
+		log.Printf("Scaling %s pool from %d to %d workers (avg wait %s, queue depth %d, peak busy %d)",
+			p.name, size, target, avgWait, depth, peakBusy)
+		p.setSize(target)
+	}
+}
+
+// stats returns the current pool state
+func (p *pool) stats() commands.PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return commands.PoolStats{
+		Name:       p.name,
+		Size:       len(p.workers),
+		Min:        p.min,
+		Max:        p.max,
+		Busy:       int(p.busy.Load()),
+		QueueDepth: int(p.lastDepth.Load()),
+		AvgWait:    time.Duration(p.lastWait.Load()),
+	}
+}
+
+// limitInFlight aligns the unacked messages of the queue with the maximum
+// pool size, so a backlog stays in the broker instead of piling up in a
+// server that may crash. It is called with the lock held.
+func (p *pool) limitInFlight() {
+	if limiter, ok := p.queue.(queue.InFlightLimiter); ok {
+		if err := limiter.SetInFlightLimit(p.max); err != nil {
+			log.Printf("Failed to limit %s queue in-flight messages: %v", p.name, err)
+		}
+	}
+}
+
+// wait waits until all workers have exited
+func (p *pool) wait() {
+	p.wg.Wait()
+}
+
+// setSize starts or stops workers. Stopped workers finish their current
+// command first. It is called with the lock held.
+func (p *pool) setSize(size int) {
+	// Workers are not started before the pool or after it was stopped
+	if p.ctx == nil || p.ctx.Err() != nil {
+		return
+	}
+	for len(p.workers) < size {
+		stop := make(chan struct{})
+		p.workers = append(p.workers, stop)
+		p.wg.Add(1)
+		go p.worker(p.ctx, stop)
+	}
+	for len(p.workers) > size {
+		last := len(p.workers) - 1
+		close(p.workers[last])
+		p.workers = p.workers[:last]
+	}
+}
+
+// worker processes jobs until it is stopped or the context is done
+func (p *pool) worker(ctx context.Context, stop <-chan struct{}) {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case j := <-p.jobs:
+			storeMax(&p.peakBusy, p.busy.Add(1))
+			j.result <- p.handle(j.cmd)
+			p.busy.Add(-1)
+		}
+	}
+}
+
+// storeMax raises the value to n if it is lower
+func storeMax(value *atomic.Int64, n int64) {
+	for {
+		current := value.Load()
+		if n <= current || value.CompareAndSwap(current, n) {
+			return
+		}
+	}
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
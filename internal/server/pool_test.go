@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// fakeQueue reports a fixed depth and records the in-flight limit
+type fakeQueue struct {
+	depth         int
+	inFlightLimit int
+}
+
+func (q *fakeQueue) Publish(cmd commands.Command) error { return nil }
+func (q *fakeQueue) Subscribe(ctx context.Context, handler func(commands.Command) error) error {
+	return nil
+}
+func (q *fakeQueue) Close() error                     { return nil }
+func (q *fakeQueue) Depth() (int, error)              { return q.depth, nil }
+func (q *fakeQueue) SetInFlightLimit(limit int) error { q.inFlightLimit = limit; return nil }
+
+// waitForSize polls the pool until it has the expected number of workers
+func waitForSize(t *testing.T, p *pool, want int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if p.stats().Size == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d workers, got %d", want, p.stats().Size)
+}
+
+func TestPoolConfig_Normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PoolConfig
+		want    PoolConfig
+		wantErr bool
+	}{
+		{"fixed size", PoolConfig{Size: 4}, PoolConfig{Size: 4, Min: 4, Max: 4}, false},
+		{"size clamped to max", PoolConfig{Size: 10, Min: 1, Max: 5}, PoolConfig{Size: 5, Min: 1, Max: 5}, false},
+		{"size clamped to min", PoolConfig{Size: 1, Min: 2, Max: 5}, PoolConfig{Size: 2, Min: 2, Max: 5}, false},
+		{"no workers", PoolConfig{}, PoolConfig{}, true},
+		{"max below min", PoolConfig{Size: 4, Min: 5, Max: 2}, PoolConfig{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.normalize()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("normalize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPoolResize tests that resizing starts and stops workers
+func TestPoolResize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := &fakeQueue{}
+	p, err := newPool(commands.ReadPool, PoolConfig{Size: 2}, func(commands.Command) error { return nil }, q)
+	if err != nil {
+		t.Fatalf("newPool() error = %v", err)
+	}
+	p.start(ctx)
+	waitForSize(t, p, 2)
+
+	if err := p.resize(5, 8); err != nil {
+		t.Fatalf("resize() error = %v", err)
+	}
+	waitForSize(t, p, 5)
+	if q.inFlightLimit != 8 {
+		t.Errorf("Expected in-flight limit 8, got %d", q.inFlightLimit)
+	}
+
+	if err := p.resize(1, 3); err != nil {
+		t.Fatalf("resize() error = %v", err)
+	}
+	waitForSize(t, p, 3)
+
+	if err := p.resize(4, 2); err == nil {
+		t.Error("Expected error for max below min")
+	}
+
+	cancel()
+	p.wait()
+}
+
+// TestPoolAutoscale tests that a backlog grows the pool and idleness
+// shrinks it back to the minimum
+func TestPoolAutoscale(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	q := &fakeQueue{depth: 100}
+	p, err := newPool(commands.WritePool, PoolConfig{Size: 2, Min: 1, Max: 6}, func(commands.Command) error { return nil }, q)
+	if err != nil {
+		t.Fatalf("newPool() error = %v", err)
+	}
+	p.start(ctx)
+
+	// 2 -> 3 -> 4 -> 6, then capped at the maximum
+	for i := 0; i < 4; i++ {
+		p.scale()
+	}
+	if stats := p.stats(); stats.Size != 6 || stats.QueueDepth != 100 {
+		t.Errorf("Expected 6 workers with queue depth 100, got %+v", stats)
+	}
+
+	// Idle workers are stopped a quarter at a time
+	q.depth = 0
+	for i := 0; i < 10; i++ {
+		p.scale()
+	}
+	waitForSize(t, p, 1)
+}
+
+// TestPoolExecute tests that commands are handled by workers
+func TestPoolExecute(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan commands.Command, 10)
+	failed := errors.New("failed")
+	p, _ := newPool(commands.ReadPool, PoolConfig{Size: 3}, func(cmd commands.Command) error {
+		handled <- cmd
+		if cmd.GetKey() == "bad" {
+			return failed
+		}
+		return nil
+	}, &fakeQueue{})
+	p.start(ctx)
+
+	for i := 0; i < 10; i++ {
+		if err := p.execute(ctx, commands.NewCommand(commands.GetItem, "key", "")); err != nil {
+			t.Fatalf("execute() error = %v", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		<-handled
+	}
+
+	// The result of the command is returned to the caller
+	if err := p.execute(ctx, commands.NewCommand(commands.GetItem, "bad", "")); !errors.Is(err, failed) {
+		t.Errorf("execute() error = %v, want %v", err, failed)
+	}
+
+	cancel()
+	if err := p.execute(ctx, commands.NewCommand(commands.GetItem, "key", "")); err == nil {
+		t.Error("Expected execute to fail after the context is done")
+	}
+}
@@ -2,9 +2,15 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 
+	"eoracle-client-server/internal/audit"
+	"eoracle-client-server/internal/auth"
 	"eoracle-client-server/internal/commands"
 	"eoracle-client-server/internal/output"
 	"eoracle-client-server/internal/queue"
@@ -13,56 +19,149 @@ import (
 
 type Server interface {
 	Start(ctx context.Context) error
+	// Execute runs a command like a request taken from its queue: it is
+	// verified, validated, audited and authorized by the workers of its
+	// pool. Failed commands return their error in the result; the error is
+	// set if the command was not executed to the end.
+	Execute(ctx context.Context, cmd commands.Command) (commands.Result, error)
 	Close() error
 }
 
+var (
+	// ErrReadOnly is returned by Execute for write commands on a server
+	// without write queue
+	ErrReadOnly = errors.New("server serves read commands only")
+	// ErrStopped is returned by Execute once the workers stopped
+	ErrStopped = errors.New("server stopped")
+)
+
+// Config configures the worker pools and shutdown of a server
+type Config struct {
+	ReadPool  PoolConfig
+	WritePool PoolConfig
+	// AutoscaleInterval is the time between pool size adjustments, 0 to
+	// keep the sizes until an admin command changes them
+	AutoscaleInterval time.Duration
+	// DrainTimeout bounds how long in-flight commands may take to complete
+	// on shutdown, 0 to wait for them without limit
+	DrainTimeout time.Duration
+	// Verifier authenticates commands before they are handled, nil to
+	// accept unsigned commands
+	Verifier *auth.Verifier
+	// Policy authorizes the commands of authenticated clients, nil to allow
+	// every command
+	Policy *auth.Policy
+	// Limits are checked before commands are authorized, nil for the
+	// commands.DefaultLimits. Zero sizes are unlimited.
+	Limits *commands.Limits
+	// Audit records the write commands that passed authentication, nil to
+	// disable auditing
+	Audit *audit.Log
+	// Journal keeps the changes of writes on disk before they are
+	// acknowledged, nil to acknowledge them once applied
+	Journal Journal
+}
+
+// Journal keeps applied changes on disk, e.g. the failover state dump
+type Journal interface {
+	// Sync returns once every change applied so far is on disk
+	Sync() error
+}
+
 // Server represents the main server
 type server struct {
-	orderedMap   storage.Storage
-	output       output.Output
-	commands     commands.CommandRegistry
-	readQueue    queue.Queue
-	writeQueue   queue.Queue
-	mu           sync.Mutex
-	readWorkers  int
-	writeWorkers int
-}
-
-// NewServer creates a new server
-func NewServer(readQueue queue.Queue, writeQueue queue.Queue, readWorkers int, writeWorkers int, out output.Output) (Server, error) {
-
-	return &server{
-		orderedMap:   storage.NewOrderedMap(),
-		commands:     commands.NewCommandRegistry(),
-		readQueue:    readQueue,
-		writeQueue:   writeQueue,
-		output:       out,
-		readWorkers:  readWorkers,
-		writeWorkers: writeWorkers,
-	}, nil
-}
-
-// Start starts the server
-func (s *server) Start(ctx context.Context) error {
-	log.Printf("Starting server with %d read workers and %d write workers", s.readWorkers, s.writeWorkers)
+	namespaces storage.Namespaces
+	output     output.Output
+	commands   commands.CommandRegistry
+	readQueue  queue.Queue
+	writeQueue queue.Queue
+	readPool   *pool
+	writePool  *pool
+	config     Config
 
-	// Create an unbuffered channels for read and write commands
-	readCommandChan := make(chan commands.Command)
-	writeCommandChan := make(chan commands.Command)
+	// done is canceled once the workers stopped
+	done context.Context
+	stop context.CancelFunc
+}
 
-	// Use a WaitGroup to wait for all workers to finish
-	var wg sync.WaitGroup
+// call is a command run by Execute, which gets its result like the
+// publisher of a request
+type call struct {
+	commands.Command
+	result commands.Result
+}
 
-	// Start read worker goroutines
-	for i := 0; i < s.readWorkers; i++ {
-		wg.Add(1)
-		go s.worker(ctx, &wg, readCommandChan)
+func (c *call) Reply(result commands.Result) error {
+	c.result = result
+	return nil
+}
+
+// NewServer creates a new server. A nil write queue serves read commands only.
+func NewServer(readQueue queue.Queue, writeQueue queue.Queue, config Config, out output.Output, spaces storage.Namespaces) (Server, error) {
+	s := &server{
+		namespaces: spaces,
+		commands:   commands.NewCommandRegistry(),
+		readQueue:  readQueue,
+		writeQueue: writeQueue,
+		output:     out,
+		config:     config,
+	}
+	s.done, s.stop = context.WithCancel(context.Background())
+	s.commands.SetAdmin(s)
+	if config.Limits != nil {
+		if err := s.commands.SetLimits(*config.Limits); err != nil {
+			return nil, err
+		}
 	}
 
-	// Start write worker goroutines
-	for i := 0; i < s.writeWorkers; i++ {
-		wg.Add(1)
-		go s.worker(ctx, &wg, writeCommandChan)
+	var err error
+	s.readPool, err = newPool(commands.ReadPool, config.ReadPool, s.handle, readQueue)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replicas have no write queue and serve read commands only
+	if writeQueue != nil {
+		s.writePool, err = newPool(commands.WritePool, config.WritePool, s.handle, writeQueue)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Start serves commands until the context is done and then drains: the
+// subscriptions stop taking messages, commands already taken are completed
+// and acked, and the workers stop. When the drain timeout expires, messages
+// of unfinished commands are requeued; a command still running may then be
+// executed again by another server.
+func (s *server) Start(ctx context.Context) error {
+	pools := []*pool{s.readPool}
+	if s.writePool != nil {
+		pools = append(pools, s.writePool)
+	} else {
+		log.Println("No write queue, serving read commands only")
+	}
+
+	// Workers outlive ctx until the in-flight commands are drained
+	workCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	// Use WaitGroups to wait for the subscriptions and autoscalers to finish
+	var wg, autoscalers sync.WaitGroup
+
+	for _, p := range pools {
+		p.start(workCtx)
+		stats := p.stats()
+		log.Printf("Started %s pool with %d workers (min %d, max %d)", stats.Name, stats.Size, stats.Min, stats.Max)
+
+		if s.config.AutoscaleInterval > 0 {
+			autoscalers.Add(1)
+			go func(p *pool) {
+				defer autoscalers.Done()
+				p.autoscale(ctx, s.config.AutoscaleInterval)
+			}(p)
+		}
 	}
 
 	// Start consuming messages from the read queue
@@ -71,63 +170,262 @@ func (s *server) Start(ctx context.Context) error {
 		defer wg.Done()
 		//Subscribe to the read queue
 		if err := s.readQueue.Subscribe(ctx, func(cmd commands.Command) error {
-			select {
-			case readCommandChan <- cmd:
-				return nil // If command is sent to channel, return nil
-			case <-ctx.Done():
-				return ctx.Err() // If context is done, return the error
-			}
+			return s.readPool.execute(workCtx, cmd)
 		}); err != nil {
 			log.Fatalf("failed to subscribe to read queue: %s", err)
 		}
 	}()
 
 	// Start consuming messages from the write queue
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		// Subscribe to the write queue
-		if err := s.writeQueue.Subscribe(ctx, func(cmd commands.Command) error {
-
-			select {
-			case writeCommandChan <- cmd:
-				return nil // If command is sent to channel, return nil
-			case <-ctx.Done():
-				return ctx.Err() // If context is done, return the error
+	if s.writePool != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Subscribe to the write queue
+			if err := s.writeQueue.Subscribe(ctx, func(cmd commands.Command) error {
+				return s.writePool.execute(workCtx, cmd)
+			}); err != nil {
+				log.Fatalf("failed to subscribe to write queue: %s", err)
 			}
-		}); err != nil {
-			log.Fatalf("failed to subscribe to write queue: %s", err)
-		}
-	}()
+		}()
+	}
+
+	<-ctx.Done()
+	log.Println("Draining in-flight commands")
+	drained := s.drain(&wg)
+	stopWorkers()
+	s.stop()
+	autoscalers.Wait()
 
-	wg.Wait()
+	// Commands running past the drain timeout are left behind, their
+	// messages are requeued once the subscriptions returned
+	if !drained {
+		log.Printf("Drain timeout of %s expired, requeueing remaining commands", s.config.DrainTimeout)
+		wg.Wait()
+		return nil
+	}
+
+	for _, p := range pools {
+		p.wait()
+	}
 	log.Println("All goroutines have exited")
 	return nil
 }
 
-// worker processes commands from the channel
-func (s *server) worker(ctx context.Context, wg *sync.WaitGroup, commandChan <-chan commands.Command) {
-	defer wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case cmd := <-commandChan:
-			if err := s.commands.HandleCommand(cmd, s.orderedMap, s.output); err != nil {
-				log.Printf("Failed to handle command: %v", err)
-				continue
-			}
+// drain waits for the subscriptions to return, which happens once their
+// in-flight commands are settled. It returns false on the drain timeout.
+func (s *server) drain(subscriptions *sync.WaitGroup) bool {
+	drained := make(chan struct{})
+	go func() {
+		subscriptions.Wait()
+		close(drained)
+	}()
+
+	var timeout <-chan time.Time
+	if s.config.DrainTimeout > 0 {
+		timer := time.NewTimer(s.config.DrainTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-drained:
+		return true
+	case <-timeout:
+		return false
+	}
+}
+
+// Execute hands the command to the read or write pool and waits for its
+// result. Commands sent before Start wait for the workers.
+func (s *server) Execute(ctx context.Context, cmd commands.Command) (commands.Result, error) {
+	p := s.readPool
+	if !s.commands.IsReadCommand(cmd) {
+		if s.writePool == nil {
+			return commands.Result{}, ErrReadOnly
 		}
+		p = s.writePool
 	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(s.done, cancel)()
+
+	c := &call{Command: cmd}
+	err := p.execute(ctx, c)
+	if ctxErr := ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+		if s.done.Err() != nil {
+			return commands.Result{}, ErrStopped
+		}
+		return commands.Result{}, ctxErr
+	}
+	if err != nil {
+		// Commands are not retried here, the caller gets the error like
+		// the publisher of a request that failed for good
+		var rejectErr *queue.RejectError
+		if errors.As(err, &rejectErr) {
+			err = rejectErr.Err
+		}
+		return commands.NewResult("", nil, err), nil
+	}
+	return c.result, nil
+}
+
+// handle executes a command taken by a worker. Requests get the output of
+// the command as their result; failures are replied by the queue once the
+// command is not retried anymore.
+func (s *server) handle(cmd commands.Command) error {
+	request, ok := cmd.(queue.Request)
+	if !ok {
+		return s.process(cmd, s.output)
+	}
+
+	recorder := output.NewRecorder(s.output)
+	err := s.process(cmd, recorder)
+	if err == nil {
+		if replyErr := request.Reply(commands.NewResult(recorder.Text(), recorder.Items(), nil)); replyErr != nil {
+			log.Printf("Failed to reply to %s command: %v", cmd.GetType(), replyErr)
+		}
+	}
+	return err
+}
+
+// process verifies, validates and executes a command. Retryable errors are
+// passed on for the queue to retry the command; other errors reject it,
+// since executing it again would fail the same way.
+func (s *server) process(cmd commands.Command, out output.Output) error {
+	if s.config.Verifier != nil {
+		if err := s.config.Verifier.Verify(cmd); err != nil {
+			log.Printf("Rejected %s command: %v", cmd.GetType(), err)
+			return queue.Reject(err)
+		}
+	}
+	if err := s.commands.Validate(cmd); err != nil {
+		log.Printf("Rejected %s command: %v", cmd.GetType(), err)
+		return queue.Reject(err)
+	}
+	if s.config.Audit != nil && !s.commands.IsReadCommand(cmd) {
+		// Writes are not run unaudited: once the log failed they are
+		// rejected, and a write whose entry failed is not acknowledged but
+		// dead-lettered with the error for the operator to reconcile
+		if err := s.config.Audit.Err(); err != nil {
+			log.Printf("Rejected %s command: %v", cmd.GetType(), err)
+			return queue.Reject(err)
+		}
+		tracker := audit.Track(s.namespaces)
+		err := s.execute(cmd, tracker, out)
+		if auditErr := s.config.Audit.Append(audit.NewEntry(cmd, tracker.Changes(), err)); auditErr != nil {
+			log.Printf("Failed to audit %s command: %v", cmd.GetType(), auditErr)
+			return queue.Reject(fmt.Errorf("%s command not audited: %w", cmd.GetType(), auditErr))
+		}
+		return s.settle(cmd, s.journal(cmd, err))
+	}
+	return s.settle(cmd, s.journal(cmd, s.execute(cmd, s.namespaces, out)))
+}
+
+// journal waits until the changes of a handled write are on disk. A write
+// that cannot be journaled is retried, e.g. by the server taking over.
+func (s *server) journal(cmd commands.Command, err error) error {
+	if s.config.Journal == nil || s.commands.IsReadCommand(cmd) {
+		return err
+	}
+	if syncErr := s.config.Journal.Sync(); syncErr != nil {
+		log.Printf("Failed to journal %s command: %v", cmd.GetType(), syncErr)
+		return commands.Retryable(fmt.Errorf("%s command not journaled: %w", cmd.GetType(), syncErr))
+	}
+	return err
+}
+
+// execute authorizes and handles the command
+func (s *server) execute(cmd commands.Command, spaces storage.Namespaces, out output.Output) error {
+	if s.config.Policy != nil {
+		if err := s.authorize(cmd); err != nil {
+			return err
+		}
+	}
+	return s.commands.HandleCommand(cmd, spaces, out)
+}
+
+// settle tells the queue what to do with the message of a handled command
+func (s *server) settle(cmd commands.Command, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case commands.IsRetryable(err):
+		// The retried message carries the same nonce
+		if s.config.Verifier != nil {
+			s.config.Verifier.Release(cmd)
+		}
+		return err
+	default:
+		return queue.Reject(err)
+	}
+}
+
+// authorize checks the command against the access policy. Denials are
+// logged for the audit trail.
+func (s *server) authorize(cmd commands.Command) error {
+	client := ""
+	if signature := cmd.GetAuth(); signature != nil {
+		client = signature.Client
+	}
+	scope := s.commands.Scope(cmd)
+
+	err := s.config.Policy.Authorize(client, cmd.GetType(), s.commands.Category(cmd), scope)
+	if err != nil {
+		log.Printf("AUDIT denied client=%q command=%s namespace=%q keys=%q",
+			client, cmd.GetType(), scope.Namespace, strings.Join(scope.Keys, ","))
+	}
+	return err
+}
+
+// ResizePool changes the worker bounds of the read or write pool
+func (s *server) ResizePool(name string, min, max int) error {
+	switch {
+	case name == commands.ReadPool:
+		return s.readPool.resize(min, max)
+	case name == commands.WritePool && s.writePool != nil:
+		return s.writePool.resize(min, max)
+	default:
+		return fmt.Errorf("no %s worker pool", name)
+	}
+}
+
+// PoolStats returns the state of the worker pools
+func (s *server) PoolStats() []commands.PoolStats {
+	stats := []commands.PoolStats{s.readPool.stats()}
+	if s.writePool != nil {
+		stats = append(stats, s.writePool.stats())
+	}
+	return stats
+}
+
+// Rejections returns the number of commands rejected by authentication per
+// reason, and by the access policy as ACCESS_DENIED. It is nil if neither
+// is configured.
+func (s *server) Rejections() map[string]int64 {
+	if s.config.Verifier == nil && s.config.Policy == nil {
+		return nil
+	}
+	counts := make(map[string]int64)
+	if s.config.Verifier != nil {
+		counts = s.config.Verifier.Rejections()
+	}
+	if s.config.Policy != nil {
+		counts[string(commands.CodeAccessDenied)] = s.config.Policy.Denied()
+	}
+	return counts
 }
 
 // Close closes the server
 func (s *server) Close() error {
 
 	// Close the write queue
-	if err := s.writeQueue.Close(); err != nil {
-		log.Printf("Failed to close write queue: %v", err)
-		return err
+	if s.writeQueue != nil {
+		if err := s.writeQueue.Close(); err != nil {
+			log.Printf("Failed to close write queue: %v", err)
+			return err
+		}
 	}
 	// Close the read queue
 	if err := s.readQueue.Close(); err != nil {
@@ -0,0 +1,658 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"eoracle-client-server/internal/audit"
+	"eoracle-client-server/internal/auth"
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/queue"
+	"eoracle-client-server/internal/storage"
+)
+
+// memoryPrefetch bounds the unsettled messages of a memoryQueue
+const memoryPrefetch = 8
+
+// memoryQueue follows the Subscribe contract of queue.Queue in memory and
+// records how every message was settled
+type memoryQueue struct {
+	messages chan commands.Command
+
+	mu       sync.Mutex
+	acked    []commands.Command
+	requeued []commands.Command
+	rejected []commands.Command
+}
+
+func newMemoryQueue(cmds ...commands.Command) *memoryQueue {
+	q := &memoryQueue{messages: make(chan commands.Command, len(cmds))}
+	for _, cmd := range cmds {
+		q.messages <- cmd
+	}
+	return q
+}
+
+func (q *memoryQueue) Publish(cmd commands.Command) error {
+	q.messages <- cmd
+	return nil
+}
+
+func (q *memoryQueue) Subscribe(ctx context.Context, handler func(commands.Command) error) error {
+	var inFlight sync.WaitGroup
+	defer inFlight.Wait()
+	prefetch := make(chan struct{}, memoryPrefetch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case prefetch <- struct{}{}:
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case cmd := <-q.messages:
+			inFlight.Add(1)
+			go func() {
+				defer inFlight.Done()
+				defer func() { <-prefetch }()
+				err := handler(cmd)
+				var rejectErr *queue.RejectError
+
+				q.mu.Lock()
+				defer q.mu.Unlock()
+				switch {
+				case err == nil:
+					q.acked = append(q.acked, cmd)
+				case errors.As(err, &rejectErr):
+					q.rejected = append(q.rejected, cmd)
+				default:
+					q.requeued = append(q.requeued, cmd)
+				}
+			}()
+		}
+	}
+}
+
+func (q *memoryQueue) Close() error { return nil }
+
+func (q *memoryQueue) settledCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.acked) + len(q.requeued) + len(q.rejected)
+}
+
+func (q *memoryQueue) ackedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.acked)
+}
+
+type nopOutput struct{}
+
+func (nopOutput) Write(data string) {}
+func (nopOutput) Close() error      { return nil }
+
+// slowNamespaces delays every namespace lookup, and so every command, by
+// the given time. Closing release stops the delay.
+func slowNamespaces(delay time.Duration, release <-chan struct{}) storage.Namespaces {
+	return delayedNamespaces{Namespaces: storage.NewNamespaces(storage.Limits{}, nil), delay: delay, release: release}
+}
+
+type delayedNamespaces struct {
+	storage.Namespaces
+	delay   time.Duration
+	release <-chan struct{}
+}
+
+func (n delayedNamespaces) Namespace(name string) storage.Storage {
+	select {
+	case <-time.After(n.delay):
+	case <-n.release:
+	}
+	return n.Namespaces.Namespace(name)
+}
+
+// serveUntilSettled runs the server until n messages of the queue are settled
+func serveUntilSettled(srv Server, q *memoryQueue, n int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+	for q.settledCount() < n {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+func addCommands(n int) []commands.Command {
+	cmds := make([]commands.Command, n)
+	for i := range cmds {
+		cmds[i] = commands.NewCommand(commands.AddItem, fmt.Sprintf("key%d", i), "value")
+	}
+	return cmds
+}
+
+// TestServerDrain tests that shutdown completes in-flight commands and acks
+// exactly the applied ones
+func TestServerDrain(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	spaces := slowNamespaces(time.Millisecond, release)
+
+	cmds := addCommands(200)
+	writeQueue := newMemoryQueue(cmds...)
+	srv, err := NewServer(newMemoryQueue(), writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 4},
+	}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+
+	// Shut down while commands are in flight
+	for writeQueue.ackedCount() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	store := spaces.Namespace("")
+	for _, cmd := range writeQueue.acked {
+		if _, exists := store.Get(cmd.GetKey()); !exists {
+			t.Errorf("Acked command for %s was not applied", cmd.GetKey())
+		}
+	}
+	if store.Size() != len(writeQueue.acked) {
+		t.Errorf("Expected %d applied commands, got %d", len(writeQueue.acked), store.Size())
+	}
+	if len(writeQueue.rejected) != 0 {
+		t.Errorf("Expected no rejected commands, got %d", len(writeQueue.rejected))
+	}
+
+	// Every message is either acked, requeued or still in the queue
+	if settled := len(writeQueue.acked) + len(writeQueue.requeued) + len(writeQueue.messages); settled != len(cmds) {
+		t.Errorf("Expected %d messages accounted for, got %d", len(cmds), settled)
+	}
+}
+
+// TestServerDrainTimeout tests that commands blocked past the drain timeout
+// are requeued instead of acked
+func TestServerDrainTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	spaces := slowNamespaces(time.Hour, release)
+
+	writeQueue := newMemoryQueue(addCommands(5)...)
+	srv, err := NewServer(newMemoryQueue(), writeQueue, Config{
+		ReadPool:     PoolConfig{Size: 1},
+		WritePool:    PoolConfig{Size: 1},
+		DrainTimeout: 50 * time.Millisecond,
+	}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Start to return after the drain timeout")
+	}
+
+	// The first command blocks until the test ends, so no command completes
+	// and all of them are requeued
+	if writeQueue.ackedCount() != 0 {
+		t.Errorf("Expected no acked command, got %d", writeQueue.ackedCount())
+	}
+	if len(writeQueue.requeued) != 5 {
+		t.Errorf("Expected 5 requeued commands, got %d", len(writeQueue.requeued))
+	}
+}
+
+// TestServerFailedCommands tests that invalid commands and permanent handler
+// errors reject the command and retryable ones hand it back to the queue
+func TestServerFailedCommands(t *testing.T) {
+	readQueue := newMemoryQueue(
+		commands.NewCommand(commands.ResizePool, "write", "", "1", "2"), // no write pool
+		commands.NewCommand("fromNewerVersion", "key", ""),
+		commands.NewCommand(commands.GetItem, "key-over-limit", ""),
+	)
+	srv, err := NewServer(readQueue, nil, Config{
+		ReadPool: PoolConfig{Size: 1},
+		Limits:   &commands.Limits{MaxKeySize: 8},
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+
+	serveUntilSettled(srv, readQueue, 3)
+
+	rejected := map[commands.CommandType]bool{}
+	for _, cmd := range readQueue.rejected {
+		rejected[cmd.GetType()] = true
+	}
+	if len(readQueue.rejected) != 2 || !rejected[commands.ResizePool] || !rejected[commands.GetItem] {
+		t.Errorf("Expected resize and get to be rejected, got %v", readQueue.rejected)
+	}
+	if len(readQueue.requeued) != 1 || readQueue.requeued[0].GetType() != "fromNewerVersion" {
+		t.Errorf("Expected unknown command to be retried, got %v", readQueue.requeued)
+	}
+}
+
+// newTestAuth returns a signer of the client and a verifier knowing its key
+func newTestAuth(t *testing.T, client string) (*auth.Signer, *auth.Verifier) {
+	t.Helper()
+	signing, verification, err := auth.GenerateKey(client, client+"-1", auth.Ed25519)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, _ := auth.NewSigner(signing)
+
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	data, _ := json.Marshal([]auth.Key{verification})
+	os.WriteFile(path, data, 0600)
+	keyring, err := auth.LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring() error = %v", err)
+	}
+	return signer, auth.NewVerifier(keyring, time.Minute)
+}
+
+// TestServerAuthenticatesCommands tests that unsigned and replayed commands
+// are rejected before they are handled
+func TestServerAuthenticatesCommands(t *testing.T) {
+	signer, verifier := newTestAuth(t, "dashboard")
+
+	cmd := commands.NewCommand(commands.GetItem, "key", "")
+	signed, _ := signer.Sign(cmd)
+	readQueue := newMemoryQueue(signed, cmd, signed)
+
+	srv, err := NewServer(readQueue, nil, Config{
+		ReadPool: PoolConfig{Size: 1},
+		Verifier: verifier,
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	serveUntilSettled(srv, readQueue, 3)
+
+	if len(readQueue.acked) != 1 || len(readQueue.rejected) != 2 {
+		t.Errorf("Expected 1 acked and 2 rejected commands, got %d acked and %d rejected",
+			len(readQueue.acked), len(readQueue.rejected))
+	}
+	rejections := srv.(*server).Rejections()
+	if rejections["unsigned command"] != 1 || rejections["replayed nonce"] != 1 {
+		t.Errorf("Rejections() = %v, want 1 unsigned and 1 replayed", rejections)
+	}
+}
+
+// TestServerAuthorizesCommands tests that commands the policy does not allow
+// are rejected
+func TestServerAuthorizesCommands(t *testing.T) {
+	signer, verifier := newTestAuth(t, "grafana")
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"clients": {"grafana": {"rules": [{"categories": ["read"]}]}}}`), 0600)
+	policy, err := auth.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	get, _ := signer.Sign(commands.NewCommand(commands.GetItem, "key", ""))
+	add, _ := signer.Sign(commands.NewCommand(commands.AddItem, "key", "value"))
+	readQueue, writeQueue := newMemoryQueue(get), newMemoryQueue(add)
+
+	srv, err := NewServer(readQueue, writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Verifier:  verifier,
+		Policy:    policy,
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	for readQueue.settledCount() == 0 {
+		serveUntilSettled(srv, writeQueue, 1)
+	}
+
+	if len(readQueue.acked) != 1 {
+		t.Errorf("Expected get to be acked, got %d acked", len(readQueue.acked))
+	}
+	if len(writeQueue.rejected) != 1 {
+		t.Errorf("Expected add to be rejected, got %d rejected", len(writeQueue.rejected))
+	}
+	if denied := srv.(*server).Rejections()[string(commands.CodeAccessDenied)]; denied != 1 {
+		t.Errorf("Expected 1 denied command, got %d", denied)
+	}
+}
+
+// TestServerCountsDenialsWithoutVerifier tests that policy denials are
+// counted on servers that do not authenticate commands
+func TestServerCountsDenialsWithoutVerifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"clients": {"grafana": {"rules": [{"categories": ["read"]}]}}}`), 0600)
+	policy, err := auth.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+
+	writeQueue := newMemoryQueue(commands.NewCommand(commands.AddItem, "key", "value"))
+	srv, err := NewServer(newMemoryQueue(), writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Policy:    policy,
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	serveUntilSettled(srv, writeQueue, 1)
+
+	if len(writeQueue.rejected) != 1 {
+		t.Errorf("Expected add to be rejected, got %d rejected", len(writeQueue.rejected))
+	}
+	rejections := srv.(*server).Rejections()
+	if len(rejections) != 1 || rejections[string(commands.CodeAccessDenied)] != 1 {
+		t.Errorf("Rejections() = %v, want 1 ACCESS_DENIED", rejections)
+	}
+}
+
+// TestServerAuditsWrites tests that write commands are recorded with their
+// changes and result
+func TestServerAuditsWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer auditLog.Close()
+
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	spaces.Namespace("").Add("key", "old")
+	writeQueue := newMemoryQueue(
+		commands.NewCommand(commands.AddItem, "key", "new"),
+		commands.NewCommand(commands.DeleteItem, "missing", ""),
+	)
+	readQueue := newMemoryQueue(commands.NewCommand(commands.GetItem, "key", ""))
+
+	srv, err := NewServer(readQueue, writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Audit:     auditLog,
+	}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	for readQueue.settledCount() == 0 {
+		serveUntilSettled(srv, writeQueue, 2)
+	}
+
+	if head := auditLog.Head(); head.Seq != 2 {
+		t.Fatalf("Expected 2 audited writes, got %d", head.Seq)
+	}
+	if _, err := audit.Verify(path, nil); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{`"old_value":"old","new_value":"new"`, `"result":"ok"`, `"command":"deleteItem"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected audit log to contain %s, got %s", want, data)
+		}
+	}
+}
+
+// TestServerRejectsUnauditedWrites tests that writes are not acknowledged
+// once the audit log fails
+func TestServerRejectsUnauditedWrites(t *testing.T) {
+	auditLog, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	// Appending to the closed log fails
+	auditLog.Close()
+
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	writeQueue := newMemoryQueue(
+		commands.NewCommand(commands.AddItem, "first", "1"),
+		commands.NewCommand(commands.AddItem, "second", "2"),
+	)
+	srv, err := NewServer(newMemoryQueue(), writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Audit:     auditLog,
+	}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	serveUntilSettled(srv, writeQueue, 2)
+
+	if len(writeQueue.rejected) != 2 {
+		t.Errorf("Expected 2 rejected writes, got %d", len(writeQueue.rejected))
+	}
+	if auditLog.Err() == nil {
+		t.Error("Expected the audit log to be failed")
+	}
+	// The write whose entry failed ran, the one after the failure did not
+	if size := spaces.Namespace("").Size(); size != 1 {
+		t.Errorf("Expected 1 write to run, got %d", size)
+	}
+}
+
+// TestServerRepliesToRequests tests that requests get the output of their
+// command and failed requests the error
+func TestServerRepliesToRequests(t *testing.T) {
+	readQueue, writeQueue := queue.NewMemoryQueue(0), queue.NewMemoryQueue(0)
+	srv, err := NewServer(readQueue, writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Limits:    &commands.Limits{MaxKeySize: 8},
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if result, err := writeQueue.Request(ctx, commands.NewCommand(commands.AddItem, "key", "value")); err != nil || result.Error != "" {
+		t.Fatalf("Request(add) = %+v, %v", result, err)
+	}
+	result, err := readQueue.Request(ctx, commands.NewCommand(commands.GetItem, "key", ""))
+	if err != nil {
+		t.Fatalf("Request(get) error = %v", err)
+	}
+	// Items are not repeated in the output
+	want := commands.Result{Items: []commands.Item{{Key: "key", Value: "value"}}}
+	if result.Output != want.Output || len(result.Items) != 1 || result.Items[0] != want.Items[0] {
+		t.Errorf("Request(get) = %+v, want %+v", result, want)
+	}
+	result, err = readQueue.Request(ctx, commands.NewCommand(commands.NamespaceInfo, "default", ""))
+	if want := "default: 1 items\n"; err != nil || result.Output != want || len(result.Items) != 0 {
+		t.Errorf("Request(nsinfo) = %+v, %v, want output %q", result, err, want)
+	}
+
+	result, err = readQueue.Request(ctx, commands.NewCommand(commands.GetItem, "key-over-limit", ""))
+	if err != nil {
+		t.Fatalf("Request(get) error = %v", err)
+	}
+	if result.Code != commands.CodeKeyTooLong || len(result.Items) != 0 {
+		t.Errorf("Request(get) = %+v, want code %s", result, commands.CodeKeyTooLong)
+	}
+}
+
+// TestServerExecute tests that executed commands are authenticated and
+// authorized like queued ones
+func TestServerExecute(t *testing.T) {
+	signer, verifier := newTestAuth(t, "grafana")
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"clients": {"grafana": {"rules": [{"categories": ["read"]}]}}}`), 0600)
+	policy, err := auth.LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error = %v", err)
+	}
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	spaces.Namespace("").Add("key", "value")
+
+	srv, err := NewServer(newMemoryQueue(), newMemoryQueue(), Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Verifier:  verifier,
+		Policy:    policy,
+	}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+
+	get, _ := signer.Sign(commands.NewCommand(commands.GetItem, "key", ""))
+	result, err := srv.Execute(context.Background(), get)
+	if err != nil || len(result.Items) != 1 || result.Items[0].Value != "value" {
+		t.Errorf("Execute(get) = %+v, %v, want key = value", result, err)
+	}
+	if result, err := srv.Execute(context.Background(), commands.NewCommand(commands.GetItem, "key", "")); err != nil || result.Error == "" {
+		t.Errorf("Execute(unsigned get) = %+v, %v, want error result", result, err)
+	}
+	add, _ := signer.Sign(commands.NewCommand(commands.AddItem, "key", "new"))
+	if result, err := srv.Execute(context.Background(), add); err != nil || result.Code != commands.CodeAccessDenied {
+		t.Errorf("Execute(add) = %+v, %v, want code %s", result, err, commands.CodeAccessDenied)
+	}
+	if value, _ := spaces.Namespace("").Get("key"); value != "value" {
+		t.Errorf("Denied add changed the value to %q", value)
+	}
+
+	cancel()
+	<-done
+	get, _ = signer.Sign(commands.NewCommand(commands.GetItem, "key", ""))
+	if _, err := srv.Execute(context.Background(), get); !errors.Is(err, ErrStopped) {
+		t.Errorf("Execute() after stop error = %v, want %v", err, ErrStopped)
+	}
+
+	replica, err := NewServer(newMemoryQueue(), nil, Config{ReadPool: PoolConfig{Size: 1}}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if _, err := replica.Execute(context.Background(), add); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Execute(add) on replica error = %v, want %v", err, ErrReadOnly)
+	}
+}
+
+// TestServerMutationErrors tests that a mutation failing on the stored
+// value or the limits returns its code and is audited as failed
+func TestServerMutationErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer auditLog.Close()
+	spaces := storage.NewNamespaces(storage.Limits{}, nil)
+	spaces.Namespace("").Add("counter", "abc")
+	spaces.Namespace("").Add("greeting", "hello")
+
+	srv, err := NewServer(newMemoryQueue(), newMemoryQueue(), Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Limits:    &commands.Limits{MaxValueSize: 8},
+		Audit:     auditLog,
+	}, nopOutput{}, spaces)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	result, err := srv.Execute(context.Background(), commands.NewCommand(commands.Incr, "counter", ""))
+	if err != nil || result.Code != commands.CodeNotInteger || result.Output != "" {
+		t.Errorf("Execute(incr) = %+v, %v, want code %s", result, err, commands.CodeNotInteger)
+	}
+	result, err = srv.Execute(context.Background(), commands.NewCommand(commands.Append, "greeting", " world"))
+	if err != nil || result.Code != commands.CodeValueTooLong || result.Output != "" {
+		t.Errorf("Execute(append) = %+v, %v, want code %s", result, err, commands.CodeValueTooLong)
+	}
+	if value, _ := spaces.Namespace("").Get("greeting"); value != "hello" {
+		t.Errorf("Oversized append changed the value to %q", value)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), `"result":"ok"`) || !strings.Contains(string(data), commands.ErrNotInteger.Error()) ||
+		!strings.Contains(string(data), string(commands.CodeValueTooLong)) {
+		t.Errorf("Expected the failed incr and append in the audit log, got %s", data)
+	}
+}
+
+// failingJournal fails every Sync
+type failingJournal struct{}
+
+func (failingJournal) Sync() error { return errors.New("disk failed") }
+
+// TestServerJournalsWrites tests that writes are not acknowledged before
+// they are journaled
+func TestServerJournalsWrites(t *testing.T) {
+	writeQueue := newMemoryQueue(commands.NewCommand(commands.AddItem, "key", "value"))
+	readQueue := newMemoryQueue(commands.NewCommand(commands.GetItem, "key", ""))
+	srv, err := NewServer(readQueue, writeQueue, Config{
+		ReadPool:  PoolConfig{Size: 1},
+		WritePool: PoolConfig{Size: 1},
+		Journal:   failingJournal{},
+	}, nopOutput{}, storage.NewNamespaces(storage.Limits{}, nil))
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	for readQueue.settledCount() == 0 {
+		serveUntilSettled(srv, writeQueue, 1)
+	}
+
+	if len(writeQueue.requeued) != 1 {
+		t.Errorf("Expected the unjournaled write to be retried, got %d acked and %d rejected",
+			len(writeQueue.acked), len(writeQueue.rejected))
+	}
+	if len(readQueue.acked) != 1 {
+		t.Errorf("Expected reads not to be journaled, got %d acked", len(readQueue.acked))
+	}
+}
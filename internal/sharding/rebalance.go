@@ -0,0 +1,77 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/queue"
+	"eoracle-client-server/internal/storage"
+)
+
+// Move is a key that has to be copied to another shard during rebalancing
+type Move struct {
+	Namespace string
+	Key       string
+	Value     string
+	From      string
+	To        string
+}
+
+// PlanMoves returns the keys of a shard snapshot that belong to another shard
+// of the ring. Namespaces are planned in name order and keys in map order,
+// so moved keys keep their relative order on the target shard.
+func PlanMoves(shard string, namespaces map[string][]storage.KeyValue, ring *Ring) []Move {
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var moves []Move
+	for _, name := range names {
+		for _, item := range namespaces[name] {
+			if target := ring.Shard(item.Key); target != shard {
+				moves = append(moves, Move{
+					Namespace: name,
+					Key:       item.Key,
+					Value:     item.Value,
+					From:      shard,
+					To:        target,
+				})
+			}
+		}
+	}
+	return moves
+}
+
+// Apply copies the key to its new shard and deletes it from the old one
+// once the copy was applied. A copy that failed, e.g. denied by the access
+// policy, leaves the key on the old shard.
+func (m Move) Apply(ctx context.Context, from, to queue.Requester) error {
+	add := commands.WithNamespace(commands.NewCommand(commands.AddItem, m.Key, m.Value), m.Namespace)
+	if err := request(ctx, to, add); err != nil {
+		return fmt.Errorf("failed to copy to %s: %w", m.To, err)
+	}
+	del := commands.WithNamespace(commands.NewCommand(commands.DeleteItem, m.Key, ""), m.Namespace)
+	if err := request(ctx, from, del); err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", m.From, err)
+	}
+	return nil
+}
+
+// request sends the command and returns the error of its result
+func request(ctx context.Context, q queue.Requester, cmd commands.Command) error {
+	result, err := q.Request(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	if result.Error != "" {
+		if result.Code != "" {
+			return fmt.Errorf("%s (%s)", result.Error, result.Code)
+		}
+		return fmt.Errorf("%s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"eoracle-client-server/internal/commands"
+	"eoracle-client-server/internal/storage"
+)
+
+func TestPlanMoves(t *testing.T) {
+	before, _ := NewRing(ShardNames(2))
+	after, _ := NewRing(ShardNames(3))
+
+	var items []storage.KeyValue
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		if before.Shard(key) == "shard-0" {
+			items = append(items, storage.KeyValue{Key: key, Value: "v-" + key})
+		}
+	}
+
+	moves := PlanMoves("shard-0", map[string][]storage.KeyValue{"default": items}, after)
+
+	expected := 0
+	for _, item := range items {
+		if after.Shard(item.Key) != "shard-0" {
+			expected++
+		}
+	}
+	if len(moves) != expected {
+		t.Fatalf("Expected %d moves, got %d", expected, len(moves))
+	}
+	for _, move := range moves {
+		if move.From != "shard-0" || move.To != after.Shard(move.Key) {
+			t.Errorf("Move of %s from %s to %s does not match the ring", move.Key, move.From, move.To)
+		}
+		if move.Namespace != "default" || move.Value != "v-"+move.Key {
+			t.Errorf("Move of %s has namespace %s and value %s", move.Key, move.Namespace, move.Value)
+		}
+	}
+}
+
+// shardQueue answers requests with the result of a handler
+type shardQueue func(cmd commands.Command) commands.Result
+
+func (q shardQueue) Request(ctx context.Context, cmd commands.Command) (commands.Result, error) {
+	return q(cmd), nil
+}
+
+// TestMoveApply tests that a key is deleted from its old shard only after
+// the copy was applied
+func TestMoveApply(t *testing.T) {
+	move := Move{Namespace: "prices", Key: "a", Value: "1", From: "shard-0", To: "shard-1"}
+
+	var sent []string
+	record := func(shard string, result commands.Result) shardQueue {
+		return func(cmd commands.Command) commands.Result {
+			sent = append(sent, fmt.Sprintf("%s %s %s/%s", shard, cmd.GetType(), cmd.GetNamespace(), cmd.GetKey()))
+			return result
+		}
+	}
+
+	if err := move.Apply(context.Background(), record("shard-0", commands.Result{}), record("shard-1", commands.Result{})); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	want := []string{"shard-1 addItem prices/a", "shard-0 deleteItem prices/a"}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("Apply() sent %v, want %v", sent, want)
+	}
+
+	// A rejected copy keeps the key on the old shard
+	sent = nil
+	denied := commands.Result{Error: "access denied", Code: commands.CodeAccessDenied}
+	if err := move.Apply(context.Background(), record("shard-0", commands.Result{}), record("shard-1", denied)); err == nil {
+		t.Error("Apply() with a denied copy returned no error")
+	}
+	if want := []string{"shard-1 addItem prices/a"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("Apply() with a denied copy sent %v, want %v", sent, want)
+	}
+}
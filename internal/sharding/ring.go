@@ -0,0 +1,88 @@
+package sharding
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// virtualNodes is the number of points per shard on the ring. More points
+// spread keys more evenly between shards.
+const virtualNodes = 128
+
+// Ring assigns keys to shards with consistent hashing, so changing the number
+// of shards only moves the keys of the added or removed shards
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+	shards []string
+}
+
+// NewRing creates a ring for the given shard names
+func NewRing(shards []string) (*Ring, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("ring requires at least one shard")
+	}
+
+	r := &Ring{
+		owners: make(map[uint32]string),
+		shards: append([]string(nil), shards...),
+	}
+	for _, shard := range shards {
+		for i := 0; i < virtualNodes; i++ {
+			point := hash(shard + "#" + strconv.Itoa(i))
+			// On the rare collision the first shard keeps the point
+			if _, exists := r.owners[point]; exists {
+				continue
+			}
+			r.owners[point] = shard
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r, nil
+}
+
+// Shard returns the shard owning the key
+func (r *Ring) Shard(key string) string {
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Shards returns the shard names of the ring
+func (r *Ring) Shards() []string {
+	return append([]string(nil), r.shards...)
+}
+
+// ShardNames returns the names of count shards: shard-0, shard-1, ...
+func ShardNames(count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = "shard-" + strconv.Itoa(i)
+	}
+	return names
+}
+
+// QueueName returns the name of a per-shard queue or exchange
+func QueueName(base, shard string) string {
+	return base + "." + shard
+}
+
+// hash returns FNV-1a of s with the murmur3 finalizer applied, since plain
+// FNV-1a spreads similar short strings like "key1", "key2" unevenly
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
@@ -0,0 +1,62 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestRingDistribution tests that keys are spread over all shards
+func TestRingDistribution(t *testing.T) {
+	ring, err := NewRing(ShardNames(4))
+	if err != nil {
+		t.Fatalf("NewRing() error = %v", err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[ring.Shard(fmt.Sprintf("key%d", i))]++
+	}
+
+	for _, shard := range ShardNames(4) {
+		// An even split is 2500 keys per shard
+		if counts[shard] < 1500 || counts[shard] > 3500 {
+			t.Errorf("Expected about 2500 keys on %s, got %d", shard, counts[shard])
+		}
+	}
+}
+
+// TestRingStability tests that adding a shard only moves keys to the new shard
+func TestRingStability(t *testing.T) {
+	before, _ := NewRing(ShardNames(3))
+	after, _ := NewRing(ShardNames(4))
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		from, to := before.Shard(key), after.Shard(key)
+		if from == to {
+			continue
+		}
+		moved++
+		if to != "shard-3" {
+			t.Fatalf("Expected %s to move to shard-3, got %s", key, to)
+		}
+	}
+
+	// About a quarter of the keys belongs to the new shard
+	if moved < 1500 || moved > 3500 {
+		t.Errorf("Expected about 2500 moved keys, got %d", moved)
+	}
+}
+
+func TestNewRing_NoShards(t *testing.T) {
+	if _, err := NewRing(nil); err == nil {
+		t.Error("Expected error for ring without shards")
+	}
+}
+
+func TestQueueName(t *testing.T) {
+	if got := QueueName("write-commands", "shard-1"); got != "write-commands.shard-1" {
+		t.Errorf("QueueName() = %s, want write-commands.shard-1", got)
+	}
+}
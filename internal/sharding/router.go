@@ -0,0 +1,52 @@
+package sharding
+
+import (
+	"fmt"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// Router maps commands to the shards that have to execute them
+type Router struct {
+	ring     *Ring
+	registry commands.CommandRegistry
+}
+
+// NewRouter creates a router for the ring
+func NewRouter(ring *Ring, registry commands.CommandRegistry) *Router {
+	return &Router{
+		ring:     ring,
+		registry: registry,
+	}
+}
+
+// Route returns the command to send to each shard. Broadcast commands are
+// scattered to all shards and every shard writes its part of the result.
+func (r *Router) Route(cmd commands.Command) (map[string]commands.Command, error) {
+	switch r.registry.ShardingMode(cmd) {
+	case commands.ShardByKey:
+		return map[string]commands.Command{r.ring.Shard(cmd.GetKey()): cmd}, nil
+
+	case commands.ShardColocated:
+		shard := r.ring.Shard(cmd.GetKey())
+		for _, key := range cmd.GetArgs() {
+			if other := r.ring.Shard(key); other != shard {
+				return nil, fmt.Errorf("keys %s and %s are on different shards (%s, %s)", cmd.GetKey(), key, shard, other)
+			}
+		}
+		return map[string]commands.Command{shard: cmd}, nil
+
+	case commands.ShardSplitKeys:
+		return commands.SplitKeys(cmd, r.ring.Shard), nil
+
+	case commands.ShardBroadcast:
+		routes := make(map[string]commands.Command)
+		for _, shard := range r.ring.Shards() {
+			routes[shard] = cmd
+		}
+		return routes, nil
+
+	default:
+		return nil, fmt.Errorf("command %s is not supported with sharding", cmd.GetType())
+	}
+}
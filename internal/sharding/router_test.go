@@ -0,0 +1,88 @@
+package sharding
+
+import (
+	"fmt"
+	"testing"
+
+	"eoracle-client-server/internal/commands"
+)
+
+// keysOnShards returns two keys owned by different shards of the ring
+func keysOnShards(ring *Ring) (string, string) {
+	first := "key0"
+	for i := 1; ; i++ {
+		if key := fmt.Sprintf("key%d", i); ring.Shard(key) != ring.Shard(first) {
+			return first, key
+		}
+	}
+}
+
+func TestRouter_Route(t *testing.T) {
+	ring, _ := NewRing(ShardNames(3))
+	registry := commands.NewCommandRegistry()
+	router := NewRouter(ring, registry)
+	key1, key2 := keysOnShards(ring)
+
+	parse := func(line string) commands.Command {
+		cmd, err := registry.ParseCommand(line)
+		if err != nil {
+			t.Fatalf("ParseCommand(%q) error = %v", line, err)
+		}
+		return cmd
+	}
+
+	t.Run("by key", func(t *testing.T) {
+		routes, err := router.Route(parse("add " + key1 + " value"))
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if _, ok := routes[ring.Shard(key1)]; !ok || len(routes) != 1 {
+			t.Errorf("Expected route to %s only, got %v", ring.Shard(key1), routes)
+		}
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		routes, err := router.Route(parse("getall"))
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if len(routes) != 3 {
+			t.Errorf("Expected routes to 3 shards, got %d", len(routes))
+		}
+	})
+
+	t.Run("split keys", func(t *testing.T) {
+		routes, err := router.Route(parse("mget " + key1 + " " + key2))
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if len(routes) != 2 {
+			t.Fatalf("Expected routes to 2 shards, got %d", len(routes))
+		}
+		if args := routes[ring.Shard(key2)].GetArgs(); len(args) != 1 || args[0] != key2 {
+			t.Errorf("Expected %s on its own shard, got %v", key2, args)
+		}
+	})
+
+	t.Run("colocated on different shards", func(t *testing.T) {
+		if _, err := router.Route(parse("insertafter " + key1 + " " + key2 + " value")); err == nil {
+			t.Error("Expected error for insert relative to a key on another shard")
+		}
+	})
+
+	t.Run("colocated on same shard", func(t *testing.T) {
+		routes, err := router.Route(parse("insertafter " + key1 + " " + key1 + " value"))
+		if err != nil {
+			t.Fatalf("Route() error = %v", err)
+		}
+		if len(routes) != 1 {
+			t.Errorf("Expected route to 1 shard, got %d", len(routes))
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, err := router.Route(parse("popfirst")); err == nil {
+			t.Error("Expected error for popfirst with sharding")
+		}
+	})
+}
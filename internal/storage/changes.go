@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"path"
+	"sync"
+	"time"
+)
+
+// ChangeOp is the kind of mutation recorded by a ChangeEvent
+type ChangeOp string
+
+const (
+	OpAdd    ChangeOp = "add"
+	OpUpdate ChangeOp = "update"
+	OpDelete ChangeOp = "delete"
+	OpEvict  ChangeOp = "evict"
+	// OpMove reorders an existing key, see Position and Mark
+	OpMove ChangeOp = "move"
+	// OpDrop removes a whole namespace, the key is empty
+	OpDrop ChangeOp = "drop"
+)
+
+// Position is where an OpMove places its key
+type Position string
+
+const (
+	PositionFront  Position = "front"
+	PositionBack   Position = "back"
+	PositionBefore Position = "before"
+	PositionAfter  Position = "after"
+)
+
+// ChangeEvent describes a single applied mutation of a namespace. Events are
+// state based: replaying an event that was already applied is harmless.
+type ChangeEvent struct {
+	Op        ChangeOp  `json:"op"`
+	Namespace string    `json:"namespace"`
+	Key       string    `json:"key"`
+	OldValue  string    `json:"old_value,omitempty"`
+	NewValue  string    `json:"new_value,omitempty"`
+	Position  Position  `json:"position,omitempty"`
+	Mark      string    `json:"mark,omitempty"`
+	Epoch     int64     `json:"epoch"`
+	Version   uint64    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Matches reports whether the event belongs to the namespace and its key
+// matches the glob pattern (see path.Match), e.g. "user:*"
+func (e ChangeEvent) Matches(namespace, pattern string) bool {
+	if normalizeNamespace(e.Namespace) != normalizeNamespace(namespace) {
+		return false
+	}
+	matched, err := path.Match(pattern, e.Key)
+	return err == nil && matched
+}
+
+// ChangeFeed fans out change events to in-process subscribers. Events are
+// emitted while the mutated map holds its write lock, so versions follow the
+// order in which writes are applied. Delivery never blocks writers: a
+// subscriber whose buffer is full is disconnected, so one slow subscriber
+// cannot stall every writer.
+type ChangeFeed struct {
+	mu          sync.Mutex
+	epoch       int64
+	version     uint64
+	subscribers map[int]chan ChangeEvent
+	nextID      int
+}
+
+// NewChangeFeed creates a change feed without subscribers. Versions restart
+// with every feed, so events carry the feed creation time as epoch.
+func NewChangeFeed() *ChangeFeed {
+	return &ChangeFeed{
+		epoch:       time.Now().UnixNano(),
+		subscribers: make(map[int]chan ChangeEvent),
+	}
+}
+
+// Subscribe returns a channel receiving all future events and a function that
+// cancels the subscription and closes the channel. The buffer bounds how far
+// the subscriber may fall behind: once it is full the subscriber is
+// disconnected and the channel closed; it missed events then and has to read
+// the state again.
+func (f *ChangeFeed) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	id := f.nextID
+	f.nextID++
+	events := make(chan ChangeEvent, buffer)
+	f.subscribers[id] = events
+
+	cancel := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		// A disconnected subscriber is already closed
+		if _, ok := f.subscribers[id]; ok {
+			delete(f.subscribers, id)
+			close(events)
+		}
+	}
+	return events, cancel
+}
+
+// Version returns the version of the last emitted event
+func (f *ChangeFeed) Version() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.version
+}
+
+// Epoch identifies the feed instance the versions belong to
+func (f *ChangeFeed) Epoch() int64 {
+	return f.epoch
+}
+
+// emit assigns the next version to the event and delivers it to subscribers
+func (f *ChangeFeed) emit(event ChangeEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.version++
+	event.Epoch = f.epoch
+	event.Version = f.version
+	event.Timestamp = time.Now().UTC()
+	for id, events := range f.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(f.subscribers, id)
+			close(events)
+		}
+	}
+}
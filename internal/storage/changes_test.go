@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// collect drains n events from the channel
+func collect(t *testing.T, events <-chan ChangeEvent, n int) []ChangeEvent {
+	t.Helper()
+	result := make([]ChangeEvent, 0, n)
+	for i := 0; i < n; i++ {
+		result = append(result, <-events)
+	}
+	return result
+}
+
+// TestChangeFeedEvents tests that mutations emit events with old and new values
+func TestChangeFeedEvents(t *testing.T) {
+	feed := NewChangeFeed()
+	events, cancel := feed.Subscribe(16)
+	defer cancel()
+
+	ns := NewNamespaces(Limits{MaxEntries: 2}, feed)
+	store := ns.Namespace("team")
+	store.Add("key1", "value1")
+	store.Add("key1", "value2")
+	store.Add("key2", "value2")
+	store.Delete("key2")
+	store.Add("key3", "value3")
+	store.Add("key4", "value4")
+
+	// Reads do not emit events, reordering does
+	store.Get("key1")
+	store.MoveToFront("key4")
+	ns.Drop("team")
+
+	got := collect(t, events, 6)
+	type change struct {
+		op                  ChangeOp
+		key, oldVal, newVal string
+	}
+	want := []change{
+		{OpAdd, "key1", "", "value1"},
+		{OpUpdate, "key1", "value1", "value2"},
+		{OpAdd, "key2", "", "value2"},
+		{OpDelete, "key2", "value2", ""},
+		{OpAdd, "key3", "", "value3"},
+		{OpEvict, "key1", "value2", ""},
+	}
+	for i, event := range got {
+		c := change{event.Op, event.Key, event.OldValue, event.NewValue}
+		if !reflect.DeepEqual(c, want[i]) {
+			t.Errorf("Event %d = %+v, want %+v", i, c, want[i])
+		}
+		if event.Namespace != "team" {
+			t.Errorf("Event %d namespace = %s, want team", i, event.Namespace)
+		}
+		if event.Version != uint64(i+1) {
+			t.Errorf("Event %d version = %d, want %d", i, event.Version, i+1)
+		}
+		if event.Epoch != feed.Epoch() {
+			t.Errorf("Event %d epoch = %d, want %d", i, event.Epoch, feed.Epoch())
+		}
+		if event.Timestamp.IsZero() {
+			t.Errorf("Event %d has no timestamp", i)
+		}
+	}
+
+	// The add of key4 follows the eviction
+	if event := <-events; event.Op != OpAdd || event.Key != "key4" {
+		t.Errorf("Expected add of key4, got %+v", event)
+	}
+	if event := <-events; event.Op != OpMove || event.Key != "key4" || event.Position != PositionFront {
+		t.Errorf("Expected move of key4 to front, got %+v", event)
+	}
+	if event := <-events; event.Op != OpDrop || event.Namespace != "team" {
+		t.Errorf("Expected drop of team, got %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Unexpected event %+v", event)
+	default:
+	}
+}
+
+// TestChangeFeedOrdering tests that versions follow the order of applied writes
+func TestChangeFeedOrdering(t *testing.T) {
+	feed := NewChangeFeed()
+	events, cancel := feed.Subscribe(1000)
+	defer cancel()
+
+	om := NewNamespaces(Limits{}, feed).Namespace("")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				om.Update("counter", func(current string, exists bool) (string, error) {
+					return current + "x", nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every event must see the value produced by the previous one
+	previous := ""
+	for i, event := range collect(t, events, 500) {
+		if event.Version != uint64(i+1) {
+			t.Fatalf("Event %d version = %d, want %d", i, event.Version, i+1)
+		}
+		if event.OldValue != previous {
+			t.Fatalf("Event %d old value length = %d, want %d", i, len(event.OldValue), len(previous))
+		}
+		previous = event.NewValue
+	}
+}
+
+// TestChangeFeedCancel tests that cancelling closes the channel and stops
+// the delivery
+func TestChangeFeedCancel(t *testing.T) {
+	feed := NewChangeFeed()
+	events, cancel := feed.Subscribe(1)
+
+	cancel()
+	NewNamespaces(Limits{}, feed).Namespace("").Add("key1", "value1")
+
+	if _, ok := <-events; ok {
+		t.Error("Expected events channel to be closed")
+	}
+	if feed.Version() != 1 {
+		t.Errorf("Expected version 1, got %d", feed.Version())
+	}
+	cancel()
+}
+
+// TestChangeFeedSlowSubscriber tests that a subscriber that stops reading is
+// disconnected instead of blocking writers and other subscribers
+func TestChangeFeedSlowSubscriber(t *testing.T) {
+	feed := NewChangeFeed()
+	slow, cancelSlow := feed.Subscribe(1)
+	fast, cancelFast := feed.Subscribe(16)
+	defer cancelFast()
+
+	store := NewNamespaces(Limits{}, feed).Namespace("")
+	for _, key := range []string{"key1", "key2", "key3"} {
+		store.Add(key, "value")
+	}
+
+	if got := collect(t, fast, 3); got[2].Version != 3 {
+		t.Errorf("Expected 3 events for the fast subscriber, got %+v", got)
+	}
+	if event := <-slow; event.Key != "key1" {
+		t.Errorf("Expected the buffered key1 event, got %+v", event)
+	}
+	if _, ok := <-slow; ok {
+		t.Error("Expected the slow subscriber to be disconnected")
+	}
+	cancelSlow()
+}
+
+// TestChangeEventMatches tests namespace and key pattern matching
+func TestChangeEventMatches(t *testing.T) {
+	event := ChangeEvent{Namespace: DefaultNamespace, Key: "user:1"}
+
+	tests := []struct {
+		namespace string
+		pattern   string
+		want      bool
+	}{
+		{"", "user:1", true},
+		{DefaultNamespace, "user:*", true},
+		{"", "user:?", true},
+		{"", "user:2", false},
+		{"", "order:*", false},
+		{"other", "user:*", false},
+		{"", "[", false},
+	}
+	for _, tt := range tests {
+		if got := event.Matches(tt.namespace, tt.pattern); got != tt.want {
+			t.Errorf("Matches(%q, %q) = %v, want %v", tt.namespace, tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"log"
+
+	"eoracle-client-server/internal/compress"
+)
+
+// newNode creates a detached node, compressing the value if configured
+func (om *OrderedMap) newNode(key, value string) *node {
+	stored, compressed := om.pack(value)
+	return &node{key: key, value: stored, compressed: compressed}
+}
+
+// pack returns the stored form of a value. Values below the threshold and
+// values that do not shrink are stored as they are.
+func (om *OrderedMap) pack(value string) (string, bool) {
+	if om.limits.CompressValues <= 0 || len(value) < om.limits.CompressValues {
+		return value, false
+	}
+	compressed := compress.Compress([]byte(value))
+	if len(compressed) >= len(value) {
+		return value, false
+	}
+	return string(compressed), true
+}
+
+// load returns the value of a node, decompressing it if needed. A value
+// that fails to decompress, e.g. after memory corruption, is logged and
+// served as stored rather than taking the server down.
+func (n *node) load() string {
+	if !n.compressed {
+		return n.value
+	}
+	value, err := compress.Decompress([]byte(n.value), 0)
+	if err != nil {
+		log.Printf("Failed to decompress value of %s, serving the stored bytes: %v", n.key, err)
+		return n.value
+	}
+	return string(value)
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestCompressValues tests that large values are stored compressed and read
+// back unchanged through every operation
+func TestCompressValues(t *testing.T) {
+	large := strings.Repeat("price:42.0;", 100)
+	om := NewOrderedMapWithLimits(Limits{CompressValues: 64})
+
+	om.Add("small", "value")
+	om.Add("large", large)
+	if !om.InsertAfter("small", "inserted", large+"1") {
+		t.Fatal("Expected InsertAfter to succeed")
+	}
+	if !om.data["large"].compressed || om.data["small"].compressed {
+		t.Error("Expected only values above the threshold to be compressed")
+	}
+	if stats := om.MemoryStats(); stats.MemoryBytes >= int64(len(large)) {
+		t.Errorf("Expected compressed memory usage, got %d bytes", stats.MemoryBytes)
+	}
+
+	if got, _ := om.Get("large"); got != large {
+		t.Errorf("Get() returned %d bytes, want %d", len(got), len(large))
+	}
+	updated, err := om.Update("large", func(current string, exists bool) (string, error) {
+		return current + "2", nil
+	})
+	if err != nil || updated != large+"2" {
+		t.Errorf("Update() = %d bytes, %v", len(updated), err)
+	}
+	want := []KeyValue{{"small", "value"}, {"inserted", large + "1"}, {"large", large + "2"}}
+	if got := om.GetAll(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAll() returned %d entries differing from the values added", len(got))
+	}
+
+	// Shrinking below the threshold stores the value as it is
+	om.Add("large", "v")
+	if om.data["large"].compressed {
+		t.Error("Expected small value to be stored uncompressed")
+	}
+	if kv, _ := om.PopFirst(); kv.Value != "value" {
+		t.Errorf("PopFirst() = %v", kv)
+	}
+	if kv, _ := om.PopFirst(); kv.Value != large+"1" {
+		t.Errorf("PopFirst() returned %d bytes, want %d", len(kv.Value), len(large)+1)
+	}
+	om.PopFirst()
+	if stats := om.MemoryStats(); stats.MemoryBytes != 0 {
+		t.Errorf("Expected empty accounting, got %+v", stats)
+	}
+}
+
+// TestCompressValuesChangeFeed tests that change events carry plain values
+func TestCompressValuesChangeFeed(t *testing.T) {
+	feed := NewChangeFeed()
+	events, cancel := feed.Subscribe(4)
+	defer cancel()
+
+	large := strings.Repeat("a", 1000)
+	store := NewNamespaces(Limits{CompressValues: 64}, feed).Namespace("prices")
+	store.Add("key", large)
+	store.Add("key", large+"b")
+	store.Delete("key")
+
+	got := collect(t, events, 3)
+	if got[0].NewValue != large || got[1].OldValue != large || got[1].NewValue != large+"b" || got[2].OldValue != large+"b" {
+		t.Error("Expected change events with decompressed values")
+	}
+}
+
+// TestCompressValuesCorrupt tests that a value that fails to decompress is
+// served as stored
+func TestCompressValuesCorrupt(t *testing.T) {
+	om := NewOrderedMapWithLimits(Limits{CompressValues: 64})
+	om.Add("large", strings.Repeat("price:42.0;", 100))
+	om.data["large"].value = "corrupt"
+
+	if got, ok := om.Get("large"); !ok || got != "corrupt" {
+		t.Errorf("Get() = %q, %v, want the stored bytes", got, ok)
+	}
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EvictionPolicy selects which entry is removed when a limit is reached
+type EvictionPolicy string
+
+const (
+	// EvictOldest removes the first entry in insertion order
+	EvictOldest EvictionPolicy = "oldest"
+	// EvictLRU moves entries to the back on access and removes the first one
+	EvictLRU EvictionPolicy = "lru"
+	// EvictLFU removes the least frequently used entry
+	EvictLFU EvictionPolicy = "lfu"
+)
+
+// nodeOverhead approximates the memory used by a node besides key and value
+const nodeOverhead = 96
+
+// Limits bounds the size of an OrderedMap, zero values mean unlimited
+type Limits struct {
+	MaxEntries int
+	MaxMemory  int64
+	Policy     EvictionPolicy
+	// CompressValues keeps values of at least this many bytes gzip
+	// compressed, 0 to disable. The memory limit counts compressed sizes.
+	CompressValues int
+}
+
+// MemoryStats describes the memory usage and evictions of an OrderedMap
+type MemoryStats struct {
+	Entries     int
+	MemoryBytes int64
+	MaxEntries  int
+	MaxMemory   int64
+	Policy      EvictionPolicy
+	Evictions   uint64
+}
+
+// ParseEvictionPolicy converts a policy name to an EvictionPolicy
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch policy := EvictionPolicy(strings.ToLower(name)); policy {
+	case EvictOldest, EvictLRU, EvictLFU:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy: %s", name)
+	}
+}
+
+// entrySize returns the approximate memory used by an entry
+func entrySize(key, value string) int64 {
+	return int64(len(key)+len(value)) + nodeOverhead
+}
+
+// evictor tracks entries for an eviction policy. All methods are called with
+// the OrderedMap write lock held.
+type evictor interface {
+	added(n *node)
+	accessed(n *node)
+	removed(n *node)
+	victim() *node
+}
+
+// orderEvictor evicts the head of the insertion order list. With moveOnAccess
+// set accessed entries are moved to the back, which turns it into an LRU.
+type orderEvictor struct {
+	om           *OrderedMap
+	moveOnAccess bool
+}
+
+func (e *orderEvictor) added(n *node) {}
+
+// accessed keeps recency off the change feed: reads would otherwise turn
+// into events for replicas, standbys and watchers
+func (e *orderEvictor) accessed(n *node) {
+	if e.moveOnAccess && e.om.tail != n {
+		e.om.unlink(n)
+		e.om.pushBack(n)
+	}
+}
+
+func (e *orderEvictor) removed(n *node) {}
+
+func (e *orderEvictor) victim() *node {
+	return e.om.head
+}
+
+// freqList is a doubly-linked list of nodes sharing the same use count
+type freqList struct {
+	head *node
+	tail *node
+}
+
+// lfuEvictor keeps nodes in per-frequency buckets for O(1) LFU eviction.
+// Within a bucket the least recently used node is evicted first.
+type lfuEvictor struct {
+	buckets map[int]*freqList
+	minFreq int
+}
+
+func newLFUEvictor() *lfuEvictor {
+	return &lfuEvictor{
+		buckets: make(map[int]*freqList),
+	}
+}
+
+func (e *lfuEvictor) added(n *node) {
+	n.freq = 1
+	e.push(n)
+	e.minFreq = 1
+}
+
+func (e *lfuEvictor) accessed(n *node) {
+	e.remove(n)
+	if e.buckets[n.freq] == nil && e.minFreq == n.freq {
+		e.minFreq++
+	}
+	n.freq++
+	e.push(n)
+}
+
+func (e *lfuEvictor) removed(n *node) {
+	e.remove(n)
+	if e.buckets[n.freq] == nil && e.minFreq == n.freq {
+		e.recalcMinFreq()
+	}
+}
+
+func (e *lfuEvictor) victim() *node {
+	if list := e.buckets[e.minFreq]; list != nil {
+		return list.head
+	}
+	return nil
+}
+
+// push appends the node to the bucket of its frequency
+func (e *lfuEvictor) push(n *node) {
+	list := e.buckets[n.freq]
+	if list == nil {
+		list = &freqList{}
+		e.buckets[n.freq] = list
+	}
+
+	n.freqPrev = list.tail
+	n.freqNext = nil
+	if list.tail != nil {
+		list.tail.freqNext = n
+	} else {
+		list.head = n
+	}
+	list.tail = n
+}
+
+// remove detaches the node from its bucket, dropping empty buckets
+func (e *lfuEvictor) remove(n *node) {
+	list := e.buckets[n.freq]
+	if list == nil {
+		return
+	}
+
+	if n.freqPrev != nil {
+		n.freqPrev.freqNext = n.freqNext
+	} else {
+		list.head = n.freqNext
+	}
+	if n.freqNext != nil {
+		n.freqNext.freqPrev = n.freqPrev
+	} else {
+		list.tail = n.freqPrev
+	}
+	n.freqPrev = nil
+	n.freqNext = nil
+
+	if list.head == nil {
+		delete(e.buckets, n.freq)
+	}
+}
+
+// recalcMinFreq finds the lowest non-empty bucket. It only runs when the
+// minimum bucket is emptied by a removal, not on the hot access path.
+func (e *lfuEvictor) recalcMinFreq() {
+	e.minFreq = 0
+	for freq := range e.buckets {
+		if e.minFreq == 0 || freq < e.minFreq {
+			e.minFreq = freq
+		}
+	}
+}
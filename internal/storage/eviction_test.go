@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseEvictionPolicy tests policy name validation
+func TestParseEvictionPolicy(t *testing.T) {
+	for _, name := range []string{"oldest", "LRU", "lfu"} {
+		if _, err := ParseEvictionPolicy(name); err != nil {
+			t.Errorf("ParseEvictionPolicy(%q) returned error: %v", name, err)
+		}
+	}
+	if _, err := ParseEvictionPolicy("random"); err == nil {
+		t.Error("Expected error for unknown eviction policy")
+	}
+}
+
+// TestEvictOldest tests that the first inserted entry is evicted
+func TestEvictOldest(t *testing.T) {
+	om := NewOrderedMapWithLimits(Limits{MaxEntries: 2, Policy: EvictOldest})
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Get("key1")
+	om.Add("key3", "value3")
+
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key2", "key3"}) {
+		t.Errorf("Expected [key2 key3] after eviction, got %v", keys)
+	}
+	if stats := om.MemoryStats(); stats.Evictions != 1 {
+		t.Errorf("Expected 1 eviction, got %d", stats.Evictions)
+	}
+}
+
+// TestEvictLRU tests that accessed entries are moved back and survive eviction
+func TestEvictLRU(t *testing.T) {
+	om := NewOrderedMapWithLimits(Limits{MaxEntries: 2, Policy: EvictLRU})
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Get("key1")
+	om.Add("key3", "value3")
+
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key1", "key3"}) {
+		t.Errorf("Expected [key1 key3] after eviction, got %v", keys)
+	}
+}
+
+// TestEvictLRU_NoEvents tests that reads do not reach the change feed
+func TestEvictLRU_NoEvents(t *testing.T) {
+	feed := NewChangeFeed()
+	events, cancel := feed.Subscribe(16)
+	defer cancel()
+
+	store := NewNamespaces(Limits{Policy: EvictLRU}, feed).Namespace("team")
+	store.Add("key1", "value1")
+	store.Add("key2", "value2")
+	store.Get("key1")
+
+	if version := feed.Version(); version != 2 {
+		t.Errorf("Version() = %d, want 2", version)
+	}
+	for _, event := range collect(t, events, 2) {
+		if event.Op != OpAdd {
+			t.Errorf("Expected only add events, got %+v", event)
+		}
+	}
+}
+
+// TestEvictLFU tests that the least frequently used entry is evicted
+func TestEvictLFU(t *testing.T) {
+	om := NewOrderedMapWithLimits(Limits{MaxEntries: 3, Policy: EvictLFU})
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Add("key3", "value3")
+	om.Get("key1")
+	om.Get("key1")
+	om.Get("key3")
+
+	// key2 has the lowest frequency
+	om.Add("key4", "value4")
+	if _, exists := om.Get("key2"); exists {
+		t.Error("Expected key2 to be evicted")
+	}
+
+	// key4 is now the only entry used once
+	om.Add("key5", "value5")
+	if _, exists := om.Get("key4"); exists {
+		t.Error("Expected key4 to be evicted")
+	}
+
+	// Insertion order is kept for LFU
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key1", "key3", "key5"}) {
+		t.Errorf("Expected [key1 key3 key5], got %v", keys)
+	}
+	if stats := om.MemoryStats(); stats.Evictions != 2 {
+		t.Errorf("Expected 2 evictions, got %d", stats.Evictions)
+	}
+}
+
+// TestEvictLFUAfterDelete tests that deleting entries keeps LFU buckets consistent
+func TestEvictLFUAfterDelete(t *testing.T) {
+	om := NewOrderedMapWithLimits(Limits{MaxEntries: 2, Policy: EvictLFU})
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Get("key2")
+	om.Delete("key1")
+	om.PopFirst()
+	om.Add("key3", "value3")
+	om.Add("key4", "value4")
+	om.Add("key5", "value5")
+
+	if om.Size() != 2 {
+		t.Errorf("Expected size 2, got %d", om.Size())
+	}
+	if _, exists := om.Get("key5"); !exists {
+		t.Error("Expected newest key5 to be present")
+	}
+}
+
+// TestEvictMaxMemory tests eviction by approximate memory usage
+func TestEvictMaxMemory(t *testing.T) {
+	limit := 2*entrySize("key1", "value1") + 1
+	om := NewOrderedMapWithLimits(Limits{MaxMemory: limit})
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Add("key3", "value3")
+
+	stats := om.MemoryStats()
+	if stats.Entries != 2 {
+		t.Errorf("Expected 2 entries, got %d", stats.Entries)
+	}
+	if stats.MemoryBytes > limit {
+		t.Errorf("Expected memory <= %d, got %d", limit, stats.MemoryBytes)
+	}
+
+	// Growing a value evicts others but never the updated entry itself
+	om.Add("key3", "a much longer value than before")
+	if _, exists := om.Get("key3"); !exists {
+		t.Error("Expected updated key3 to be present")
+	}
+	if _, exists := om.Get("key2"); exists {
+		t.Error("Expected key2 to be evicted")
+	}
+}
+
+// TestMemoryAccounting tests that memory is released on removal
+func TestMemoryAccounting(t *testing.T) {
+	om := NewOrderedMap()
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Add("key1", "v")
+	om.Delete("key2")
+	om.PopFirst()
+
+	if stats := om.MemoryStats(); stats.MemoryBytes != 0 || stats.Entries != 0 {
+		t.Errorf("Expected empty accounting, got %+v", stats)
+	}
+}
+
+// TestEvictInsertKeepsMark tests that positional inserts never evict their mark
+func TestEvictInsertKeepsMark(t *testing.T) {
+	om := NewOrderedMapWithLimits(Limits{MaxEntries: 1})
+
+	om.Add("key1", "value1")
+	if !om.InsertAfter("key1", "key2", "value2") {
+		t.Fatal("Expected InsertAfter to succeed")
+	}
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key1", "key2"}) {
+		t.Errorf("Expected [key1 key2], got %v", keys)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultNamespace is used for commands that do not carry a namespace
+const DefaultNamespace = "default"
+
+// Namespaces manages named, isolated storages
+type Namespaces interface {
+	Namespace(name string) Storage
+	Lookup(name string) (Storage, bool)
+	Drop(name string) bool
+	Names() []string
+}
+
+// namespaces is a thread-safe registry of ordered maps created on first use
+type namespaces struct {
+	mu     sync.RWMutex
+	data   map[string]*OrderedMap
+	limits Limits
+	feed   *ChangeFeed
+}
+
+// NewNamespaces creates an empty namespace registry. Every namespace gets
+// its own OrderedMap bounded by the limits. Mutations of all namespaces are
+// reported to the feed unless it is nil.
+func NewNamespaces(limits Limits, feed *ChangeFeed) Namespaces {
+	return &namespaces{
+		data:   make(map[string]*OrderedMap),
+		limits: limits,
+		feed:   feed,
+	}
+}
+
+// Namespace returns the storage for the namespace, creating it if needed
+func (n *namespaces) Namespace(name string) Storage {
+	name = normalizeNamespace(name)
+
+	n.mu.RLock()
+	om, exists := n.data[name]
+	n.mu.RUnlock()
+	if exists {
+		return om
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Another goroutine may have created it between the locks
+	if om, exists := n.data[name]; exists {
+		return om
+	}
+	om = NewOrderedMapWithLimits(n.limits)
+	om.namespace = name
+	om.feed = n.feed
+	n.data[name] = om
+	return om
+}
+
+// Lookup returns the storage for the namespace without creating it
+func (n *namespaces) Lookup(name string) (Storage, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	om, exists := n.data[normalizeNamespace(name)]
+	if !exists {
+		return nil, false
+	}
+	return om, true
+}
+
+// Drop removes the namespace and all its data. Holders of its storage can
+// no longer change it, see ErrDropped.
+func (n *namespaces) Drop(name string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	name = normalizeNamespace(name)
+	om, exists := n.data[name]
+	if !exists {
+		return false
+	}
+	om.drop()
+	delete(n.data, name)
+	if n.feed != nil {
+		n.feed.emit(ChangeEvent{Op: OpDrop, Namespace: name})
+	}
+	return true
+}
+
+// Names returns the names of all existing namespaces in sorted order
+func (n *namespaces) Names() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	names := make([]string, 0, len(n.data))
+	for name := range n.data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dropped tells whether the storage belongs to a dropped namespace. Only
+// storages of Namespaces can be dropped.
+func Dropped(s Storage) bool {
+	d, ok := s.(interface{ Dropped() bool })
+	return ok && d.Dropped()
+}
+
+func normalizeNamespace(name string) string {
+	if name == "" {
+		return DefaultNamespace
+	}
+	return name
+}
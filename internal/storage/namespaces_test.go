@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// TestNamespaceIsolation tests that namespaces do not share keys
+func TestNamespaceIsolation(t *testing.T) {
+	ns := NewNamespaces(Limits{}, nil)
+
+	ns.Namespace("a").Add("key1", "value-a")
+	ns.Namespace("b").Add("key1", "value-b")
+
+	if val, _ := ns.Namespace("a").Get("key1"); val != "value-a" {
+		t.Errorf("Expected key1=value-a in namespace a, got %s", val)
+	}
+	if val, _ := ns.Namespace("b").Get("key1"); val != "value-b" {
+		t.Errorf("Expected key1=value-b in namespace b, got %s", val)
+	}
+}
+
+// TestNamespaceDefault tests that an empty name maps to the default namespace
+func TestNamespaceDefault(t *testing.T) {
+	ns := NewNamespaces(Limits{}, nil)
+
+	ns.Namespace("").Add("key1", "value1")
+	store, exists := ns.Lookup(DefaultNamespace)
+	if !exists {
+		t.Fatal("Expected default namespace to exist")
+	}
+	if val, _ := store.Get("key1"); val != "value1" {
+		t.Errorf("Expected key1=value1 in default namespace, got %s", val)
+	}
+}
+
+// TestNamespaceLookupAndDrop tests lookup without creation and dropping
+func TestNamespaceLookupAndDrop(t *testing.T) {
+	ns := NewNamespaces(Limits{}, nil)
+
+	if _, exists := ns.Lookup("missing"); exists {
+		t.Error("Expected Lookup to not create namespace")
+	}
+	if ns.Drop("missing") {
+		t.Error("Expected Drop to return false for non-existent namespace")
+	}
+
+	ns.Namespace("b").Add("key1", "value1")
+	ns.Namespace("a")
+	if names := ns.Names(); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Expected names [a b], got %v", names)
+	}
+
+	if !ns.Drop("b") {
+		t.Error("Expected Drop to return true for existing namespace")
+	}
+	if _, exists := ns.Lookup("b"); exists {
+		t.Error("Expected namespace b to be dropped")
+	}
+	if size := ns.Namespace("b").Size(); size != 0 {
+		t.Errorf("Expected recreated namespace to be empty, got size %d", size)
+	}
+}
+
+// TestNamespaceConcurrentCreate tests that concurrent first use creates a single map
+func TestNamespaceConcurrentCreate(t *testing.T) {
+	ns := NewNamespaces(Limits{}, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ns.Namespace("shared").Add("key"+string(rune('a'+i%26)), "value")
+		}(i)
+	}
+	wg.Wait()
+
+	if size := ns.Namespace("shared").Size(); size != 26 {
+		t.Errorf("Expected 26 keys in shared namespace, got %d", size)
+	}
+}
+
+// TestNamespaceDroppedStorage tests that holders of a dropped namespace can
+// no longer change it
+func TestNamespaceDroppedStorage(t *testing.T) {
+	feed := NewChangeFeed()
+	ns := NewNamespaces(Limits{}, feed)
+	store := ns.Namespace("a")
+	store.Add("key1", "value1")
+	store.Add("key2", "value2")
+	ns.Drop("a")
+
+	events, cancel := feed.Subscribe(16)
+	defer cancel()
+
+	store.Add("key3", "value3")
+	if _, err := store.Update("key1", func(string, bool) (string, error) { return "x", nil }); !errors.Is(err, ErrDropped) {
+		t.Errorf("Expected ErrDropped from Update, got %v", err)
+	}
+	if store.Delete("key1") || store.MoveToFront("key2") || store.MoveToBack("key1") ||
+		store.InsertBefore("key1", "key4", "v") || store.InsertAfter("key1", "key4", "v") {
+		t.Error("Expected mutations of a dropped namespace to fail")
+	}
+	if _, ok := store.PopFirst(); ok {
+		t.Error("Expected PopFirst of a dropped namespace to fail")
+	}
+	if !Dropped(store) || Dropped(ns.Namespace("a")) {
+		t.Error("Expected only the old storage to be dropped")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected no events, got %+v", event)
+	default:
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+// Observable is implemented by storages that can report the changes made
+// through a view, e.g. to audit the changes of one command
+type Observable interface {
+	// Observe returns a view of the storage that reports the changes made
+	// through it to observe, including the evictions they cause. observe is
+	// called under the write lock, so old values are those replaced.
+	Observe(observe func(ChangeEvent)) Storage
+}
+
+// Observe returns a view of the map reporting its changes to observe
+func (om *OrderedMap) Observe(observe func(ChangeEvent)) Storage {
+	return &observedMap{OrderedMap: om, observe: observe}
+}
+
+// observedMap sets the observer of the map while its mutations hold the
+// write lock. Reads pass through.
+type observedMap struct {
+	*OrderedMap
+	observe func(ChangeEvent)
+}
+
+// lock takes the write lock with the observer set, until unlock is called
+func (m *observedMap) lock() (unlock func()) {
+	m.mu.Lock()
+	m.observer = m.observe
+	return func() {
+		m.observer = nil
+		m.mu.Unlock()
+	}
+}
+
+func (m *observedMap) Add(key, value string) {
+	defer m.lock()()
+	m.add(key, value)
+}
+
+func (m *observedMap) Update(key string, fn UpdateFunc) (string, error) {
+	defer m.lock()()
+	return m.updateKey(key, fn)
+}
+
+func (m *observedMap) Delete(key string) bool {
+	defer m.lock()()
+	return m.delete(key)
+}
+
+func (m *observedMap) MoveToFront(key string) bool {
+	defer m.lock()()
+	return m.moveToFront(key)
+}
+
+func (m *observedMap) MoveToBack(key string) bool {
+	defer m.lock()()
+	return m.moveToBack(key)
+}
+
+func (m *observedMap) InsertBefore(mark, key, value string) bool {
+	defer m.lock()()
+	return m.insertBefore(mark, key, value)
+}
+
+func (m *observedMap) InsertAfter(mark, key, value string) bool {
+	defer m.lock()()
+	return m.insertAfter(mark, key, value)
+}
+
+func (m *observedMap) PopFirst() (KeyValue, bool) {
+	defer m.lock()()
+	return m.pop(m.head)
+}
+
+func (m *observedMap) PopLast() (KeyValue, bool) {
+	defer m.lock()()
+	return m.pop(m.tail)
+}
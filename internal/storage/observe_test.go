@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestOrderedMap_Observe(t *testing.T) {
+	om := NewOrderedMap()
+	om.Add("a", "1")
+
+	var events []ChangeEvent
+	view := om.Observe(func(event ChangeEvent) {
+		events = append(events, event)
+	})
+	view.Add("a", "2")
+	view.InsertBefore("a", "b", "3")
+	view.Delete("missing")
+	view.PopLast()
+	om.Add("c", "4") // not through the view
+
+	want := []ChangeEvent{
+		{Op: OpUpdate, Key: "a", OldValue: "1", NewValue: "2"},
+		{Op: OpAdd, Key: "b", NewValue: "3"},
+		{Op: OpMove, Key: "b", Position: PositionBefore, Mark: "a"},
+		{Op: OpDelete, Key: "a", OldValue: "2"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Observed %+v, want %+v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+}
+
+// TestOrderedMap_ObserveConcurrent tests that every update observes the
+// value it replaced while other writers change the key
+func TestOrderedMap_ObserveConcurrent(t *testing.T) {
+	om := NewOrderedMap()
+	om.Add("counter", "0")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			view := om.Observe(func(event ChangeEvent) {
+				old, _ := strconv.Atoi(event.OldValue)
+				if event.NewValue != strconv.Itoa(old+1) {
+					t.Errorf("Observed %s -> %s", event.OldValue, event.NewValue)
+				}
+			})
+			for j := 0; j < 100; j++ {
+				view.Update("counter", func(current string, _ bool) (string, error) {
+					n, _ := strconv.Atoi(current)
+					return strconv.Itoa(n + 1), nil
+				})
+				om.Add("other", strconv.Itoa(j))
+			}
+		}()
+	}
+	wg.Wait()
+}
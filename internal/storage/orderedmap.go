@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"errors"
 	"sync"
 )
 
+// ErrDropped is returned by updates of a map whose namespace was dropped.
+// Its other mutations change nothing and report nothing.
+var ErrDropped = errors.New("namespace was dropped")
+
 // OrderedMap represents a thread-safe ordered map with O(1) operations
 type OrderedMap struct {
 	mu   sync.RWMutex
@@ -11,13 +16,35 @@ type OrderedMap struct {
 	head *node
 	tail *node
 	size int
+
+	limits      Limits
+	evictor     evictor
+	trackAccess bool
+	memory      int64
+	evictions   uint64
+
+	// Mutations are reported to the feed when set, and to the observer of
+	// the view holding the write lock, see Observe
+	namespace string
+	feed      *ChangeFeed
+	observer  func(ChangeEvent)
+
+	// dropped is set when the namespace is dropped, see ErrDropped
+	dropped bool
 }
 
 type node struct {
 	key   string
-	value string
+	value string // gzip compressed if compressed is set
 	next  *node
 	prev  *node
+
+	compressed bool
+
+	// Used by the LFU eviction policy only
+	freq     int
+	freqNext *node
+	freqPrev *node
 }
 
 // KeyValue represents a key-value pair
@@ -28,51 +55,115 @@ type KeyValue struct {
 
 // New creates a new OrderedMap
 func NewOrderedMap() *OrderedMap {
-	return &OrderedMap{
-		data: make(map[string]*node),
+	return NewOrderedMapWithLimits(Limits{})
+}
+
+// NewOrderedMapWithLimits creates a new OrderedMap that evicts entries
+// according to the policy once one of the limits is exceeded
+func NewOrderedMapWithLimits(limits Limits) *OrderedMap {
+	if limits.Policy == "" {
+		limits.Policy = EvictOldest
+	}
+
+	om := &OrderedMap{
+		data:   make(map[string]*node),
+		limits: limits,
+	}
+	switch limits.Policy {
+	case EvictLFU:
+		om.evictor = newLFUEvictor()
+		om.trackAccess = true
+	case EvictLRU:
+		om.evictor = &orderEvictor{om: om, moveOnAccess: true}
+		om.trackAccess = true
+	default:
+		om.evictor = &orderEvictor{om: om}
 	}
+	return om
 }
 
 // Add adds or updates a key-value pair in O(1) time
 func (om *OrderedMap) Add(key, value string) {
 	om.mu.Lock()
 	defer om.mu.Unlock()
+	om.add(key, value)
+}
 
+func (om *OrderedMap) add(key, value string) {
+	if om.dropped {
+		return
+	}
 	if existingNode, exists := om.data[key]; exists {
 		// Update existing node
-		existingNode.value = value
+		om.update(existingNode, value)
 		return
 	}
 
 	// Create new node
-	newNode := &node{
-		key:   key,
-		value: value,
-	}
+	newNode := om.newNode(key, value)
 
-	// Add to map
-	om.data[key] = newNode
+	// Make room for the new node
+	om.makeRoom(1, entrySize(key, newNode.value))
 
 	// Add to linked list
-	if om.head == nil {
-		om.head = newNode
-		om.tail = newNode
-	} else {
-		om.tail.next = newNode
-		newNode.prev = om.tail
-		om.tail = newNode
+	om.pushBack(newNode)
+
+	// Add to map
+	om.store(newNode)
+}
+
+// Update atomically replaces the value of key with the result of fn under the
+// write lock. fn receives the current value and whether the key exists; a
+// missing key is added at the back. Nothing is changed if fn returns an error.
+func (om *OrderedMap) Update(key string, fn UpdateFunc) (string, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	return om.updateKey(key, fn)
+}
+
+func (om *OrderedMap) updateKey(key string, fn UpdateFunc) (string, error) {
+	if om.dropped {
+		return "", ErrDropped
+	}
+	existingNode, exists := om.data[key]
+	current := ""
+	if exists {
+		current = existingNode.load()
 	}
 
-	om.size++
+	value, err := fn(current, exists)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		om.update(existingNode, value)
+		return value, nil
+	}
+
+	newNode := om.newNode(key, value)
+	om.makeRoom(1, entrySize(key, newNode.value))
+	om.pushBack(newNode)
+	om.store(newNode)
+	return value, nil
 }
 
 // Get retrieves a value by key in O(1) time
 func (om *OrderedMap) Get(key string) (string, bool) {
-	om.mu.RLock()
-	defer om.mu.RUnlock()
+	// LRU and LFU policies update their bookkeeping on access
+	if om.trackAccess {
+		om.mu.Lock()
+		defer om.mu.Unlock()
+	} else {
+		om.mu.RLock()
+		defer om.mu.RUnlock()
+	}
 
 	if node, exists := om.data[key]; exists {
-		return node.value, true
+		if om.trackAccess {
+			om.evictor.accessed(node)
+		}
+		return node.load(), true
 	}
 	return "", false
 }
@@ -81,32 +172,134 @@ func (om *OrderedMap) Get(key string) (string, bool) {
 func (om *OrderedMap) Delete(key string) bool {
 	om.mu.Lock()
 	defer om.mu.Unlock()
+	return om.delete(key)
+}
 
+func (om *OrderedMap) delete(key string) bool {
+	if om.dropped {
+		return false
+	}
 	node, exists := om.data[key]
 	if !exists {
 		return false
 	}
 
-	// Remove from map
-	delete(om.data, key)
+	om.remove(node, OpDelete)
+	return true
+}
 
-	// Remove from linked list
-	if node.prev != nil {
-		node.prev.next = node.next
-	} else {
-		om.head = node.next
+// MoveToFront moves an existing key to the front of the order in O(1) time
+func (om *OrderedMap) MoveToFront(key string) bool {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	return om.moveToFront(key)
+}
+
+func (om *OrderedMap) moveToFront(key string) bool {
+	if om.dropped {
+		return false
+	}
+	node, exists := om.data[key]
+	if !exists {
+		return false
+	}
+
+	om.unlink(node)
+	om.pushFront(node)
+	om.emitMove(key, PositionFront, "")
+	return true
+}
+
+// MoveToBack moves an existing key to the back of the order in O(1) time
+func (om *OrderedMap) MoveToBack(key string) bool {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	return om.moveToBack(key)
+}
+
+func (om *OrderedMap) moveToBack(key string) bool {
+	if om.dropped {
+		return false
+	}
+	node, exists := om.data[key]
+	if !exists {
+		return false
 	}
 
-	if node.next != nil {
-		node.next.prev = node.prev
+	om.unlink(node)
+	om.pushBack(node)
+	om.emitMove(key, PositionBack, "")
+	return true
+}
+
+// InsertBefore places key right before mark in O(1) time. An existing key is
+// updated and moved. Returns false if mark does not exist or equals key.
+func (om *OrderedMap) InsertBefore(mark, key, value string) bool {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	return om.insertBefore(mark, key, value)
+}
+
+func (om *OrderedMap) insertBefore(mark, key, value string) bool {
+	markNode, target, ok := om.prepareInsert(mark, key, value)
+	if !ok {
+		return false
+	}
+
+	target.prev = markNode.prev
+	target.next = markNode
+	if markNode.prev != nil {
+		markNode.prev.next = target
 	} else {
-		om.tail = node.prev
+		om.head = target
 	}
+	markNode.prev = target
+	om.emitMove(key, PositionBefore, mark)
+	return true
+}
 
-	om.size--
+// InsertAfter places key right after mark in O(1) time. An existing key is
+// updated and moved. Returns false if mark does not exist or equals key.
+func (om *OrderedMap) InsertAfter(mark, key, value string) bool {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	return om.insertAfter(mark, key, value)
+}
+
+func (om *OrderedMap) insertAfter(mark, key, value string) bool {
+	markNode, target, ok := om.prepareInsert(mark, key, value)
+	if !ok {
+		return false
+	}
+
+	target.next = markNode.next
+	target.prev = markNode
+	if markNode.next != nil {
+		markNode.next.prev = target
+	} else {
+		om.tail = target
+	}
+	markNode.next = target
+	om.emitMove(key, PositionAfter, mark)
 	return true
 }
 
+// PopFirst removes and returns the first key-value pair in O(1) time
+func (om *OrderedMap) PopFirst() (KeyValue, bool) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return om.pop(om.head)
+}
+
+// PopLast removes and returns the last key-value pair in O(1) time
+func (om *OrderedMap) PopLast() (KeyValue, bool) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	return om.pop(om.tail)
+}
+
 // GetAll returns all key-value pairs in insertion order
 func (om *OrderedMap) GetAll() []KeyValue {
 	om.mu.RLock()
@@ -118,7 +311,7 @@ func (om *OrderedMap) GetAll() []KeyValue {
 	for current != nil {
 		result = append(result, KeyValue{
 			Key:   current.key,
-			Value: current.value,
+			Value: current.load(),
 		})
 		current = current.next
 	}
@@ -126,9 +319,237 @@ func (om *OrderedMap) GetAll() []KeyValue {
 	return result
 }
 
+// GetPage returns up to limit pairs in insertion order, following the key
+// after or from the front if after is empty. It returns false if after is
+// not a key, e.g. deleted since the previous page. Keys added meanwhile are
+// not skipped or repeated, unless they are inserted before after.
+func (om *OrderedMap) GetPage(after string, limit int) ([]KeyValue, bool) {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	current := om.head
+	if after != "" {
+		node, exists := om.data[after]
+		if !exists {
+			return nil, false
+		}
+		current = node.next
+	}
+
+	result := make([]KeyValue, 0, min(limit, om.size))
+	for ; current != nil && len(result) < limit; current = current.next {
+		result = append(result, KeyValue{Key: current.key, Value: current.load()})
+	}
+	return result, true
+}
+
 // Size returns the number of elements
 func (om *OrderedMap) Size() int {
 	om.mu.RLock()
 	defer om.mu.RUnlock()
 	return om.size
 }
+
+// prepareInsert resolves the mark node and returns a detached node for key,
+// creating it if needed. Must be called with the write lock held.
+func (om *OrderedMap) prepareInsert(mark, key, value string) (*node, *node, bool) {
+	if mark == key || om.dropped {
+		return nil, nil, false
+	}
+	markNode, exists := om.data[mark]
+	if !exists {
+		return nil, nil, false
+	}
+
+	if target, exists := om.data[key]; exists {
+		om.update(target, value, markNode)
+		om.unlink(target)
+		return markNode, target, true
+	}
+
+	target := om.newNode(key, value)
+	om.makeRoom(1, entrySize(key, target.value), markNode)
+	om.store(target)
+	return markNode, target, true
+}
+
+// pop removes the node from the map and the list
+func (om *OrderedMap) pop(n *node) (KeyValue, bool) {
+	if n == nil || om.dropped {
+		return KeyValue{}, false
+	}
+
+	value := n.load()
+	om.remove(n, OpDelete)
+	return KeyValue{Key: n.key, Value: value}, true
+}
+
+// Dropped tells whether the namespace of the map was dropped
+func (om *OrderedMap) Dropped() bool {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+	return om.dropped
+}
+
+// drop marks the map dropped, so holders can no longer change it
+func (om *OrderedMap) drop() {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	om.dropped = true
+}
+
+// MemoryStats returns the memory usage, limits and eviction count
+func (om *OrderedMap) MemoryStats() MemoryStats {
+	om.mu.RLock()
+	defer om.mu.RUnlock()
+
+	return MemoryStats{
+		Entries:     om.size,
+		MemoryBytes: om.memory,
+		MaxEntries:  om.limits.MaxEntries,
+		MaxMemory:   om.limits.MaxMemory,
+		Policy:      om.limits.Policy,
+		Evictions:   om.evictions,
+	}
+}
+
+// store adds a linked node to the map and the accounting
+func (om *OrderedMap) store(n *node) {
+	om.data[n.key] = n
+	om.size++
+	om.memory += entrySize(n.key, n.value)
+	om.evictor.added(n)
+	if om.reporting() {
+		om.emit(OpAdd, n.key, "", n.load())
+	}
+}
+
+// update replaces the value of a node, evicting others if it grows
+func (om *OrderedMap) update(n *node, value string, protect ...*node) {
+	stored, compressed := om.pack(value)
+	om.makeRoom(0, int64(len(stored)-len(n.value)), append(protect, n)...)
+	om.memory += int64(len(stored) - len(n.value))
+	if om.reporting() {
+		om.emit(OpUpdate, n.key, n.load(), value)
+	}
+	n.value, n.compressed = stored, compressed
+	om.evictor.accessed(n)
+}
+
+// remove deletes a node from the map, the list and the accounting
+func (om *OrderedMap) remove(n *node, op ChangeOp) {
+	delete(om.data, n.key)
+	om.unlink(n)
+	om.size--
+	om.memory -= entrySize(n.key, n.value)
+	om.evictor.removed(n)
+	if om.reporting() {
+		om.emit(op, n.key, n.load(), "")
+	}
+}
+
+// reporting tells whether mutations are reported to a feed or observer
+func (om *OrderedMap) reporting() bool {
+	return om.feed != nil || om.observer != nil
+}
+
+// emitMove reports a reordering of key to the change feed and observer
+func (om *OrderedMap) emitMove(key string, position Position, mark string) {
+	om.report(ChangeEvent{
+		Op:        OpMove,
+		Namespace: om.namespace,
+		Key:       key,
+		Position:  position,
+		Mark:      mark,
+	})
+}
+
+// emit reports a mutation to the change feed and observer
+func (om *OrderedMap) emit(op ChangeOp, key, oldValue, newValue string) {
+	om.report(ChangeEvent{
+		Op:        op,
+		Namespace: om.namespace,
+		Key:       key,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+	})
+}
+
+func (om *OrderedMap) report(event ChangeEvent) {
+	if om.observer != nil {
+		om.observer(event)
+	}
+	if om.feed != nil {
+		om.feed.emit(event)
+	}
+}
+
+// makeRoom evicts entries until the given number of entries and bytes fit
+// into the limits. Protected nodes are never evicted; eviction stops when
+// one becomes the victim, so limits are approximate.
+func (om *OrderedMap) makeRoom(entries int, bytes int64, protect ...*node) {
+	for om.overLimits(entries, bytes) {
+		victim := om.evictor.victim()
+		if victim == nil {
+			return
+		}
+		for _, p := range protect {
+			if victim == p {
+				return
+			}
+		}
+		om.remove(victim, OpEvict)
+		om.evictions++
+	}
+}
+
+// overLimits reports whether adding entries and bytes exceeds the limits
+func (om *OrderedMap) overLimits(entries int, bytes int64) bool {
+	if om.limits.MaxEntries > 0 && om.size+entries > om.limits.MaxEntries {
+		return true
+	}
+	if om.limits.MaxMemory > 0 && om.memory+bytes > om.limits.MaxMemory {
+		return true
+	}
+	return false
+}
+
+// pushFront links a detached node at the head of the list
+func (om *OrderedMap) pushFront(n *node) {
+	n.prev = nil
+	n.next = om.head
+	if om.head != nil {
+		om.head.prev = n
+	} else {
+		om.tail = n
+	}
+	om.head = n
+}
+
+// pushBack links a detached node at the tail of the list
+func (om *OrderedMap) pushBack(n *node) {
+	n.next = nil
+	n.prev = om.tail
+	if om.tail != nil {
+		om.tail.next = n
+	} else {
+		om.head = n
+	}
+	om.tail = n
+}
+
+// unlink detaches a node from the list
+func (om *OrderedMap) unlink(n *node) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		om.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		om.tail = n.prev
+	}
+	n.prev = nil
+	n.next = nil
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -132,6 +133,43 @@ func TestGetAll(t *testing.T) {
 	}
 }
 
+func TestGetPage(t *testing.T) {
+	om := NewOrderedMap()
+	for _, key := range []string{"key1", "key2", "key3"} {
+		om.Add(key, "value")
+	}
+
+	tests := []struct {
+		after    string
+		limit    int
+		wantKeys []string
+		wantOK   bool
+	}{
+		{"", 2, []string{"key1", "key2"}, true},
+		{"key2", 2, []string{"key3"}, true},
+		{"key3", 2, []string{}, true},
+		{"", 10, []string{"key1", "key2", "key3"}, true},
+		{"missing", 2, nil, false},
+	}
+	for _, tt := range tests {
+		page, ok := om.GetPage(tt.after, tt.limit)
+		keys := []string{}
+		for _, item := range page {
+			keys = append(keys, item.Key)
+		}
+		if ok != tt.wantOK || (ok && !reflect.DeepEqual(keys, tt.wantKeys)) {
+			t.Errorf("GetPage(%q, %d) = %v, %v, want %v, %v", tt.after, tt.limit, keys, ok, tt.wantKeys, tt.wantOK)
+		}
+	}
+
+	// Keys added after a page was read are on the following pages
+	page, _ := om.GetPage("", 2)
+	om.Add("key4", "value")
+	if rest, _ := om.GetPage(page[len(page)-1].Key, 10); len(rest) != 2 || rest[1].Key != "key4" {
+		t.Errorf("GetPage() after add = %v, want key3 and key4", rest)
+	}
+}
+
 // TestSize tests the size tracking functionality
 func TestSize(t *testing.T) {
 	om := NewOrderedMap()
@@ -152,6 +190,155 @@ func TestSize(t *testing.T) {
 	}
 }
 
+// keysOf returns the keys of the map in order
+func keysOf(om *OrderedMap) []string {
+	keys := []string{}
+	for _, item := range om.GetAll() {
+		keys = append(keys, item.Key)
+	}
+	return keys
+}
+
+// TestMoveToFrontAndBack tests reordering existing keys
+func TestMoveToFrontAndBack(t *testing.T) {
+	om := NewOrderedMap()
+
+	if om.MoveToFront("missing") || om.MoveToBack("missing") {
+		t.Error("Expected move of non-existent key to return false")
+	}
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Add("key3", "value3")
+
+	if !om.MoveToFront("key3") {
+		t.Error("Expected MoveToFront to return true for existing key")
+	}
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key3", "key1", "key2"}) {
+		t.Errorf("Unexpected order after MoveToFront: %v", keys)
+	}
+
+	if !om.MoveToBack("key3") {
+		t.Error("Expected MoveToBack to return true for existing key")
+	}
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key1", "key2", "key3"}) {
+		t.Errorf("Unexpected order after MoveToBack: %v", keys)
+	}
+
+	// Moving the only element keeps head and tail consistent
+	single := NewOrderedMap()
+	single.Add("key1", "value1")
+	single.MoveToFront("key1")
+	single.MoveToBack("key1")
+	if single.head != single.tail || single.head == nil {
+		t.Error("Expected head and tail to be the single node")
+	}
+}
+
+// TestInsertBeforeAndAfter tests positional inserts of new and existing keys
+func TestInsertBeforeAndAfter(t *testing.T) {
+	om := NewOrderedMap()
+
+	if om.InsertBefore("missing", "key1", "value1") {
+		t.Error("Expected InsertBefore with missing mark to return false")
+	}
+
+	om.Add("key1", "value1")
+	if om.InsertAfter("key1", "key1", "value2") {
+		t.Error("Expected InsertAfter with key equal to mark to return false")
+	}
+
+	om.InsertBefore("key1", "key0", "value0")
+	om.InsertAfter("key1", "key3", "value3")
+	om.InsertAfter("key1", "key2", "value2")
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key0", "key1", "key2", "key3"}) {
+		t.Errorf("Unexpected order after inserts: %v", keys)
+	}
+
+	// Inserting an existing key moves and updates it
+	om.InsertBefore("key0", "key3", "updated")
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key3", "key0", "key1", "key2"}) {
+		t.Errorf("Unexpected order after moving insert: %v", keys)
+	}
+	if val, _ := om.Get("key3"); val != "updated" {
+		t.Errorf("Expected key3=updated, got %s", val)
+	}
+	if om.Size() != 4 {
+		t.Errorf("Expected size 4, got %d", om.Size())
+	}
+}
+
+// TestPopFirstAndLast tests removing items from both ends
+func TestPopFirstAndLast(t *testing.T) {
+	om := NewOrderedMap()
+
+	if _, ok := om.PopFirst(); ok {
+		t.Error("Expected PopFirst on empty map to return false")
+	}
+	if _, ok := om.PopLast(); ok {
+		t.Error("Expected PopLast on empty map to return false")
+	}
+
+	om.Add("key1", "value1")
+	om.Add("key2", "value2")
+	om.Add("key3", "value3")
+
+	if item, ok := om.PopFirst(); !ok || item != (KeyValue{Key: "key1", Value: "value1"}) {
+		t.Errorf("Expected PopFirst to return key1, got %v", item)
+	}
+	if item, ok := om.PopLast(); !ok || item != (KeyValue{Key: "key3", Value: "value3"}) {
+		t.Errorf("Expected PopLast to return key3, got %v", item)
+	}
+	if _, exists := om.Get("key1"); exists {
+		t.Error("Expected popped key to be removed")
+	}
+	if om.Size() != 1 {
+		t.Errorf("Expected size 1, got %d", om.Size())
+	}
+
+	om.PopLast()
+	if om.head != nil || om.tail != nil {
+		t.Error("Expected head and tail to be nil after popping all items")
+	}
+}
+
+// TestUpdate tests atomic read-modify-write updates
+func TestUpdate(t *testing.T) {
+	om := NewOrderedMap()
+
+	value, err := om.Update("key1", func(current string, exists bool) (string, error) {
+		if exists {
+			t.Error("Expected key1 to not exist")
+		}
+		return current + "a", nil
+	})
+	if err != nil || value != "a" {
+		t.Errorf("Expected value a, got %s (err %v)", value, err)
+	}
+
+	om.Add("key2", "value2")
+	value, _ = om.Update("key1", func(current string, exists bool) (string, error) {
+		return current + "b", nil
+	})
+	if value != "ab" {
+		t.Errorf("Expected value ab, got %s", value)
+	}
+	if keys := keysOf(om); !reflect.DeepEqual(keys, []string{"key1", "key2"}) {
+		t.Errorf("Expected update to keep order, got %v", keys)
+	}
+
+	// A failed update leaves the map untouched
+	_, err = om.Update("key3", func(current string, exists bool) (string, error) {
+		return "", errors.New("invalid")
+	})
+	if err == nil {
+		t.Error("Expected error from failed update")
+	}
+	if _, exists := om.Get("key3"); exists || om.Size() != 2 {
+		t.Error("Expected failed update to not add key3")
+	}
+}
+
 // TestConcurrentAccess tests thread-safety with concurrent operations
 func TestConcurrentAccess(t *testing.T) {
 	om := NewOrderedMap()
@@ -1,8 +1,21 @@
 package storage
 
+// UpdateFunc computes a new value from the current one for atomic updates
+type UpdateFunc func(current string, exists bool) (string, error)
+
 type Storage interface {
 	Add(key string, value string)
 	Get(key string) (string, bool)
+	Update(key string, fn UpdateFunc) (string, error)
 	Delete(key string) bool
 	GetAll() []KeyValue
+	GetPage(after string, limit int) ([]KeyValue, bool)
+	Size() int
+	MoveToFront(key string) bool
+	MoveToBack(key string) bool
+	InsertBefore(mark string, key string, value string) bool
+	InsertAfter(mark string, key string, value string) bool
+	PopFirst() (KeyValue, bool)
+	PopLast() (KeyValue, bool)
+	MemoryStats() MemoryStats
 }